@@ -1,17 +1,47 @@
 package auth
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 )
 
 // Custom Errors
 const (
-	ErrUnauthorized = "Unauthorized (Failed Authentication)"
+	ErrUnauthorized  = "Unauthorized (Failed Authentication)"
+	ErrAPIKeysFormat = "API keys must be provided as name=key pairs separated by commas: %s"
 )
 
+// DefaultActor - the actor name reported for requests authenticated with APIKey
+const DefaultActor = "default"
+
 // APIKey - Authentication used by the service
 var APIKey string = ""
 
+// APIKeys - additional named keys accepted by the service, indexed by key with the name as the value
+var APIKeys = map[string]string{}
+
+// ParseAPIKeys - parse a list of name=key pairs separated by commas into a map suitable for APIKeys
+func ParseAPIKeys(value string) (map[string]string, error) {
+	result := make(map[string]string)
+
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		segs := strings.SplitN(pair, "=", 2)
+		if len(segs) < 2 || segs[0] == "" || segs[1] == "" {
+			return nil, fmt.Errorf(ErrAPIKeysFormat, pair)
+		}
+
+		result[segs[1]] = segs[0]
+	}
+
+	return result, nil
+}
+
 // Authenticate - perform the authentication check
 func Authenticate(r *http.Request) bool {
 	result := false
@@ -19,5 +49,20 @@ func Authenticate(r *http.Request) bool {
 	if apiKey == APIKey {
 		result = true
 	}
+	if _, ok := APIKeys[apiKey]; ok {
+		result = true
+	}
 	return result
 }
+
+// Actor - the name of the key used to authenticate the request, empty if the request is not authenticated
+func Actor(r *http.Request) string {
+	apiKey := r.Header.Get("X-API-Key")
+	if name, ok := APIKeys[apiKey]; ok {
+		return name
+	}
+	if apiKey == APIKey {
+		return DefaultActor
+	}
+	return ""
+}
@@ -0,0 +1,194 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// Audit Actions
+const (
+	AuditActionCreate         = "create"
+	AuditActionUpdate         = "update"
+	AuditActionUpdatePassword = "update_password"
+	AuditActionDelete         = "delete"
+	AuditActionRestore        = "restore"
+)
+
+// Audit Entities
+const (
+	AuditEntityFTPAccount = "ftp_account"
+	AuditEntityMapping    = "ftp_mapping"
+	AuditEntitySystem     = "ftp_system"
+)
+
+// AuditEntry - type used to represent an entry in the audit log
+// - Before and After are JSON snapshots of the entity, absent on create and delete respectively
+type AuditEntry struct {
+	ID         uint64          `json:"id,omitempty"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Actor      string          `json:"actor,omitempty"`
+	Action     string          `json:"action,omitempty"`
+	Entity     string          `json:"entity,omitempty"`
+	EntityID   string          `json:"entity_id,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+}
+
+// AuditEntries - type used to return a collection of AuditEntry structs
+type AuditEntries struct {
+	Entries    []AuditEntry `json:"entries,omitempty"`
+	TotalItems uint32       `json:"total_items,omitempty"`
+	TotalPages uint32       `json:"total_pages,omitempty"`
+}
+
+// AuditFilter - type used to narrow the result set of AuditGetSelection
+// - zero values are ignored
+type AuditFilter struct {
+	Actor    string
+	Action   string
+	Entity   string
+	EntityID string
+	Since    time.Time
+	Until    time.Time
+}
+
+// nullableJSON - store empty snapshots as null
+func nullableJSON(snapshot json.RawMessage) sql.NullString {
+	if len(snapshot) == 0 {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(snapshot), Valid: true}
+}
+
+// AuditCreate - append an entry to the audit log, occurred_at is set by the database
+func (db *Database) AuditCreate(ctx context.Context, entry AuditEntry) error {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
+		return dbErr
+	}
+
+	qry := "insert into `ftp_audit_log` (`actor`, `action`, `entity`, `entity_id`, `before_state`, `after_state`, `request_id`) values (?, ?, ?, ?, ?, ?, ?)"
+
+	_, err := db.ExecForDriver(ctx, qry, entry.Actor, entry.Action, entry.Entity, entry.EntityID, nullableJSON(entry.Before), nullableJSON(entry.After), entry.RequestID)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// AuditGetSelection - retrieve the audit log entries matching filter, most recent first
+// - for the specified page and result set size (default page=1 and page_size=30)
+// - a 1 based page index is used
+func (db *Database) AuditGetSelection(ctx context.Context, page uint32, pageSize uint32, filter AuditFilter) (entries AuditEntries, err error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if err = db.checkDBConnection(ctx); err != nil {
+		return
+	}
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	if filter.Actor != "" {
+		conditions = append(conditions, "`actor` = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "`action` = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Entity != "" {
+		conditions = append(conditions, "`entity` = ?")
+		args = append(args, filter.Entity)
+	}
+	if filter.EntityID != "" {
+		conditions = append(conditions, "`entity_id` = ?")
+		args = append(args, filter.EntityID)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "`occurred_at` >= ?")
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "`occurred_at` < ?")
+		args = append(args, filter.Until)
+	}
+
+	filterClause := ""
+	if len(conditions) > 0 {
+		filterClause = " where " + strings.Join(conditions, " and ")
+	}
+
+	qry := "select count(`id`) from `ftp_audit_log`" + filterClause
+
+	err = db.QueryRowForDriver(ctx, qry, args...).Scan(&entries.TotalItems)
+	if err != nil {
+		log.Error(err.Error())
+		return entries, err
+	}
+
+	// set default page and page_size if not provided
+	if pageSize == 0 {
+		pageSize = 30
+	}
+	if page == 0 {
+		page = 1
+	}
+
+	offset := (page - 1) * pageSize
+
+	entries.TotalPages = entries.TotalItems / pageSize
+	if entries.TotalItems%pageSize > 0 {
+		entries.TotalPages++
+	}
+
+	qry = "select `id`, `occurred_at`, `actor`, `action`, `entity`, `entity_id`, `before_state`, `after_state`, `request_id` from `ftp_audit_log`"
+	qry += filterClause + " order by `occurred_at` desc, `id` desc"
+	qry += db.dialect().LimitOffset(pageSize, offset)
+
+	results, err := db.QueryForDriver(ctx, qry, args...)
+	if err != nil {
+		log.Error(err.Error())
+		return entries, err
+	}
+	defer results.Close()
+
+	for results.Next() {
+		var (
+			entry         AuditEntry
+			before, after sql.NullString
+		)
+		err = results.Scan(&entry.ID, &entry.OccurredAt, &entry.Actor, &entry.Action, &entry.Entity, &entry.EntityID, &before, &after, &entry.RequestID)
+		if err != nil {
+			log.Error(err.Error())
+			return entries, err
+		}
+		if before.Valid {
+			entry.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			entry.After = json.RawMessage(after.String)
+		}
+		entries.Entries = append(entries.Entries, entry)
+	}
+
+	err = results.Err()
+	if err != nil {
+		log.Error(err.Error())
+		return entries, err
+	}
+
+	return entries, nil
+}
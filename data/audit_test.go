@@ -0,0 +1,82 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAuditCreate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf(errDBConnectionError, err)
+	}
+	defer db.Close()
+
+	dBase := &Database{DB: db}
+
+	insQuery := "insert into [`\"]ftp_audit_log[`\"] \\([`\"]actor[`\"], [`\"]action[`\"], [`\"]entity[`\"], [`\"]entity_id[`\"], [`\"]before_state[`\"], [`\"]after_state[`\"], [`\"]request_id[`\"]\\) values \\((\\?|\\$1), (\\?|\\$2), (\\?|\\$3), (\\?|\\$4), (\\?|\\$5), (\\?|\\$6), (\\?|\\$7)\\)"
+
+	entry := AuditEntry{
+		Actor:     "admin-ui",
+		Action:    AuditActionCreate,
+		Entity:    AuditEntityFTPAccount,
+		EntityID:  "1",
+		After:     json.RawMessage(`{"id":1,"username":"Test User 1"}`),
+		RequestID: "1",
+	}
+
+	ex := mock.ExpectExec(insQuery)
+	ex.WithArgs(entry.Actor, entry.Action, entry.Entity, entry.EntityID, nil, string(entry.After), entry.RequestID)
+	ex.WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err = dBase.AuditCreate(context.Background(), entry)
+	if err != nil {
+		t.Errorf("unexpected error from AuditCreate %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+func TestAuditGetSelection(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf(errDBConnectionError, err)
+	}
+	defer db.Close()
+
+	dBase := &Database{DB: db}
+
+	filterClause := " where [`\"]actor[`\"] = (\\?|\\$1) and [`\"]entity[`\"] = (\\?|\\$2)"
+	cntQuery := "select count\\([`\"]id[`\"]\\) from [`\"]ftp_audit_log[`\"]" + filterClause
+	selQuery := "select [`\"]id[`\"], [`\"]occurred_at[`\"], [`\"]actor[`\"], [`\"]action[`\"], [`\"]entity[`\"], [`\"]entity_id[`\"], [`\"]before_state[`\"], [`\"]after_state[`\"], [`\"]request_id[`\"] from [`\"]ftp_audit_log[`\"]"
+	selQuery += filterClause + " order by [`\"]occurred_at[`\"] desc, [`\"]id[`\"] desc"
+
+	occurredAt := time.Date(2022, 5, 4, 10, 15, 0, 0, time.UTC)
+	cntRows := sqlmock.NewRows([]string{"count"}).AddRow(1)
+	selRows := sqlmock.NewRows([]string{"id", "occurred_at", "actor", "action", "entity", "entity_id", "before_state", "after_state", "request_id"})
+	selRows = selRows.AddRow(1, occurredAt, "admin-ui", AuditActionDelete, AuditEntityFTPAccount, "1", `{"id":1}`, nil, "1")
+
+	mock.ExpectQuery(cntQuery).WithArgs("admin-ui", AuditEntityFTPAccount).WillReturnRows(cntRows)
+	mock.ExpectQuery(selQuery).WithArgs("admin-ui", AuditEntityFTPAccount).WillReturnRows(selRows)
+
+	entries, err := dBase.AuditGetSelection(context.Background(), 0, 0, AuditFilter{Actor: "admin-ui", Entity: AuditEntityFTPAccount})
+	if err != nil {
+		t.Errorf("unexpected error from AuditGetSelection %s", err)
+	}
+	if len(entries.Entries) != 1 {
+		t.Fatalf("%d entries returned.  expected 1", len(entries.Entries))
+	}
+	if string(entries.Entries[0].Before) != `{"id":1}` {
+		t.Errorf("unexpected before snapshot %s", entries.Entries[0].Before)
+	}
+	if entries.Entries[0].After != nil {
+		t.Errorf("unexpected after snapshot %s", entries.Entries[0].After)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
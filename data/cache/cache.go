@@ -0,0 +1,301 @@
+// Package cache provides a data.Datastore that keeps recent login lookups in memory
+//
+// The Cache wraps another Datastore and answers repeated logins for the same username without
+// the lookup query.  It keeps the lookup user without its password together with a keyed hash
+// of the password, so the cache never holds a password, and drops entries when the writes that
+// change them are made through it.  When the store is a data.ChangeNotifier those writes are
+// passed to the caches of the other instances sharing it, see Notify and Watch.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	sftpgo "github.com/drakkan/sftpgo/v2/dataprovider"
+	"github.com/drakkan/sftpgo/v2/vfs"
+	"github.com/halt-joe/ftp-user-svc/data"
+	"github.com/halt-joe/ftp-user-svc/metrics"
+	log "github.com/inconshreveable/log15"
+)
+
+// now - the current time, replaced by tests
+var now = time.Now
+
+// entry - a cached lookup user and the verifier of its password
+type entry struct {
+	username string
+	id       uint32
+	user     sftpgo.User
+	verifier []byte
+	expires  time.Time
+}
+
+// Cache - a data.Datastore that keeps the most recently used FtpUserLookup results for logins
+// - at most size entries are kept, each for at most ttl
+// - FtpUserUpdate, FtpUserUpdatePassword and FtpUserDelete drop the entries of the account,
+// MappingCreate and MappingDelete in data.LookupSystem drop every entry as a mapping can move between accounts
+// - writes made in a transaction drop entries when it is committed
+// - writes are notified to the other instances through Notify when the store is a data.ChangeNotifier
+type Cache struct {
+	data.Datastore
+
+	notifier data.ChangeNotifier
+
+	size int
+	ttl  time.Duration
+	key  []byte
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	ids     map[uint32]map[string]struct{}
+	order   *list.List
+
+	// generation - incremented by every invalidation, so a lookup that overlaps one is not cached
+	generation uint64
+}
+
+// compile time checks that Cache satisfies the interfaces used by the handlers
+var (
+	_ data.Datastore     = (*Cache)(nil)
+	_ data.LoginVerifier = (*Cache)(nil)
+)
+
+// New - a Cache of up to size lookups kept for ttl in front of store, notifying its writes with Notify
+func New(store data.Datastore, size int, ttl time.Duration) *Cache {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic("cache: unable to generate a verifier key: " + err.Error())
+	}
+
+	store = Notify(store)
+	notifier, _ := store.(data.ChangeNotifier)
+
+	return &Cache{
+		Datastore: store,
+		notifier:  notifier,
+		size:      size,
+		ttl:       ttl,
+		key:       key,
+		entries:   make(map[string]*list.Element),
+		ids:       make(map[uint32]map[string]struct{}),
+		order:     list.New(),
+	}
+}
+
+// verifier - the keyed hash of password
+func (c *Cache) verifier(password string) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+// FtpUserVerify - the lookup user for username, without its Password, and whether password is theirs
+// - a password that does not match a cached entry is checked against the store, in case it was changed elsewhere
+func (c *Cache) FtpUserVerify(ctx context.Context, username string, password string) (sftpgo.User, bool, error) {
+	if user, verifier, ok := c.get(username); ok {
+		if hmac.Equal(verifier, c.verifier(password)) {
+			metrics.IncLoginCache(metrics.LoginCacheHit)
+			return user, true, nil
+		}
+		c.remove(username)
+	}
+	metrics.IncLoginCache(metrics.LoginCacheMiss)
+
+	c.mu.Lock()
+	generation := c.generation
+	c.mu.Unlock()
+
+	user, err := c.Datastore.FtpUserLookup(ctx, username)
+	if err != nil {
+		return user, false, err
+	}
+
+	matched := user.Password == password
+	user.Password = ""
+
+	if matched {
+		c.put(username, user, c.verifier(password), generation)
+	}
+
+	return copyUser(user), matched, nil
+}
+
+// get - the unexpired entry for username
+func (c *Cache) get(username string) (sftpgo.User, []byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[username]
+	if !ok {
+		return sftpgo.User{}, nil, false
+	}
+
+	e := element.Value.(*entry)
+	if now().After(e.expires) {
+		c.removeElement(element)
+		return sftpgo.User{}, nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return copyUser(e.user), e.verifier, true
+}
+
+// put - keep user for username unless an invalidation has happened since generation, evicting the least recently used entry when full
+func (c *Cache) put(username string, user sftpgo.User, verifier []byte, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation != generation {
+		return
+	}
+
+	if element, ok := c.entries[username]; ok {
+		c.removeElement(element)
+	}
+	for c.order.Len() >= c.size && c.order.Len() > 0 {
+		c.removeElement(c.order.Back())
+	}
+
+	e := &entry{username: username, id: uint32(user.ID), user: user, verifier: verifier, expires: now().Add(c.ttl)}
+	c.entries[username] = c.order.PushFront(e)
+	if c.ids[e.id] == nil {
+		c.ids[e.id] = make(map[string]struct{})
+	}
+	c.ids[e.id][username] = struct{}{}
+}
+
+// remove - drop the entry for username
+func (c *Cache) remove(username string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if element, ok := c.entries[username]; ok {
+		c.removeElement(element)
+	}
+}
+
+// removeElement - drop element from the entries, the id index and the use order
+func (c *Cache) removeElement(element *list.Element) {
+	e := element.Value.(*entry)
+	delete(c.entries, e.username)
+	delete(c.ids[e.id], e.username)
+	if len(c.ids[e.id]) == 0 {
+		delete(c.ids, e.id)
+	}
+	c.order.Remove(element)
+}
+
+// InvalidateAccount - drop every entry of the account with id
+// - entries are keyed by the username the client sent, so a database comparing usernames without case
+// can hold one entry of the account for each spelling, e.g. "Bob" and "bob"
+func (c *Cache) InvalidateAccount(id uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for username := range c.ids[id] {
+		c.removeElement(c.entries[username])
+	}
+}
+
+// InvalidateAll - drop every entry
+func (c *Cache) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.entries = make(map[string]*list.Element)
+	c.ids = make(map[uint32]map[string]struct{})
+	c.order.Init()
+}
+
+// invalidateMapping - drop every entry when a mapping of system changes, the lookup only reads data.LookupSystem
+func (c *Cache) invalidateMapping(system string) {
+	if system == data.LookupSystem {
+		c.InvalidateAll()
+	}
+}
+
+// apply - drop the entries change makes stale
+func (c *Cache) apply(change data.Change) {
+	switch {
+	case change.FTPAccountID != 0:
+		c.InvalidateAccount(change.FTPAccountID)
+	case change.System != "":
+		c.invalidateMapping(change.System)
+	default:
+		c.InvalidateAll()
+	}
+}
+
+// changed - drop the entries change makes stale here, the store beneath notifies the other instances
+func (c *Cache) changed(change data.Change) {
+	if change.System != "" && change.System != data.LookupSystem {
+		return
+	}
+
+	c.apply(change)
+}
+
+// Watch - drop the entries made stale by the writes of other instances until ctx is done
+// - returns at once when the store does not pass changes between instances
+func (c *Cache) Watch(ctx context.Context) error {
+	if c.notifier == nil {
+		return nil
+	}
+
+	log.Info("Watching for changes made by other instances")
+	return c.notifier.WatchChanges(ctx, c.apply)
+}
+
+// FtpUserUpdate - update the account and drop its entry
+func (c *Cache) FtpUserUpdate(ctx context.Context, user data.FtpUser) error {
+	defer c.changed(data.Change{FTPAccountID: user.ID})
+	return c.Datastore.FtpUserUpdate(ctx, user)
+}
+
+// FtpUserUpdatePassword - update the password of the account and drop its entry
+func (c *Cache) FtpUserUpdatePassword(ctx context.Context, user data.FtpUser) error {
+	defer c.changed(data.Change{FTPAccountID: user.ID})
+	return c.Datastore.FtpUserUpdatePassword(ctx, user)
+}
+
+// FtpUserDelete - soft delete the account and drop its entry
+func (c *Cache) FtpUserDelete(ctx context.Context, id uint32, version *time.Time) error {
+	defer c.changed(data.Change{FTPAccountID: id})
+	return c.Datastore.FtpUserDelete(ctx, id, version)
+}
+
+// MappingCreate - create or move the mapping and drop the entries it may change
+func (c *Cache) MappingCreate(ctx context.Context, mapping data.NewMapping) (int, error) {
+	defer c.changed(data.Change{System: mapping.System})
+	return c.Datastore.MappingCreate(ctx, mapping)
+}
+
+// MappingDelete - delete the mapping and drop the entries it may change
+func (c *Cache) MappingDelete(ctx context.Context, system string, id string, version *time.Time) (int64, error) {
+	defer c.changed(data.Change{System: system})
+	return c.Datastore.MappingDelete(ctx, system, id, version)
+}
+
+// Begin - start a transaction whose writes drop entries when it is committed
+func (c *Cache) Begin(ctx context.Context) (data.Tx, error) {
+	tx, err := c.Datastore.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cacheTx{Tx: tx, cache: c}, nil
+}
+
+// copyUser - a copy of user that does not share its folders
+func copyUser(user sftpgo.User) sftpgo.User {
+	user.VirtualFolders = append([]vfs.VirtualFolder(nil), user.VirtualFolders...)
+	return user
+}
@@ -0,0 +1,391 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	sftpgo "github.com/drakkan/sftpgo/v2/dataprovider"
+	"github.com/halt-joe/ftp-user-svc/data"
+	"github.com/halt-joe/ftp-user-svc/data/datastoretest"
+	"github.com/halt-joe/ftp-user-svc/data/memory"
+)
+
+// countingStore - a Datastore that counts the lookups made against it
+type countingStore struct {
+	data.Datastore
+	lookups int
+}
+
+func (s *countingStore) FtpUserLookup(ctx context.Context, username string) (sftpgo.User, error) {
+	s.lookups++
+	return s.Datastore.FtpUserLookup(ctx, username)
+}
+
+// newCache - a Cache of size entries over a store holding the account username with password and one lookup folder
+func newCache(t *testing.T, size int, usernames ...string) (*Cache, *countingStore, []uint32) {
+	t.Helper()
+
+	store := &countingStore{Datastore: memory.New()}
+
+	var ids []uint32
+	for i, username := range usernames {
+		user, err := store.FtpUserCreate(context.Background(), data.FtpUser{Username: username, Password: "secret"})
+		if err != nil {
+			t.Fatalf("unexpected error from FtpUserCreate %s", err)
+		}
+		if _, err := store.MappingCreate(context.Background(), data.NewMapping{System: data.LookupSystem, SystemID: string(rune('a' + i)), FTPAccountID: user.ID}); err != nil {
+			t.Fatalf("unexpected error from MappingCreate %s", err)
+		}
+		ids = append(ids, user.ID)
+	}
+
+	return New(store, size, time.Minute), store, ids
+}
+
+// verify - check the login of username with password against c
+func verify(t *testing.T, c *Cache, username string, password string, expMatched bool) {
+	t.Helper()
+
+	user, matched, err := c.FtpUserVerify(context.Background(), username, password)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserVerify %s", err)
+	}
+	if matched != expMatched {
+		t.Errorf("expected matched %t for %s but received %t", expMatched, username, matched)
+	}
+	if user.Username != username || user.Password != "" {
+		t.Errorf("unexpected user returned %+v", user)
+	}
+}
+
+func TestFtpUserVerify(t *testing.T) {
+	c, store, _ := newCache(t, 10, "Test")
+
+	verify(t, c, "Test", "secret", true)
+	verify(t, c, "Test", "secret", true)
+	if store.lookups != 1 {
+		t.Errorf("expected the second login to be cached but found %d lookups", store.lookups)
+	}
+
+	// a wrong password is checked against the store and drops the entry
+	verify(t, c, "Test", "wrong", false)
+	verify(t, c, "Test", "secret", true)
+	if store.lookups != 3 {
+		t.Errorf("expected 3 lookups but found %d", store.lookups)
+	}
+
+	for _, e := range c.entries {
+		if e.Value.(*entry).user.Password != "" {
+			t.Error("expected no password to be cached")
+		}
+	}
+
+	_, _, err := c.FtpUserVerify(context.Background(), "Missing", "secret")
+	if err != data.ErrUserNotFound {
+		t.Errorf("expected %v but received %v", data.ErrUserNotFound, err)
+	}
+}
+
+func TestInvalidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		write      func(ds data.Datastore, id uint32) error
+		invalidate bool
+	}{
+		{
+			name: "Update",
+			write: func(ds data.Datastore, id uint32) error {
+				return ds.FtpUserUpdate(context.Background(), data.FtpUser{ID: id, Description: "Updated"})
+			},
+			invalidate: true,
+		},
+		{
+			name: "Update Password",
+			write: func(ds data.Datastore, id uint32) error {
+				return ds.FtpUserUpdatePassword(context.Background(), data.FtpUser{ID: id, Password: "changed"})
+			},
+			invalidate: true,
+		},
+		{
+			name:       "Delete",
+			write:      func(ds data.Datastore, id uint32) error { return ds.FtpUserDelete(context.Background(), id, nil) },
+			invalidate: true,
+		},
+		{
+			name: "Mapping Create",
+			write: func(ds data.Datastore, id uint32) error {
+				_, err := ds.MappingCreate(context.Background(), data.NewMapping{System: data.LookupSystem, SystemID: "z", FTPAccountID: id})
+				return err
+			},
+			invalidate: true,
+		},
+		{
+			name: "Mapping Delete",
+			write: func(ds data.Datastore, id uint32) error {
+				_, err := ds.MappingDelete(context.Background(), data.LookupSystem, "a", nil)
+				return err
+			},
+			invalidate: true,
+		},
+		{
+			name: "Mapping In Another System",
+			write: func(ds data.Datastore, id uint32) error {
+				_, err := ds.MappingCreate(context.Background(), data.NewMapping{System: "OtherSys", SystemID: "z", FTPAccountID: id})
+				return err
+			},
+		},
+		{
+			name: "Record Login",
+			write: func(ds data.Datastore, id uint32) error {
+				return ds.FtpUserRecordLogin(context.Background(), id, "10.0.0.1")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, store, ids := newCache(t, 10, "Test")
+
+			verify(t, c, "Test", "secret", true)
+
+			if err := tt.write(c, ids[0]); err != nil {
+				t.Fatalf("unexpected error from the write %s", err)
+			}
+
+			c.mu.Lock()
+			_, cached := c.entries["Test"]
+			c.mu.Unlock()
+			if cached == tt.invalidate {
+				t.Errorf("expected the entry to be dropped %t but found it cached %t after %d lookups", tt.invalidate, cached, store.lookups)
+			}
+		})
+	}
+}
+
+// caseInsensitiveStore - a Datastore whose lookups ignore the case of the username, as MySQL's collation does
+type caseInsensitiveStore struct {
+	data.Datastore
+}
+
+func (s caseInsensitiveStore) FtpUserLookup(ctx context.Context, username string) (sftpgo.User, error) {
+	return s.Datastore.FtpUserLookup(ctx, strings.ToLower(username))
+}
+
+func TestInvalidationOfEverySpelling(t *testing.T) {
+	store := memory.New()
+	user, err := store.FtpUserCreate(context.Background(), data.FtpUser{Username: "bob", Password: "secret"})
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserCreate %s", err)
+	}
+	if _, err := store.MappingCreate(context.Background(), data.NewMapping{System: data.LookupSystem, SystemID: "a", FTPAccountID: user.ID}); err != nil {
+		t.Fatalf("unexpected error from MappingCreate %s", err)
+	}
+
+	c := New(caseInsensitiveStore{store}, 10, time.Minute)
+
+	spellings := []string{"Bob", "bob"}
+	for _, username := range spellings {
+		if _, matched, err := c.FtpUserVerify(context.Background(), username, "secret"); err != nil || !matched {
+			t.Fatalf("expected %s to log in but received %t %v", username, matched, err)
+		}
+	}
+	if len(c.entries) != len(spellings) {
+		t.Fatalf("expected an entry for each spelling but found %d", len(c.entries))
+	}
+
+	if err := c.FtpUserUpdatePassword(context.Background(), data.FtpUser{ID: user.ID, Password: "changed"}); err != nil {
+		t.Fatalf("unexpected error from FtpUserUpdatePassword %s", err)
+	}
+
+	for _, username := range spellings {
+		if _, matched, err := c.FtpUserVerify(context.Background(), username, "secret"); err != nil || matched {
+			t.Errorf("expected the old password of %s to be rejected but received %t %v", username, matched, err)
+		}
+	}
+}
+
+func TestTransactionInvalidation(t *testing.T) {
+	c, _, ids := newCache(t, 10, "Test")
+
+	verify(t, c, "Test", "secret", true)
+
+	tx, err := c.Begin(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error from Begin %s", err)
+	}
+	defer tx.Rollback()
+
+	if err := tx.FtpUserUpdatePassword(context.Background(), data.FtpUser{ID: ids[0], Password: "changed"}); err != nil {
+		t.Fatalf("unexpected error from FtpUserUpdatePassword %s", err)
+	}
+	if _, cached := c.entries["Test"]; !cached {
+		t.Error("expected the entry to be kept until the transaction is committed")
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("unexpected error from Commit %s", err)
+	}
+	verify(t, c, "Test", "secret", false)
+	verify(t, c, "Test", "changed", true)
+}
+
+func TestExpiryAndSize(t *testing.T) {
+	defer func() { now = time.Now }()
+
+	c, store, _ := newCache(t, 1, "First", "Second")
+
+	at := time.Now()
+	now = func() time.Time { return at }
+
+	verify(t, c, "First", "secret", true)
+	verify(t, c, "Second", "secret", true)
+	verify(t, c, "First", "secret", true)
+	if store.lookups != 3 {
+		t.Errorf("expected First to be evicted by Second but found %d lookups", store.lookups)
+	}
+
+	at = at.Add(2 * time.Minute)
+	verify(t, c, "First", "secret", true)
+	if store.lookups != 4 {
+		t.Errorf("expected the expired entry to be looked up but found %d lookups", store.lookups)
+	}
+	if len(c.entries) != 1 || c.order.Len() != 1 {
+		t.Errorf("expected a single entry but found %d", len(c.entries))
+	}
+}
+
+func TestCacheConformance(t *testing.T) {
+	datastoretest.Run(t, func(t *testing.T, opts data.Options) data.Datastore {
+		return New(memory.NewWithOptions(opts), 10, time.Minute)
+	})
+}
+
+// notifyingStore - a Datastore that records the changes notified through it and passes on those watched
+type notifyingStore struct {
+	*countingStore
+	notified []data.Change
+	watched  chan data.Change
+}
+
+func (s *notifyingStore) NotifyChange(ctx context.Context, change data.Change) error {
+	s.notified = append(s.notified, change)
+	return nil
+}
+
+func (s *notifyingStore) WatchChanges(ctx context.Context, fn func(data.Change)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case change := <-s.watched:
+			fn(change)
+		}
+	}
+}
+
+func TestChangeNotification(t *testing.T) {
+	_, counting, ids := newCache(t, 10, "First", "Second")
+	store := &notifyingStore{countingStore: counting, watched: make(chan data.Change)}
+	c := New(store, 10, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- c.Watch(ctx) }()
+
+	t.Run("Notify", func(t *testing.T) {
+		if err := c.FtpUserUpdate(context.Background(), data.FtpUser{ID: ids[0], Username: "First", Description: "Updated"}); err != nil {
+			t.Fatalf("unexpected error from FtpUserUpdate %s", err)
+		}
+		if _, err := c.MappingCreate(context.Background(), data.NewMapping{System: "OtherSys", SystemID: "z", FTPAccountID: ids[0]}); err != nil {
+			t.Fatalf("unexpected error from MappingCreate %s", err)
+		}
+		if _, err := c.MappingCreate(context.Background(), data.NewMapping{System: data.LookupSystem, SystemID: "z", FTPAccountID: ids[0]}); err != nil {
+			t.Fatalf("unexpected error from MappingCreate %s", err)
+		}
+
+		expected := []data.Change{{FTPAccountID: ids[0]}, {System: data.LookupSystem}}
+		if len(store.notified) != len(expected) || store.notified[0] != expected[0] || store.notified[1] != expected[1] {
+			t.Errorf("expected changes %+v to be notified but received %+v", expected, store.notified)
+		}
+	})
+
+	t.Run("Watch", func(t *testing.T) {
+		verify(t, c, "First", "secret", true)
+		verify(t, c, "Second", "secret", true)
+
+		// the unbuffered send returns once the change is being applied, the second once it has been
+		store.watched <- data.Change{FTPAccountID: ids[1]}
+		store.watched <- data.Change{System: "OtherSys"}
+
+		c.mu.Lock()
+		_, first := c.entries["First"]
+		_, second := c.entries["Second"]
+		c.mu.Unlock()
+		if !first || second {
+			t.Errorf("expected only Second to be dropped but found First %t and Second %t cached", first, second)
+		}
+
+		store.watched <- data.Change{}
+		store.watched <- data.Change{System: "OtherSys"}
+		if len(c.entries) != 0 {
+			t.Errorf("expected every entry to be dropped but found %d", len(c.entries))
+		}
+	})
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error from Watch %s", err)
+	}
+	if err := New(memory.New(), 10, time.Minute).Watch(context.Background()); err != nil {
+		t.Errorf("expected Watch to return at once without a notifier but received %s", err)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	_, counting, ids := newCache(t, 10, "First")
+	store := &notifyingStore{countingStore: counting}
+	notifier := Notify(store)
+
+	if Notify(notifier) != notifier {
+		t.Errorf("expected Notify to return a notifying store itself")
+	}
+	if memStore := memory.New(); Notify(memStore) != data.Datastore(memStore) {
+		t.Errorf("expected Notify to return a store without a notifier itself")
+	}
+
+	if err := notifier.FtpUserUpdate(context.Background(), data.FtpUser{ID: ids[0], Username: "First", Description: "Updated"}); err != nil {
+		t.Fatalf("unexpected error from FtpUserUpdate %s", err)
+	}
+	if _, err := notifier.MappingCreate(context.Background(), data.NewMapping{System: "OtherSys", SystemID: "z", FTPAccountID: ids[0]}); err != nil {
+		t.Fatalf("unexpected error from MappingCreate %s", err)
+	}
+
+	tx, err := notifier.Begin(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error from Begin %s", err)
+	}
+	if _, err := tx.MappingDelete(context.Background(), data.LookupSystem, "a", nil); err != nil {
+		t.Fatalf("unexpected error from MappingDelete %s", err)
+	}
+	if len(store.notified) != 1 {
+		t.Errorf("expected the write in the transaction to wait for the commit but received %+v", store.notified)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("unexpected error from Commit %s", err)
+	}
+
+	tx, err = notifier.Begin(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error from Begin %s", err)
+	}
+	if err := tx.FtpUserDelete(context.Background(), ids[0], nil); err != nil {
+		t.Fatalf("unexpected error from FtpUserDelete %s", err)
+	}
+	tx.Rollback()
+
+	expected := []data.Change{{FTPAccountID: ids[0]}, {System: data.LookupSystem}}
+	if len(store.notified) != len(expected) || store.notified[0] != expected[0] || store.notified[1] != expected[1] {
+		t.Errorf("expected changes %+v to be notified but received %+v", expected, store.notified)
+	}
+}
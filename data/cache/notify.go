@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/halt-joe/ftp-user-svc/data"
+)
+
+// changeNotifier - a data.Datastore that notifies the instances sharing its data.ChangeNotifier of the
+// writes that make their cached logins stale
+type changeNotifier struct {
+	data.Datastore
+	data.ChangeNotifier
+}
+
+// Notify - store notifying the other instances sharing it of every write that makes their cached logins stale
+// - installed whether or not this instance caches logins, so the caches of the others are kept fresh
+// - returns store itself when it does not pass changes between instances or already notifies them
+func Notify(store data.Datastore) data.Datastore {
+	if _, ok := store.(*changeNotifier); ok {
+		return store
+	}
+	notifier, ok := store.(data.ChangeNotifier)
+	if !ok {
+		return store
+	}
+
+	return &changeNotifier{Datastore: store, ChangeNotifier: notifier}
+}
+
+// notify - pass change to the other instances, mappings of systems other than data.LookupSystem are not cached
+// - the notification is sent without the request context so a client going away cannot stop it,
+// the notifier logs its failures
+func (n *changeNotifier) notify(change data.Change) {
+	if change.System != "" && change.System != data.LookupSystem {
+		return
+	}
+
+	n.NotifyChange(context.Background(), change)
+}
+
+// FtpUserUpdate - update the account and notify the change
+func (n *changeNotifier) FtpUserUpdate(ctx context.Context, user data.FtpUser) error {
+	defer n.notify(data.Change{FTPAccountID: user.ID})
+	return n.Datastore.FtpUserUpdate(ctx, user)
+}
+
+// FtpUserUpdatePassword - update the password of the account and notify the change
+func (n *changeNotifier) FtpUserUpdatePassword(ctx context.Context, user data.FtpUser) error {
+	defer n.notify(data.Change{FTPAccountID: user.ID})
+	return n.Datastore.FtpUserUpdatePassword(ctx, user)
+}
+
+// FtpUserDelete - soft delete the account and notify the change
+func (n *changeNotifier) FtpUserDelete(ctx context.Context, id uint32, version *time.Time) error {
+	defer n.notify(data.Change{FTPAccountID: id})
+	return n.Datastore.FtpUserDelete(ctx, id, version)
+}
+
+// MappingCreate - create or move the mapping and notify the change
+func (n *changeNotifier) MappingCreate(ctx context.Context, mapping data.NewMapping) (int, error) {
+	defer n.notify(data.Change{System: mapping.System})
+	return n.Datastore.MappingCreate(ctx, mapping)
+}
+
+// MappingDelete - delete the mapping and notify the change
+func (n *changeNotifier) MappingDelete(ctx context.Context, system string, id string, version *time.Time) (int64, error) {
+	defer n.notify(data.Change{System: system})
+	return n.Datastore.MappingDelete(ctx, system, id, version)
+}
+
+// Begin - start a transaction whose writes are notified when it is committed
+func (n *changeNotifier) Begin(ctx context.Context) (data.Tx, error) {
+	tx, err := n.Datastore.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &notifyTx{Tx: tx, notifier: n}, nil
+}
+
+// notifyTx - a transaction that records the changes its writes make and notifies them once committed
+// - the other instances would otherwise read the data it replaces again before the commit
+type notifyTx struct {
+	data.Tx
+
+	notifier *changeNotifier
+	pending  []data.Change
+}
+
+// compile time check that notifyTx satisfies the data.Tx interface
+var _ data.Tx = (*notifyTx)(nil)
+
+// Commit - commit the transaction and notify the changes made in it
+func (t *notifyTx) Commit() error {
+	err := t.Tx.Commit()
+	if err == nil {
+		for _, change := range t.pending {
+			t.notifier.notify(change)
+		}
+	}
+	t.pending = nil
+
+	return err
+}
+
+// FtpUserUpdate - update the account, notifying the change on commit
+func (t *notifyTx) FtpUserUpdate(ctx context.Context, user data.FtpUser) error {
+	t.pending = append(t.pending, data.Change{FTPAccountID: user.ID})
+	return t.Tx.FtpUserUpdate(ctx, user)
+}
+
+// FtpUserUpdatePassword - update the password of the account, notifying the change on commit
+func (t *notifyTx) FtpUserUpdatePassword(ctx context.Context, user data.FtpUser) error {
+	t.pending = append(t.pending, data.Change{FTPAccountID: user.ID})
+	return t.Tx.FtpUserUpdatePassword(ctx, user)
+}
+
+// FtpUserDelete - soft delete the account, notifying the change on commit
+func (t *notifyTx) FtpUserDelete(ctx context.Context, id uint32, version *time.Time) error {
+	t.pending = append(t.pending, data.Change{FTPAccountID: id})
+	return t.Tx.FtpUserDelete(ctx, id, version)
+}
+
+// MappingCreate - create or move the mapping, notifying the change on commit
+func (t *notifyTx) MappingCreate(ctx context.Context, mapping data.NewMapping) (int, error) {
+	t.pending = append(t.pending, data.Change{System: mapping.System})
+	return t.Tx.MappingCreate(ctx, mapping)
+}
+
+// MappingDelete - delete the mapping, notifying the change on commit
+func (t *notifyTx) MappingDelete(ctx context.Context, system string, id string, version *time.Time) (int64, error) {
+	t.pending = append(t.pending, data.Change{System: system})
+	return t.Tx.MappingDelete(ctx, system, id, version)
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/halt-joe/ftp-user-svc/data"
+)
+
+// cacheTx - a transaction that records the changes its writes make and applies them once committed
+// - entries are dropped after the commit so a lookup cannot cache the data it replaces
+type cacheTx struct {
+	data.Tx
+
+	cache   *Cache
+	pending []data.Change
+}
+
+// compile time check that cacheTx satisfies the data.Tx interface
+var _ data.Tx = (*cacheTx)(nil)
+
+// Commit - commit the transaction and drop the entries changed in it
+func (t *cacheTx) Commit() error {
+	err := t.Tx.Commit()
+	if err == nil {
+		for _, change := range t.pending {
+			t.cache.changed(change)
+		}
+	}
+	t.pending = nil
+
+	return err
+}
+
+// FtpUserUpdate - update the account, dropping its entry on commit
+func (t *cacheTx) FtpUserUpdate(ctx context.Context, user data.FtpUser) error {
+	t.pending = append(t.pending, data.Change{FTPAccountID: user.ID})
+	return t.Tx.FtpUserUpdate(ctx, user)
+}
+
+// FtpUserUpdatePassword - update the password of the account, dropping its entry on commit
+func (t *cacheTx) FtpUserUpdatePassword(ctx context.Context, user data.FtpUser) error {
+	t.pending = append(t.pending, data.Change{FTPAccountID: user.ID})
+	return t.Tx.FtpUserUpdatePassword(ctx, user)
+}
+
+// FtpUserDelete - soft delete the account, dropping its entry on commit
+func (t *cacheTx) FtpUserDelete(ctx context.Context, id uint32, version *time.Time) error {
+	t.pending = append(t.pending, data.Change{FTPAccountID: id})
+	return t.Tx.FtpUserDelete(ctx, id, version)
+}
+
+// MappingCreate - create or move the mapping, dropping the entries it may change on commit
+func (t *cacheTx) MappingCreate(ctx context.Context, mapping data.NewMapping) (int, error) {
+	t.pending = append(t.pending, data.Change{System: mapping.System})
+	return t.Tx.MappingCreate(ctx, mapping)
+}
+
+// MappingDelete - delete the mapping, dropping the entries it may change on commit
+func (t *cacheTx) MappingDelete(ctx context.Context, system string, id string, version *time.Time) (int64, error) {
+	t.pending = append(t.pending, data.Change{System: system})
+	return t.Tx.MappingDelete(ctx, system, id, version)
+}
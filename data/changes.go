@@ -0,0 +1,211 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/lib/pq"
+)
+
+// Change - a write made by one instance that makes data cached by the others stale
+// - FTPAccountID names the account changed, System the system whose mappings changed,
+// the zero Change means anything may have changed
+type Change struct {
+	FTPAccountID uint32
+	System       string
+}
+
+// ChangeNotifier - a Datastore shared by several instances that passes Changes between them
+type ChangeNotifier interface {
+	// NotifyChange - tell every instance watching the datastore about change
+	NotifyChange(ctx context.Context, change Change) error
+	// WatchChanges - call fn with each change notified by any instance until ctx is done
+	// - fn may be called more than once for the same change
+	WatchChanges(ctx context.Context, fn func(Change)) error
+}
+
+// compile time check that Database satisfies the ChangeNotifier interface
+var _ ChangeNotifier = (*Database)(nil)
+
+// changeChannel - the PostgreSQL notification channel that wakes watchers when a Change is logged
+const changeChannel = "ftp_change"
+
+// the Options.ChangePollInterval and Options.ChangeRetention of DefaultOptions, also used when they are not set
+const (
+	defaultChangePollInterval = 2 * time.Second
+	defaultChangeRetention    = time.Hour
+)
+
+// changeSettle - how far behind the newest Change the change log is read again
+// - ids are assigned when a Change is inserted but seen once it is committed, so a Change can
+// appear behind a later one
+const changeSettle = 5 * time.Second
+
+// changeListenerPing - how often PostgreSQL watchers check their listener and read the change
+// log without being notified
+const changeListenerPing = 90 * time.Second
+
+// NotifyChange - add change to the change log, PostgreSQL also notifies changeChannel
+func (db *Database) NotifyChange(ctx context.Context, change Change) error {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	var ftpID, system interface{}
+	if change.FTPAccountID != 0 {
+		ftpID = change.FTPAccountID
+	}
+	if change.System != "" {
+		system = change.System
+	}
+
+	_, err := db.ExecForDriver(ctx, "insert into `ftp_change` (`ftp_id`, `system`) values (?, ?)", ftpID, system)
+	if err == nil && db.dialect() == PostgreSQL {
+		_, err = db.ExecForDriver(ctx, "select pg_notify(?, '')", changeChannel)
+	}
+	if err != nil {
+		log.Error(err.Error())
+	}
+
+	return err
+}
+
+// WatchChanges - call fn with each Change added to the change log from now until ctx is done
+// - PostgreSQL reads the log when notified, MySQL and SQLite every Options.ChangePollInterval
+// - Changes older than Options.ChangeRetention are purged from the log as it is read
+func (db *Database) WatchChanges(ctx context.Context, fn func(Change)) error {
+	interval := db.Options.ChangePollInterval
+	if interval <= 0 {
+		interval = defaultChangePollInterval
+	}
+	retention := db.Options.ChangeRetention
+	if retention <= 0 {
+		retention = defaultChangeRetention
+	}
+
+	var listener *pq.Listener
+	var notified <-chan *pq.Notification
+	if db.dialect() == PostgreSQL {
+		listener = pq.NewListener(db.dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Warn("Change listener", "event", event, "error", err.Error())
+			}
+		})
+		defer listener.Close()
+
+		if err := listener.Listen(changeChannel); err != nil {
+			return err
+		}
+		notified = listener.Notify
+		interval = changeListenerPing
+	}
+
+	var cursor int64
+	err := db.QueryRowForDriver(ctx, "select coalesce(max(`id`), 0) from `ftp_change`").Scan(&cursor)
+	if err != nil {
+		return contextErr(ctx, err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failing := false
+	purged := time.Now()
+	for {
+		// a nil notification is sent after the listener reconnects, the log is read to catch up
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-notified:
+		case <-ticker.C:
+			if listener != nil {
+				go listener.Ping()
+			}
+		}
+
+		next, err := db.readChanges(ctx, cursor, fn)
+		if err != nil {
+			if !failing && ctx.Err() == nil {
+				log.Warn("Unable to read the change log", "error", err.Error())
+			}
+			failing = true
+			continue
+		}
+		if failing {
+			log.Info("Reading the change log again")
+			failing = false
+		}
+		cursor = next
+
+		if time.Since(purged) > retention {
+			purged = time.Now()
+			if _, err := db.changePurge(ctx, purged.Add(-retention)); err != nil {
+				log.Warn("Unable to purge the change log", "error", err.Error())
+			}
+		}
+	}
+}
+
+// readChanges - call fn with each Change in the change log after cursor, returning the cursor for the next read
+// - the cursor stays behind the Changes added in the changeSettle before the newest, so they are read again
+func (db *Database) readChanges(ctx context.Context, cursor int64, fn func(Change)) (int64, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	qry := "select `id`, `changed_at`, `ftp_id`, `system` from `ftp_change` where `id` > ? order by `id`"
+
+	rows, err := db.QueryForDriver(ctx, qry, cursor)
+	if err != nil {
+		return cursor, err
+	}
+	defer rows.Close()
+
+	type logged struct {
+		id        int64
+		changedAt time.Time
+	}
+
+	var read []logged
+	for rows.Next() {
+		var l logged
+		var ftpID sql.NullInt64
+		var system sql.NullString
+		if err := rows.Scan(&l.id, &l.changedAt, &ftpID, &system); err != nil {
+			return cursor, err
+		}
+
+		fn(Change{FTPAccountID: uint32(ftpID.Int64), System: system.String})
+		read = append(read, l)
+	}
+	if err := rows.Err(); err != nil {
+		return cursor, err
+	}
+
+	if len(read) == 0 {
+		return cursor, nil
+	}
+
+	settled := read[len(read)-1].changedAt.Add(-changeSettle)
+	for _, l := range read {
+		if l.changedAt.After(settled) {
+			break
+		}
+		cursor = l.id
+	}
+
+	return cursor, nil
+}
+
+// changePurge - delete the Changes logged before before
+func (db *Database) changePurge(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := db.ExecForDriver(ctx, "delete from `ftp_change` where `changed_at` < ?", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,142 @@
+package data
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestNotifyChangePostgres(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf(errDBConnectionError, err)
+	}
+	defer db.Close()
+
+	dBase := &Database{DB: db, Dialect: PostgreSQL}
+
+	ex := mock.ExpectExec("insert into \"ftp_change\" \\(\"ftp_id\", \"system\"\\) values \\(\\$1, \\$2\\)")
+	ex.WithArgs(5, nil)
+	ex.WillReturnResult(sqlmock.NewResult(1, 1))
+
+	ex = mock.ExpectExec("select pg_notify\\(\\$1, ''\\)")
+	ex.WithArgs(changeChannel)
+	ex.WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := dBase.NotifyChange(context.Background(), Change{FTPAccountID: 5}); err != nil {
+		t.Errorf("unexpected error from NotifyChange %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWatchChangesPolling(t *testing.T) {
+	// two instances sharing one database file
+	dataSourceName := "sqlite://" + filepath.Join(t.TempDir(), "changes.db")
+	var instances [2]*Database
+	for i := range instances {
+		opts := DefaultOptions()
+		opts.ChangePollInterval = 10 * time.Millisecond
+		db, err := NewDB(dataSourceName, opts)
+		if err != nil {
+			t.Fatalf("unexpected error from NewDB %s", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if _, err = db.MigrateUp(context.Background()); err != nil {
+			t.Fatalf("unexpected error from MigrateUp %s", err)
+		}
+		instances[i] = db
+	}
+
+	// a change made before watching starts is not seen
+	if err := instances[0].NotifyChange(context.Background(), Change{FTPAccountID: 1}); err != nil {
+		t.Fatalf("unexpected error from NotifyChange %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	received := map[Change]bool{}
+	done := make(chan error)
+	go func() {
+		done <- instances[1].WatchChanges(ctx, func(change Change) {
+			mu.Lock()
+			received[change] = true
+			mu.Unlock()
+		})
+	}()
+
+	// wait until change is read by the watch, notifying it again in case the watch had not started
+	await := func(change Change, notify bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			if notify {
+				if err := instances[0].NotifyChange(context.Background(), change); err != nil {
+					t.Fatalf("unexpected error from NotifyChange %s", err)
+				}
+			}
+			mu.Lock()
+			seen := received[change]
+			mu.Unlock()
+			if seen {
+				return
+			}
+		}
+		t.Fatalf("expected %+v to be received", change)
+	}
+	await(Change{FTPAccountID: 2}, true)
+
+	expected := []Change{{System: "FTPSvc"}, {FTPAccountID: 3}, {}}
+	for _, change := range expected {
+		if err := instances[0].NotifyChange(context.Background(), change); err != nil {
+			t.Fatalf("unexpected error from NotifyChange %s", err)
+		}
+	}
+	for _, change := range expected {
+		await(change, false)
+	}
+
+	mu.Lock()
+	if received[Change{FTPAccountID: 1}] {
+		t.Error("expected the change made before watching to be skipped")
+	}
+	mu.Unlock()
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error from WatchChanges %s", err)
+	}
+}
+
+func TestReadChangesSettle(t *testing.T) {
+	db := newSQLiteDB(t)
+
+	at := time.Now().Add(-time.Minute)
+	for _, changedAt := range []time.Time{at, at.Add(time.Second), at.Add(10 * time.Second)} {
+		_, err := db.ExecForDriver(context.Background(), "insert into `ftp_change` (`changed_at`, `ftp_id`) values (?, ?)", changedAt, 1)
+		if err != nil {
+			t.Fatalf("unexpected error adding a change %s", err)
+		}
+	}
+
+	read := 0
+	cursor, err := db.readChanges(context.Background(), 0, func(Change) { read++ })
+	if err != nil {
+		t.Fatalf("unexpected error from readChanges %s", err)
+	}
+	if read != 3 {
+		t.Errorf("expected 3 changes to be read but received %d", read)
+	}
+	if cursor != 2 {
+		t.Errorf("expected the cursor to stay behind the newest change but received %d", cursor)
+	}
+
+	purged, err := db.changePurge(context.Background(), at.Add(5*time.Second))
+	if err != nil || purged != 2 {
+		t.Errorf("expected 2 changes to be purged but received %d %v", purged, err)
+	}
+}
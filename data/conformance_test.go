@@ -0,0 +1,26 @@
+package data_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/halt-joe/ftp-user-svc/data"
+	"github.com/halt-joe/ftp-user-svc/data/datastoretest"
+)
+
+func TestSQLiteConformance(t *testing.T) {
+	datastoretest.Run(t, func(t *testing.T, opts data.Options) data.Datastore {
+		db, err := data.NewDB("sqlite://file::memory:", opts)
+		if err != nil {
+			t.Fatalf("unexpected error from NewDB %s", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		_, err = db.MigrateUp(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error from MigrateUp %s", err)
+		}
+
+		return db
+	})
+}
@@ -0,0 +1,193 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Cursor - a position in the ordering of FtpUserGetSelection, passed to clients as an opaque token
+// - only the values of the Sort keys and the ID are kept, the ID breaks ties between equal values
+// - Before selects the rows before the position rather than after it
+type Cursor struct {
+	Sort        string     `json:"s,omitempty"`
+	Before      bool       `json:"b,omitempty"`
+	ID          uint32     `json:"i"`
+	Username    string     `json:"u,omitempty"`
+	LoginCount  uint32     `json:"c,omitempty"`
+	LastLoginAt *time.Time `json:"l,omitempty"`
+	UpdatedOn   *time.Time `json:"o,omitempty"`
+}
+
+// NewCursor - the token of the position of user in the ordering of sort
+// - before selects the rows before user when the token is used, otherwise those after
+func NewCursor(user FtpUser, sort string, before bool) string {
+	c := Cursor{Sort: sort, Before: before, ID: user.ID}
+	for _, field := range sortFields(sort) {
+		switch field.Key {
+		case "username":
+			c.Username = user.Username
+		case "login_count":
+			c.LoginCount = user.LoginCount
+		case "last_login_at":
+			c.LastLoginAt = user.LastLoginAt
+		case "updated_on":
+			c.UpdatedOn = user.UpdatedOn
+		}
+	}
+
+	token, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// ParseCursor - the Cursor in token, which must have been issued for the same sort
+func ParseCursor(token string, sort string) (Cursor, error) {
+	var c Cursor
+
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || json.Unmarshal(decoded, &c) != nil {
+		return c, ErrInvalidCursor
+	}
+
+	fields, cFields := sortFields(sort), sortFields(c.Sort)
+	if len(fields) != len(cFields) {
+		return c, ErrInvalidCursor
+	}
+	for i := range fields {
+		if fields[i] != cFields[i] {
+			return c, ErrInvalidCursor
+		}
+	}
+	for _, field := range fields {
+		if field.Key == "updated_on" && c.UpdatedOn == nil {
+			return c, ErrInvalidCursor
+		}
+	}
+
+	return c, nil
+}
+
+// Position - an account holding the values of the position, for comparing against other accounts
+func (c Cursor) Position() FtpUser {
+	return FtpUser{ID: c.ID, Username: c.Username, LoginCount: c.LoginCount, LastLoginAt: c.LastLoginAt, UpdatedOn: c.UpdatedOn}
+}
+
+// SortField - a ftpUserSortColumns key and whether it is ordered descending
+type SortField struct {
+	Key  string
+	Desc bool
+}
+
+// ParseSort - the fields of sort in order
+// - sort is a comma separated list of ftpUserSortColumns keys, each optionally prefixed with "-"
+// - ok is false for an unknown or repeated key
+func ParseSort(sort string) (fields []SortField, ok bool) {
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(sort, ",") {
+		key := strings.TrimPrefix(part, "-")
+		if _, known := ftpUserSortColumns[key]; !known || seen[key] {
+			return nil, false
+		}
+		seen[key] = true
+		fields = append(fields, SortField{Key: key, Desc: strings.HasPrefix(part, "-")})
+	}
+
+	return fields, true
+}
+
+// sortFields - the fields of sort ending with id, which breaks ties, ordering by id when sort is not valid
+// - keys after id are dropped as id is unique
+func sortFields(sort string) []SortField {
+	fields, ok := ParseSort(sort)
+	if !ok {
+		return []SortField{{Key: "id"}}
+	}
+
+	for i, field := range fields {
+		if field.Key == "id" {
+			return fields[:i+1]
+		}
+	}
+
+	return append(fields, SortField{Key: "id"})
+}
+
+// direction - the order by direction, descending when desc is set
+func direction(desc bool) string {
+	if desc {
+		return " desc"
+	}
+	return ""
+}
+
+// keysetCondition - the condition selecting the rows after c in the order of getOrderClause(c.Sort, c.Before)
+// - accounts that have never logged in are ordered before those that have
+func keysetCondition(c Cursor) (string, []interface{}) {
+	fields := sortFields(c.Sort)
+
+	// the rows beyond the id, then for each key before it the rows beyond its value or equal to it and beyond the rest
+	var (
+		condition string
+		args      []interface{}
+	)
+	for i := len(fields) - 1; i >= 0; i-- {
+		field := fields[i]
+		desc := field.Desc != c.Before
+		column := ftpUserSortColumns[field.Key]
+
+		if field.Key == "id" {
+			condition = "`id` > ?"
+			if desc {
+				condition = "`id` < ?"
+			}
+			args = []interface{}{c.ID}
+			continue
+		}
+
+		var value interface{}
+		switch field.Key {
+		case "username":
+			value = c.Username
+		case "login_count":
+			value = c.LoginCount
+		case "last_login_at":
+			if c.LastLoginAt != nil {
+				value = *c.LastLoginAt
+			}
+		case "updated_on":
+			if c.UpdatedOn != nil {
+				value = *c.UpdatedOn
+			}
+		}
+
+		var (
+			beyond     string
+			beyondArgs []interface{}
+			equal      = column + " = ?"
+			equalArgs  = []interface{}{value}
+		)
+		switch {
+		case value == nil && desc:
+			equal, equalArgs = column+" is null", nil
+		case value == nil:
+			beyond = column + " is not null"
+			equal, equalArgs = column+" is null", nil
+		case desc && field.Key == "last_login_at":
+			beyond, beyondArgs = "("+column+" < ? or "+column+" is null)", []interface{}{value}
+		case desc:
+			beyond, beyondArgs = column+" < ?", []interface{}{value}
+		default:
+			beyond, beyondArgs = column+" > ?", []interface{}{value}
+		}
+
+		rest := append(equalArgs, args...)
+		if beyond == "" {
+			condition, args = "("+equal+" and "+condition+")", rest
+		} else {
+			condition, args = "("+beyond+" or ("+equal+" and "+condition+"))", append(beyondArgs, rest...)
+		}
+	}
+
+	return condition, args
+}
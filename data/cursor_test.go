@@ -0,0 +1,151 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	lastLogin := time.Date(2022, 5, 4, 10, 15, 0, 123000000, time.UTC)
+	user := FtpUser{ID: 7, Username: "Test", LoginCount: 3, LastLoginAt: &lastLogin}
+
+	tests := []struct {
+		sort     string
+		before   bool
+		expected Cursor
+	}{
+		{sort: "", expected: Cursor{ID: 7}},
+		{sort: "-username", before: true, expected: Cursor{Sort: "-username", Before: true, ID: 7, Username: "Test"}},
+		{sort: "login_count", expected: Cursor{Sort: "login_count", ID: 7, LoginCount: 3}},
+		{sort: "last_login_at", expected: Cursor{Sort: "last_login_at", ID: 7, LastLoginAt: &lastLogin}},
+	}
+	for _, tt := range tests {
+		t.Run("Sort "+tt.sort, func(t *testing.T) {
+			c, err := ParseCursor(NewCursor(user, tt.sort, tt.before), tt.sort)
+			if err != nil {
+				t.Fatalf("unexpected error from ParseCursor %s", err)
+			}
+			if !reflect.DeepEqual(c, tt.expected) {
+				t.Errorf("expected %+v but received %+v", tt.expected, c)
+			}
+		})
+	}
+
+	if _, err := ParseCursor(NewCursor(user, "id", false), "-id"); err != ErrInvalidCursor {
+		t.Errorf("expected %v for another sort but received %v", ErrInvalidCursor, err)
+	}
+	if _, err := ParseCursor(NewCursor(user, "id", false), ""); err != nil {
+		t.Errorf("unexpected error for the default sort %v", err)
+	}
+}
+
+func TestKeysetCondition(t *testing.T) {
+	lastLogin := time.Date(2022, 5, 4, 10, 15, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		cursor   Cursor
+		expOrder string
+		expCond  string
+		expArgs  []interface{}
+	}{
+		{
+			name:     "Id",
+			cursor:   Cursor{ID: 7},
+			expOrder: " order by `id`",
+			expCond:  "`id` > ?",
+			expArgs:  []interface{}{uint32(7)},
+		},
+		{
+			name:     "Id Descending Before",
+			cursor:   Cursor{Sort: "-id", Before: true, ID: 7},
+			expOrder: " order by `id`",
+			expCond:  "`id` > ?",
+			expArgs:  []interface{}{uint32(7)},
+		},
+		{
+			name:     "Username Descending",
+			cursor:   Cursor{Sort: "-username", ID: 7, Username: "Test"},
+			expOrder: " order by `username` desc, `id`",
+			expCond:  "(`username` < ? or (`username` = ? and `id` > ?))",
+			expArgs:  []interface{}{"Test", "Test", uint32(7)},
+		},
+		{
+			name:     "Login Count Before",
+			cursor:   Cursor{Sort: "login_count", Before: true, ID: 7, LoginCount: 3},
+			expOrder: " order by `login_count` desc, `id` desc",
+			expCond:  "(`login_count` < ? or (`login_count` = ? and `id` < ?))",
+			expArgs:  []interface{}{uint32(3), uint32(3), uint32(7)},
+		},
+		{
+			name:     "Never Logged In",
+			cursor:   Cursor{Sort: "last_login_at", ID: 7},
+			expOrder: " order by (`last_login_at` is not null), `last_login_at`, `id`",
+			expCond:  "(`last_login_at` is not null or (`last_login_at` is null and `id` > ?))",
+			expArgs:  []interface{}{uint32(7)},
+		},
+		{
+			name:     "Never Logged In Descending",
+			cursor:   Cursor{Sort: "-last_login_at", ID: 7},
+			expOrder: " order by (`last_login_at` is not null) desc, `last_login_at` desc, `id`",
+			expCond:  "(`last_login_at` is null and `id` > ?)",
+			expArgs:  []interface{}{uint32(7)},
+		},
+		{
+			name:     "Last Login Descending",
+			cursor:   Cursor{Sort: "-last_login_at", ID: 7, LastLoginAt: &lastLogin},
+			expOrder: " order by (`last_login_at` is not null) desc, `last_login_at` desc, `id`",
+			expCond:  "((`last_login_at` < ? or `last_login_at` is null) or (`last_login_at` = ? and `id` > ?))",
+			expArgs:  []interface{}{lastLogin, lastLogin, uint32(7)},
+		},
+		{
+			name:     "Several Keys",
+			cursor:   Cursor{Sort: "login_count,-username", ID: 7, LoginCount: 3, Username: "Test"},
+			expOrder: " order by `login_count`, `username` desc, `id`",
+			expCond:  "(`login_count` > ? or (`login_count` = ? and (`username` < ? or (`username` = ? and `id` > ?))))",
+			expArgs:  []interface{}{uint32(3), uint32(3), "Test", "Test", uint32(7)},
+		},
+		{
+			name:     "Keys After Id",
+			cursor:   Cursor{Sort: "-id,username", Before: true, ID: 7},
+			expOrder: " order by `id`",
+			expCond:  "`id` > ?",
+			expArgs:  []interface{}{uint32(7)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if order := getOrderClause(tt.cursor.Sort, tt.cursor.Before); order != tt.expOrder {
+				t.Errorf("expected order %q but received %q", tt.expOrder, order)
+			}
+			cond, args := keysetCondition(tt.cursor)
+			if cond != tt.expCond || !reflect.DeepEqual(args, tt.expArgs) {
+				t.Errorf("expected %q %v but received %q %v", tt.expCond, tt.expArgs, cond, args)
+			}
+		})
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		sort     string
+		expected []SortField
+		ok       bool
+	}{
+		{sort: "username", expected: []SortField{{Key: "username"}}, ok: true},
+		{sort: "username,-updated_on", expected: []SortField{{Key: "username"}, {Key: "updated_on", Desc: true}}, ok: true},
+		{sort: "username,-username"},
+		{sort: "password"},
+		{sort: "username,"},
+		{sort: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			fields, ok := ParseSort(tt.sort)
+			if ok != tt.ok || !reflect.DeepEqual(fields, tt.expected) {
+				t.Errorf("expected %v %t but received %v %t", tt.expected, tt.ok, fields, ok)
+			}
+		})
+	}
+}
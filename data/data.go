@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -15,40 +16,75 @@ import (
 	sdkkms "github.com/sftpgo/sdk/kms"
 
 	// Required by database/sql
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	log "github.com/inconshreveable/log15"
 
 	// Required by database/sql
 	_ "github.com/lib/pq"
+
+	// Required by database/sql
+	_ "modernc.org/sqlite"
 )
 
 // Database - type that will implement Datastore interface
 type Database struct {
 	*sql.DB
+
+	// Dialect - how queries are written for the database, MySQL when nil
+	Dialect Dialect
+
+	// dsn - the data source name the connection pool is opened with
+	dsn string
+
+	// tx - the transaction every query runs in, nil outside of a transaction
+	tx *sql.Tx
+
+	// replicas - the read only copies that lookups and listings are sent to, nil when there are none
+	replicas *replicaSet
+
+	// Options - the settings of the database, set by NewDB and AddReplicas
+	Options Options
 }
 
 // Datastore - interface to the data from the handler environment
+// - every method takes the context of the request it serves so the work stops when the client goes away
 type Datastore interface {
-	FtpUserLookup(username string) (sftpgo.User, error)
-	MappingDelete(system string, id string) (int64, error)
-	MappingRetrieve(system string, id string) (Mapping, error)
-	MappingCreate(mapping NewMapping) (int, error)
-	FtpUserGetSelection(page uint32, pageSize uint32, search string) (FtpUsers, error)
-	FtpUserGet(id uint32) (FtpUser, error)
-	FtpUserCreate(user FtpUser) (uint32, error)
-	FtpUserUpdate(user FtpUser) error
-	FtpUserDelete(id uint32) error
-	FtpUserUpdatePassword(user FtpUser) error
-	SystemIDUserRetrieve(system string) (map[string]string, error)
-}
-
-// Custom Errors
+	FtpUserLookup(ctx context.Context, username string) (sftpgo.User, error)
+	MappingDelete(ctx context.Context, system string, id string, version *time.Time) (int64, error)
+	MappingRetrieve(ctx context.Context, system string, id string) (Mapping, error)
+	MappingCreate(ctx context.Context, mapping NewMapping) (int, error)
+	MappingGetSelection(ctx context.Context, page uint32, pageSize uint32, filter MappingFilter) (Mappings, error)
+	SystemGetSelection(ctx context.Context) (Systems, error)
+	SystemRegistrationGet(ctx context.Context, system string) (System, error)
+	SystemRegister(ctx context.Context, system string, description string) error
+	SystemUnregister(ctx context.Context, system string) error
+	FtpUserGetSelection(ctx context.Context, page uint32, pageSize uint32, search string, filter FtpUserFilter) (FtpUsers, error)
+	FtpUserGet(ctx context.Context, id uint32) (FtpUser, error)
+	FtpUserCreate(ctx context.Context, user FtpUser) (FtpUser, error)
+	FtpUserUpdate(ctx context.Context, user FtpUser) error
+	FtpUserDelete(ctx context.Context, id uint32, version *time.Time) error
+	FtpUserRestore(ctx context.Context, id uint32) error
+	FtpUserPurge(ctx context.Context, before time.Time) (int64, error)
+	FtpUserUpdatePassword(ctx context.Context, user FtpUser) error
+	FtpUserRecordLogin(ctx context.Context, id uint32, ip string) error
+	SystemIDUserRetrieve(ctx context.Context, system string) (map[string]string, error)
+	LoginAttemptCreate(ctx context.Context, attempt LoginAttempt) error
+	LoginAttemptGetSelection(ctx context.Context, page uint32, pageSize uint32, filter LoginAttemptFilter) (LoginAttempts, error)
+	LoginAttemptPurge(ctx context.Context, before time.Time) (int64, error)
+	AuditCreate(ctx context.Context, entry AuditEntry) error
+	AuditGetSelection(ctx context.Context, page uint32, pageSize uint32, filter AuditFilter) (AuditEntries, error)
+	Begin(ctx context.Context) (Tx, error)
+}
+
+// LoginVerifier - a Datastore that checks login passwords itself, e.g. against cached verifiers rather than stored passwords
+type LoginVerifier interface {
+	// FtpUserVerify - the FtpUserLookup user for username, without its Password, and whether password is theirs
+	FtpUserVerify(ctx context.Context, username string, password string) (sftpgo.User, bool, error)
+}
+
+// Error Formats
 const (
-	ErrUserNotFound       = "No matching user found"
-	ErrMappingNotFound    = "No matching mapping found"
-	ErrFTPAccountNotFound = "No matching FTP Account found"
-	ErrUnexpectedResult   = "An unexpected result [%d] was returned from a data operation"
-	ErrFTPAccountExists   = "An FTP Account for the specified username already exists"
+	ErrUnexpectedResult = "An unexpected result [%d] was returned from a data operation"
 )
 
 // Mapping Create Statuses
@@ -57,23 +93,25 @@ const (
 	MappingFTPAccountNotFound = iota
 	MappingInserted           = iota
 	MappingUpdated            = iota
+	MappingModified           = iota
 )
 
 // Database Driver Names
 const (
 	MySQLDriverName      = "mysql"
 	PostgreSQLDriverName = "postgres"
+	SQLiteDriverName     = "sqlite"
 )
 
-// Azure Parameters
-var (
-	AZKey, AZAccount, AZContainer string
+// SQLite timestamps are stored as UTC text with millisecond precision so they compare and round trip exactly
+const (
+	sqliteNow        = "(strftime('%Y-%m-%d %H:%M:%f', 'now'))"
+	sqliteTimeFormat = "2006-01-02 15:04:05.000"
 )
 
-// db open connection parameters
+// Azure Parameters
 var (
-	dbDriverName = ""
-	connStr      = ""
+	AZKey, AZAccount, AZContainer string
 )
 
 // connection retry constants
@@ -82,225 +120,448 @@ const (
 	retrySleepSeconds       = 5
 )
 
+// PoolConfig - the limits of a Database's connection pool, a zero value leaves the database/sql default
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxIdleTime time.Duration
+	ConnMaxLifetime time.Duration
+}
+
+// Options - the settings of a single Database, so databases opened in one process are configured apart
+// - DefaultOptions holds the settings used by the service, the zero value applies no limits
+type Options struct {
+	// Pool - the limits of the connection pool
+	// - SQLite always uses a single connection that is never closed, as every connection to :memory: is a separate database
+	Pool PoolConfig
+
+	// QueryTimeout - the longest a single Datastore call may spend in the database, 0 for no limit
+	// - a call that runs out of time fails with context.DeadlineExceeded
+	QueryTimeout time.Duration
+
+	// CheckSchema - when set NewDB refuses to return a database with pending migrations
+	CheckSchema bool
+
+	// RequireRegisteredSystems - when set MappingCreate only maps systems added with SystemRegister,
+	// so a mistyped system is rejected rather than created
+	RequireRegisteredSystems bool
+
+	// LoginHistoryLimit - the number of login history entries kept for each username by LoginAttemptPurge, 0 keeps every entry
+	LoginHistoryLimit uint32
+
+	// ChangePollInterval - how often MySQL and SQLite databases are polled for Changes, the longest
+	// a write on one instance leaves the others stale
+	ChangePollInterval time.Duration
+
+	// ChangeRetention - how long Changes are kept in the change log
+	ChangeRetention time.Duration
+}
+
+// DefaultOptions - the settings of a Database not configured otherwise
+func DefaultOptions() Options {
+	return Options{
+		Pool: PoolConfig{
+			MaxOpenConns:    100,
+			MaxIdleConns:    30,
+			ConnMaxIdleTime: 5 * time.Minute,
+			ConnMaxLifetime: time.Hour,
+		},
+		LoginHistoryLimit:  1000,
+		ChangePollInterval: defaultChangePollInterval,
+		ChangeRetention:    defaultChangeRetention,
+	}
+}
+
 // FtpUser - type used to contain an FTP User entry
 type FtpUser struct {
-	ID          uint32 `json:"id,omitempty"`
-	Username    string `json:"username,omitempty"`
-	Description string `json:"description,omitempty"`
-	Password    string `json:"password,omitempty"`
+	ID          uint32     `json:"id,omitempty"`
+	Username    string     `json:"username,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Password    string     `json:"password,omitempty"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+	LastLoginIP string     `json:"last_login_ip,omitempty"`
+	LoginCount  uint32     `json:"login_count,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	UpdatedOn   *time.Time `json:"updated_on,omitempty"`
 }
 
 // Credentials - type used for checking for the existence of a login
 type Credentials struct {
 	Username string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// FtpUserFilter - type used to narrow and order the result set of FtpUserGetSelection
+// - LastLoginBefore matches accounts last used before the time or never used at all
+// - LastLoginAfter matches accounts last used at or after the time
+// - MaxLoginCount matches accounts with at most the specified number of logins
+// - SearchMode is how the search is matched, one of the Search modes
+// - Sort is a comma separated list of the keys in ftpUserSortColumns, each optionally prefixed with "-" for descending order
+// - Deleted selects only the soft deleted accounts instead of the active accounts
+// - Username matches the account with exactly that username, UsernamePrefix those whose username starts with it
+// - DescriptionContains matches accounts whose description contains the text
+// - UpdatedSince matches accounts changed at or after the time
+// - MappingSystems matches accounts with a mapping in any of the systems
+// - Cursor is a FtpUsers.Next or FtpUsers.Prev token issued for the same Sort, the page is read from it instead of by index
+// - SkipTotal leaves TotalItems and TotalPages unset, saving the count of the matching accounts
+type FtpUserFilter struct {
+	LastLoginBefore     time.Time
+	LastLoginAfter      time.Time
+	MaxLoginCount       *uint32
+	SearchMode          string
+	Username            string
+	UsernamePrefix      string
+	DescriptionContains string
+	UpdatedSince        time.Time
+	MappingSystems      []string
+	Sort                string
+	Deleted             bool
+	Cursor              string
+	SkipTotal           bool
 }
 
 // Mapping - type used to represent a system, system_id and ftpuser mapping
 type Mapping struct {
-	System     string  `json:"system,omitempty"`
-	ID         string  `json:"id,omitempty"`
-	FTPAccount FtpUser `json:"ftp_account,omitempty"`
+	System     string     `json:"system,omitempty"`
+	ID         string     `json:"id,omitempty"`
+	FTPAccount FtpUser    `json:"ftp_account,omitempty"`
+	UpdatedOn  *time.Time `json:"updated_on,omitempty"`
 }
 
 // NewMapping - type used to create a new mapping
+// - when UpdatedOn is set only an existing mapping with that version is updated
 type NewMapping struct {
-	System       string `json:"system,omitempty"`
-	SystemID     string `json:"id,omitempty"`
-	FTPAccountID uint32 `json:"ftp_id,omitempty"`
+	System       string     `json:"system,omitempty"`
+	SystemID     string     `json:"id,omitempty"`
+	FTPAccountID uint32     `json:"ftp_id,omitempty"`
+	UpdatedOn    *time.Time `json:"-"`
 }
 
 // FtpUsers - type used to return a collection of FtpUser structs
+// - Next and Prev are the Cursor tokens of the pages after and before this one, unset when there are none
 type FtpUsers struct {
 	Ftpusers   []FtpUser `json:"ftpusers,omitempty"`
 	TotalItems uint32    `json:"total_items,omitempty"`
 	TotalPages uint32    `json:"total_pages,omitempty"`
+	Next       string    `json:"next,omitempty"`
+	Prev       string    `json:"prev,omitempty"`
 }
 
-func fmtQueryForDriver(query string) string {
-	result := query
-
-	if dbDriverName == PostgreSQLDriverName {
-		result = strings.ReplaceAll(result, "`", "\"")
-		for p := 1; p < 11; p++ {
-			param := fmt.Sprintf("$%d", p)
-			result = strings.Replace(result, "?", param, 1)
-		}
-	}
+// ftpUserColumns - the ftp_account columns read by scanFtpUser
+const ftpUserColumns = "`id`, `username`, `description`, `last_login_at`, `last_login_ip`, `login_count`, `deleted_at`, `updated_on`"
 
-	return result
+// ftpUserSortColumns - the allowed FtpUserFilter.Sort keys and the columns they order by
+var ftpUserSortColumns = map[string]string{
+	"id":            "`id`",
+	"username":      "`username`",
+	"last_login_at": "`last_login_at`",
+	"login_count":   "`login_count`",
+	"updated_on":    "`updated_on`",
 }
-func fmtStringParameter(param string) string {
-	return strings.ReplaceAll(param, "'", "''")
+
+// ValidFtpUserSort - report whether sort is an accepted FtpUserFilter.Sort value
+func ValidFtpUserSort(sort string) bool {
+	_, ok := ParseSort(sort)
+	return ok
 }
-func getPrimaryKeyErr() string {
-	result := ""
 
-	if dbDriverName == MySQLDriverName {
-		result = "Error Code: 1062"
+// getOrderClause - build the order by clause for the provided sort, ordering by id when not specified
+// - ties are ordered by id unless the sort already includes it
+// - accounts that have never logged in are ordered before those that have on every driver
+// - reverse gives the opposite order, used to read the rows before a Cursor
+func getOrderClause(sort string, reverse bool) string {
+	var order []string
+	for _, field := range sortFields(sort) {
+		column := ftpUserSortColumns[field.Key]
+		dir := direction(field.Desc != reverse)
+		if field.Key == "last_login_at" {
+			order = append(order, "("+column+" is not null)"+dir)
+		}
+		order = append(order, column+dir)
 	}
 
-	if dbDriverName == PostgreSQLDriverName {
-		result = "duplicate key value violates unique constraint"
-	}
+	return " order by " + strings.Join(order, ", ")
+}
+
+// likeEscape - the escape clause of a like pattern made with escapeLike, the same on every driver
+const likeEscape = " escape '!'"
 
-	return result
+// escapeLike - text with the like wildcards escaped, so it only matches itself
+func escapeLike(text string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(text)
 }
-func checkPrimaryKeyErr(err error) bool {
-	pkErr := getPrimaryKeyErr()
-	return strings.Contains(err.Error(), pkErr)
+
+// scanner - the Scan method shared by sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
 }
-func getForeignKeyErr() string {
-	result := ""
 
-	if dbDriverName == MySQLDriverName {
-		result = "Error Code: 1452"
+// scanFtpUser - read a row selected with ftpUserColumns into user
+func scanFtpUser(row scanner, user *FtpUser) error {
+	var (
+		lastLoginAt sql.NullTime
+		lastLoginIP sql.NullString
+		deletedAt   sql.NullTime
+		updatedOn   time.Time
+	)
+
+	err := row.Scan(&user.ID, &user.Username, &user.Description, &lastLoginAt, &lastLoginIP, &user.LoginCount, &deletedAt, &updatedOn)
+	if err != nil {
+		return err
 	}
 
-	if dbDriverName == PostgreSQLDriverName {
-		result = "violates foreign key constraint"
+	if lastLoginAt.Valid {
+		t := lastLoginAt.Time
+		user.LastLoginAt = &t
+	}
+	user.LastLoginIP = lastLoginIP.String
+	if deletedAt.Valid {
+		t := deletedAt.Time
+		user.DeletedAt = &t
 	}
+	user.UpdatedOn = &updatedOn
 
-	return result
+	return nil
 }
-func checkForeignKeyErr(err error) bool {
-	fkErr := getForeignKeyErr()
 
-	return strings.Contains(err.Error(), fkErr)
+// versionClause - add the condition limiting a write to the row version the client last read
+// - nothing is added when version is nil so the write is unconditional
+func versionClause(qry string, args []interface{}, version *time.Time) (string, []interface{}) {
+	if version == nil {
+		return qry, args
+	}
+
+	return qry + " and `updated_on` = ?", append(args, *version)
 }
-func getLimitClauseForDriver(pageSize, offset uint32) string {
-	result := ""
 
-	if dbDriverName == MySQLDriverName {
-		result = fmt.Sprintf(" limit %d, %d", offset, pageSize)
-	}
+func fmtStringParameter(param string) string {
+	return strings.ReplaceAll(param, "'", "''")
+}
 
-	if dbDriverName == PostgreSQLDriverName {
-		result = fmt.Sprintf(" limit %d offset %d", pageSize, offset)
+// dialect - the Dialect queries are written for
+func (db *Database) dialect() Dialect {
+	if db.Dialect == nil {
+		return MySQL
 	}
+	return db.Dialect
+}
 
-	return result
+// bind - rewrite query for the dialect of the database
+func (db *Database) bind(query string) string {
+	return Bind(db.dialect(), query)
 }
 
 // attempt to connect to the database with retries <= connectionRetryAttempts
+// - the pool is opened once, later connection failures are handled by the pool replacing broken connections
 func (db *Database) attemptConnection() error {
-	var err error
+	err := db.open()
+	if err != nil {
+		return err
+	}
 
 	for attempt := 0; attempt < connectionRetryAttempts; attempt++ {
-		db.DB, err = sql.Open(dbDriverName, connStr)
-		if err != nil {
-			time.Sleep(retrySleepSeconds * time.Second)
-			err = nil
-			continue
+		err = db.Ping()
+		if err == nil {
+			return nil
 		}
-		break
+
+		log.Warn("Waiting for datasource", "attempt", attempt+1, "error", err.Error())
+		time.Sleep(retrySleepSeconds * time.Second)
 	}
 
+	db.Close()
+	return err
+}
+
+// open - open the connection pool, no connection is made until one is needed
+func (db *Database) open() error {
+	var err error
+
+	db.DB, err = sql.Open(db.dialect().DriverName(), db.dsn)
 	if err != nil {
 		return err
 	}
 
-	// setup connection pooling for PostgreSQL
-	if dbDriverName == PostgreSQLDriverName {
-		db.SetMaxIdleConns(30)
-		db.SetMaxOpenConns(100)
-		db.SetConnMaxLifetime(time.Hour)
+	db.setPool(db.Options.Pool)
+	return nil
+}
+
+// setPool - apply the limits of cfg to the connection pool
+func (db *Database) setPool(cfg PoolConfig) {
+	// SQLite allows a single writer and every connection to :memory: is a separate database
+	if db.dialect() == SQLite {
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+		return
 	}
 
-	return nil
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
 }
 
 // check for a valid db connection
-func (db *Database) checkDBConnection() error {
-	err := db.Ping()
-	if err != nil {
-		return db.attemptConnection()
-	}
-	return nil
+// - the pool checks each connection as a query takes it, replacing broken ones, so only ctx is checked here
+func (db *Database) checkDBConnection(ctx context.Context) error {
+	return ctx.Err()
 }
 
-// NewDB - attempt to connect and return the database
-func NewDB(dataSourceName string) (*Database, error) {
+// NewDB - attempt to connect and return the database configured with opts
+func NewDB(dataSourceName string, opts Options) (*Database, error) {
 	log.Info("Connecting to datasource", "database", dataSourceName)
 
+	db, err := parseDataSource(dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	db.Options = opts
+
+	err = db.attemptConnection()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.CheckSchema {
+		err = db.checkSchemaVersion(context.Background())
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &db, nil
+}
+
+// parseDataSource - the unopened Database for dataSourceName, a data source name prefixed with the driver
+func parseDataSource(dataSourceName string) (Database, error) {
 	segs := strings.Split(dataSourceName, "://")
 	if len(segs) < 2 {
-		return nil, fmt.Errorf("protocol not specified in %s", dataSourceName)
+		return Database{}, fmt.Errorf("protocol not specified in %s", dataSourceName)
 	}
 
+	db := Database{}
+
 	if segs[0] == MySQLDriverName {
-		dbDriverName = MySQLDriverName
-		connStr = segs[1]
+		// timestamps are scanned into time.Time so the driver must parse them
+		cfg, err := mysql.ParseDSN(segs[1])
+		if err != nil {
+			return db, err
+		}
+		cfg.ParseTime = true
+
+		// the driver sends and parses times as UTC, so the session reads TIMESTAMP literals as UTC too
+		// whatever the time zone of the server
+		cfg.Loc = time.UTC
+		if cfg.Params == nil {
+			cfg.Params = make(map[string]string)
+		}
+		cfg.Params["time_zone"] = "'+00:00'"
+
+		// report matched rather than changed rows so updates that change nothing are not "not found"
+		cfg.ClientFoundRows = true
+
+		db.Dialect = MySQL
+		db.dsn = cfg.FormatDSN()
 	}
 
 	if segs[0] == PostgreSQLDriverName {
-		dbDriverName = PostgreSQLDriverName
-		connStr = dataSourceName
+		db.Dialect = PostgreSQL
+		db.dsn = dataSourceName
 	}
 
-	if dbDriverName == "" {
-		return nil, fmt.Errorf("protocol %s not supported in %s", segs[0], dataSourceName)
+	if segs[0] == SQLiteDriverName {
+		// the cascades on ftp_mapping need foreign keys which SQLite disables by default
+		db.Dialect = SQLite
+		db.dsn = segs[1]
+		if strings.Contains(db.dsn, "?") {
+			db.dsn += "&"
+		} else {
+			db.dsn += "?"
+		}
+		db.dsn += "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
 	}
 
-	db := Database{}
-	err := db.attemptConnection()
-
-	if err != nil {
-		return nil, err
+	if db.Dialect == nil {
+		return db, fmt.Errorf("protocol %s not supported in %s", segs[0], dataSourceName)
 	}
 
-	return &db, nil
+	return db, nil
 }
 
-// QueryForDriver - perform the normal Query method after formatting the query based on the driver
-func (db *Database) QueryForDriver(query string, args ...interface{}) (*sql.Rows, error) {
-	qry := fmtQueryForDriver(query)
-	return db.Query(qry, args...)
+// withQueryTimeout - limit ctx to the QueryTimeout of db, ctx is returned unchanged when there is no timeout
+func (db *Database) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.Options.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, db.Options.QueryTimeout)
 }
 
-// ExecForDriver - perform the normal Exec method after formatting the query based on the driver
-func (db *Database) ExecForDriver(query string, args ...interface{}) (sql.Result, error) {
-	qry := fmtQueryForDriver(query)
-	return db.Exec(qry, args...)
+// contextErr - report a failure caused by ctx ending as the context error
+// - drivers describe a cancelled query in their own words, e.g. PostgreSQL's "canceling statement due to user request"
+func contextErr(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
 }
 
-// QueryRowForDriver - perform the normal QueryRow method after formatting the query based on the driver
-func (db *Database) QueryRowForDriver(query string, args ...interface{}) *sql.Row {
-	qry := fmtQueryForDriver(query)
-	return db.QueryRow(qry, args...)
+// conn - the transaction when there is one, otherwise the connection pool
+func (db *Database) conn() queryer {
+	if db.tx != nil {
+		return db.tx
+	}
+	return db.DB
 }
 
-// FtpUserLookup - retrieve the FtpUser for the ftp_account entry that corresponds to the supplied username
-func (db *Database) FtpUserLookup(username string) (sftpgo.User, error) {
-	var user sftpgo.User
+// QueryForDriver - perform the normal QueryContext method after formatting the query based on the driver
+func (db *Database) QueryForDriver(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	qry := db.bind(query)
+	rows, err := db.conn().QueryContext(ctx, qry, bindArgs(db.dialect(), args)...)
+	return rows, contextErr(ctx, err)
+}
 
-	if dbErr := db.checkDBConnection(); dbErr != nil {
-		return user, dbErr
-	}
+// ExecForDriver - perform the normal ExecContext method after formatting the query based on the driver
+func (db *Database) ExecForDriver(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	markWrite(ctx)
+	qry := db.bind(query)
+	result, err := db.conn().ExecContext(ctx, qry, bindArgs(db.dialect(), args)...)
+	return result, contextErr(ctx, err)
+}
 
-	qry := "select a.`id`, a.`username`, a.`description`, a.`password`, m.`id` `folder` "
-	qry += "from `ftp_account` a "
-	qry += "inner join `ftp_mapping` m "
-	qry += "on a.`id` = m.`ftp_id` "
-	qry += "where a.`username` = ? "
-	qry += "and m.`system` = 'BillSys1'"
+// QueryRowForDriver - perform the normal QueryRowContext method after formatting the query based on the driver
+func (db *Database) QueryRowForDriver(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	qry := db.bind(query)
+	return db.conn().QueryRowContext(ctx, qry, bindArgs(db.dialect(), args)...)
+}
 
-	results, err := db.QueryForDriver(qry, username)
-	if err != nil {
-		return user, err
-	}
-	defer results.Close()
+// LookupSystem - the system whose mappings name the folders of the user returned by FtpUserLookup
+const LookupSystem = "BillSys1"
 
-	userFound := false
-	for results.Next() {
-		userFound = true
-		vf := vfs.VirtualFolder{}
+// NewLookupUser - build the sftpgo user for account with a virtual folder for each of the mapped ids in folders
+// - an account with only one folder has it mapped to the root instead
+func NewLookupUser(account FtpUser, folders []string) sftpgo.User {
+	var user sftpgo.User
 
-		err = results.Scan(&user.ID, &user.Username, &user.Description, &user.Password, &vf.Name)
-		if err != nil {
-			return user, err
-		}
+	user.ID = int64(account.ID)
+	user.Username = account.Username
+	user.Description = account.Description
+	user.Password = account.Password
 
+	for _, folder := range folders {
+		vf := vfs.VirtualFolder{}
+		vf.Name = folder
 		vf.VirtualPath = "/" + vf.Name
 
 		vf.FsConfig.Provider = sdk.AzureBlobFilesystemProvider
@@ -324,65 +585,166 @@ func (db *Database) FtpUserLookup(username string) (sftpgo.User, error) {
 		user.VirtualFolders = nil
 	}
 
+	return user
+}
+
+// FtpUserLookup - retrieve the FtpUser for the ftp_account entry that corresponds to the supplied username
+// - read from a replica when there is one
+func (db *Database) FtpUserLookup(ctx context.Context, username string) (sftpgo.User, error) {
+	var user sftpgo.User
+	err := db.read(ctx, func(r *Database) (err error) {
+		user, err = r.ftpUserLookup(ctx, username)
+		return err
+	})
+	return user, err
+}
+
+func (db *Database) ftpUserLookup(ctx context.Context, username string) (sftpgo.User, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	var user sftpgo.User
+
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
+		return user, dbErr
+	}
+
+	qry := "select a.`id`, a.`username`, a.`description`, a.`password`, m.`id` `folder` "
+	qry += "from `ftp_account` a "
+	qry += "inner join `ftp_mapping` m "
+	qry += "on a.`id` = m.`ftp_id` "
+	qry += "where a.`username` = ? "
+	qry += "and a.`deleted_at` is null "
+	qry += "and m.`system` = '" + LookupSystem + "'"
+
+	results, err := db.QueryForDriver(ctx, qry, username)
+	if err != nil {
+		return user, err
+	}
+	defer results.Close()
+
+	var (
+		account FtpUser
+		folders []string
+	)
+	for results.Next() {
+		var folder string
+
+		err = results.Scan(&account.ID, &account.Username, &account.Description, &account.Password, &folder)
+		if err != nil {
+			return user, err
+		}
+
+		folders = append(folders, folder)
+	}
+
 	err = results.Err()
 	if err != nil {
 		log.Error(err.Error())
 		return user, err
 	}
 
-	if !userFound {
-		err = errors.New(ErrUserNotFound)
+	if len(folders) == 0 {
+		err = ErrUserNotFound
 		return user, err
 	}
 
-	return user, nil
+	return NewLookupUser(account, folders), nil
 }
 
 // MappingDelete - delete the mapping associated with the provided system and systemid
-func (db *Database) MappingDelete(system string, id string) (int64, error) {
-	if dbErr := db.checkDBConnection(); dbErr != nil {
+// - when version is set the mapping is only deleted if it has not been modified since
+func (db *Database) MappingDelete(ctx context.Context, system string, id string, version *time.Time) (int64, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
 		return 0, dbErr
 	}
 
-	qry := "delete from `ftp_mapping` where `system` = ? and `id` = ?"
+	qry, args := versionClause("delete from `ftp_mapping` where `system` = ? and `id` = ?", []interface{}{system, id}, version)
 
-	result, err := db.ExecForDriver(qry, system, id)
+	result, err := db.ExecForDriver(ctx, qry, args...)
 	if err != nil {
 		log.Error(err.Error())
 		return 0, err
 	}
 
 	rows, err := result.RowsAffected()
-	return rows, err
+	if err != nil {
+		return rows, err
+	}
+
+	if rows == 0 && version != nil {
+		exists, err := db.mappingExists(ctx, system, id, nil)
+		if err != nil {
+			return 0, err
+		}
+		if exists {
+			return 0, ErrMappingModified
+		}
+	}
+
+	return rows, nil
+}
+
+// mappingExists - report whether the mapping exists, limited to the version when provided
+func (db *Database) mappingExists(ctx context.Context, system string, id string, version *time.Time) (bool, error) {
+	var count int
+
+	qry, args := versionClause("select count(`id`) from `ftp_mapping` where `system` = ? and `id` = ?", []interface{}{system, id}, version)
+
+	err := db.QueryRowForDriver(ctx, qry, args...).Scan(&count)
+	if err != nil {
+		log.Error(err.Error())
+		return false, err
+	}
+
+	return count > 0, nil
 }
 
 // MappingRetrieve - retrieve the mapping associated with the provided system and systemid
-func (db *Database) MappingRetrieve(system string, id string) (Mapping, error) {
+// - read from a replica when there is one
+func (db *Database) MappingRetrieve(ctx context.Context, system string, id string) (Mapping, error) {
+	var mapping Mapping
+	err := db.read(ctx, func(r *Database) (err error) {
+		mapping, err = r.mappingRetrieve(ctx, system, id)
+		return err
+	})
+	return mapping, err
+}
+
+func (db *Database) mappingRetrieve(ctx context.Context, system string, id string) (Mapping, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
 	var mapping Mapping
 
-	if dbErr := db.checkDBConnection(); dbErr != nil {
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
 		return mapping, dbErr
 	}
 
 	mapping.ID = id
 	mapping.System = system
 
-	qry := "select a.`id`, a.`username`, a.`description` "
+	qry := "select a.`id`, a.`username`, a.`description`, m.`updated_on` "
 	qry += "from `ftp_mapping` m "
 	qry += "inner join `ftp_account` a on m.`ftp_id` = a.`id` "
-	qry += "where m.`system` = ? and m.`id` = ?"
+	qry += "where m.`system` = ? and m.`id` = ? and a.`deleted_at` is null"
 
-	results, err := db.QueryForDriver(qry, system, id)
+	results, err := db.QueryForDriver(ctx, qry, system, id)
 	if err != nil {
 		return mapping, err
 	}
 	defer results.Close()
 
 	if results.Next() {
-		err = results.Scan(&mapping.FTPAccount.ID, &mapping.FTPAccount.Username, &mapping.FTPAccount.Description)
+		var updatedOn time.Time
+		err = results.Scan(&mapping.FTPAccount.ID, &mapping.FTPAccount.Username, &mapping.FTPAccount.Description, &updatedOn)
 		if err != nil {
 			return mapping, err
 		}
+		mapping.UpdatedOn = &updatedOn
 	} else {
 		err = results.Err()
 		if err != nil {
@@ -390,105 +752,286 @@ func (db *Database) MappingRetrieve(system string, id string) (Mapping, error) {
 			return mapping, err
 		}
 
-		err = errors.New(ErrMappingNotFound)
+		err = ErrMappingNotFound
 		return mapping, err
 	}
 	return mapping, nil
 }
 
-// MappingCreate - insert a new mapping for the given system, system_id and ftp_id
-func (db *Database) MappingCreate(mapping NewMapping) (int, error) {
-	if dbErr := db.checkDBConnection(); dbErr != nil {
-		return 0, dbErr
+// MappingCreate - insert a new mapping for the given system, system_id and ftp_id, or update the existing one
+// - soft deleted ftp accounts are treated as not found, whether or not the mapping exists
+// - when mapping.UpdatedOn is set only the existing mapping with that version is updated
+// - ErrSystemNotRegistered is returned for a system that is not registered when Options.RequireRegisteredSystems is set
+func (db *Database) MappingCreate(ctx context.Context, mapping NewMapping) (int, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
+		return MappingError, dbErr
 	}
 
-	// attempt insert first
-	qry := "insert into `ftp_mapping` (`system`, `id`, `ftp_id`) values (?, ?, ?)"
+	if err := db.checkSystemRegistered(ctx, mapping.System); err != nil {
+		return MappingError, err
+	}
 
-	_, err := db.ExecForDriver(qry, mapping.System, mapping.SystemID, mapping.FTPAccountID)
+	// a versioned write must not recreate a mapping deleted since it was retrieved
+	if mapping.UpdatedOn != nil {
+		return db.mappingUpdate(ctx, mapping)
+	}
+
+	status, err := db.mappingUpsert(ctx, mapping)
 	if err != nil {
-		// if key exists try update
-		if checkPrimaryKeyErr(err) {
-			qry = "update `ftp_mapping` set `ftp_id` = ? where `system` = ? and `id` = ?"
+		// the account was removed between the select and the write
+		if isForeignKeyViolation(err) {
+			return MappingFTPAccountNotFound, nil
+		}
 
-			_, err = db.ExecForDriver(qry, mapping.FTPAccountID, mapping.System, mapping.SystemID)
-			if err != nil {
-				if checkForeignKeyErr(err) {
-					return MappingFTPAccountNotFound, nil
-				}
+		log.Error(err.Error())
+		return MappingError, contextErr(ctx, err)
+	}
 
-				return MappingError, err
+	return status, nil
+}
+
+// mappingUpsert - insert mapping, or update the existing mapping, in a single statement
+// - nothing is written when the account is not found as the row is selected from ftp_account
+func (db *Database) mappingUpsert(ctx context.Context, mapping NewMapping) (int, error) {
+	d := db.dialect()
+
+	qry := "insert into `ftp_mapping` (`system`, `id`, `ftp_id`) "
+	qry += "select ?, ?, `id` from `ftp_account` where `id` = ? and `deleted_at` is null"
+	qry += d.Upsert([]string{"system", "id"}, "`ftp_id` = "+d.Excluded("ftp_id")+", `updated_on` = current_timestamp(6)")
+
+	args := []interface{}{mapping.System, mapping.SystemID, mapping.FTPAccountID}
+
+	if inserted := d.Inserted(); inserted != "" {
+		markWrite(ctx)
+
+		var isInserted bool
+		err := db.QueryRowForDriver(ctx, qry+" returning "+inserted, args...).Scan(&isInserted)
+		if errors.Is(err, sql.ErrNoRows) {
+			return MappingFTPAccountNotFound, nil
+		}
+		if err != nil {
+			return MappingError, err
+		}
+		if isInserted {
+			return MappingInserted, nil
+		}
+
+		return MappingUpdated, nil
+	}
+
+	// SQLite counts a row either way, its single connection keeps the check and the upsert together
+	if d == SQLite {
+		var (
+			exists bool
+			rows   int64
+		)
+		err := db.inTx(ctx, func(tx *Database) error {
+			var err error
+			exists, err = tx.mappingExists(ctx, mapping.System, mapping.SystemID, nil)
+			if err != nil {
+				return err
 			}
 
+			rows, err = tx.mappingWrite(ctx, qry, args)
+			return err
+		})
+		switch {
+		case err != nil:
+			return MappingError, err
+		case rows == 0:
+			return MappingFTPAccountNotFound, nil
+		case exists:
 			return MappingUpdated, nil
 		}
 
+		return MappingInserted, nil
+	}
+
+	// MySQL counts an inserted row once and an updated row twice
+	rows, err := db.mappingWrite(ctx, qry, args)
+	switch {
+	case err != nil:
+		return MappingError, err
+	case rows == 0:
+		return MappingFTPAccountNotFound, nil
+	case rows == 1:
+		return MappingInserted, nil
+	}
+
+	return MappingUpdated, nil
+}
+
+// mappingWrite - run the mapping write qry and return the number of rows affected
+func (db *Database) mappingWrite(ctx context.Context, qry string, args []interface{}) (int64, error) {
+	result, err := db.ExecForDriver(ctx, qry, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// mappingUpdate - update the mapping with version mapping.UpdatedOn
+func (db *Database) mappingUpdate(ctx context.Context, mapping NewMapping) (int, error) {
+	qry := "update `ftp_mapping` set `ftp_id` = ?, `updated_on` = current_timestamp(6) where `system` = ? and `id` = ? "
+	qry += "and exists (select 1 from `ftp_account` where `id` = ? and `deleted_at` is null)"
+
+	qry, args := versionClause(qry, []interface{}{mapping.FTPAccountID, mapping.System, mapping.SystemID, mapping.FTPAccountID}, mapping.UpdatedOn)
+
+	rows, err := db.mappingWrite(ctx, qry, args)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return MappingFTPAccountNotFound, nil
+		}
+
 		return MappingError, err
 	}
+	if rows == 0 {
+		// nothing matched either because the account is missing or the mapping changed
+		exists, err := db.mappingExists(ctx, mapping.System, mapping.SystemID, mapping.UpdatedOn)
+		if err != nil {
+			return MappingError, err
+		}
+		if !exists {
+			return MappingModified, nil
+		}
+
+		return MappingFTPAccountNotFound, nil
+	}
 
-	return MappingInserted, nil
+	return MappingUpdated, nil
 }
 
 // FtpUserGetSelection - retrieve all ftp_account entries
 // - for the specified page and result set size (default page=1 and page_size=30)
-// - a 1 based page index is used
-func (db *Database) FtpUserGetSelection(page uint32, pageSize uint32, search string) (users FtpUsers, err error) {
-	if err = db.checkDBConnection(); err != nil {
+// - a 1 based page index is used, or the page after or before filter.Cursor when it is set
+// - narrowed by the search text and the criteria in filter
+// - read from a replica when there is one
+func (db *Database) FtpUserGetSelection(ctx context.Context, page uint32, pageSize uint32, search string, filter FtpUserFilter) (FtpUsers, error) {
+	var users FtpUsers
+	err := db.read(ctx, func(r *Database) (err error) {
+		users, err = r.ftpUserGetSelection(ctx, page, pageSize, search, filter)
+		return err
+	})
+	return users, err
+}
+
+func (db *Database) ftpUserGetSelection(ctx context.Context, page uint32, pageSize uint32, search string, filter FtpUserFilter) (users FtpUsers, err error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if err = db.checkDBConnection(ctx); err != nil {
 		return
 	}
 
 	// set the filter clause if present
-	filter := ""
-	filterClause := ""
-	if search != "" {
-		filter = "%" + search + "%"
-		filterClause = " where `username` like ? or `description` like ?"
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	if filter.Deleted {
+		conditions = append(conditions, "`deleted_at` is not null")
+	} else {
+		conditions = append(conditions, "`deleted_at` is null")
+	}
+	if !ValidSearch(search, filter.SearchMode) {
+		return users, ErrInvalidSearch
 	}
-
-	// get total number of user accounts
-	qry := "select count(`id`) from `ftp_account`" + filterClause
-
-	var result *sql.Row
 	if search != "" {
-		result = db.QueryRowForDriver(qry, filter, filter)
-	} else {
-		result = db.QueryRowForDriver(qry)
+		condition, searchArgs := db.searchCondition(search, filter.SearchMode)
+		conditions = append(conditions, condition)
+		args = append(args, searchArgs...)
 	}
-
-	err = result.Scan(&users.TotalItems)
-	if err != nil {
-		log.Error(err.Error())
-		return users, err
+	if !filter.LastLoginBefore.IsZero() {
+		conditions = append(conditions, "(`last_login_at` < ? or `last_login_at` is null)")
+		args = append(args, filter.LastLoginBefore)
+	}
+	if !filter.LastLoginAfter.IsZero() {
+		conditions = append(conditions, "`last_login_at` >= ?")
+		args = append(args, filter.LastLoginAfter)
+	}
+	if filter.MaxLoginCount != nil {
+		conditions = append(conditions, "`login_count` <= ?")
+		args = append(args, *filter.MaxLoginCount)
+	}
+	if filter.Username != "" {
+		conditions = append(conditions, "`username` = ?")
+		args = append(args, filter.Username)
+	}
+	if filter.UsernamePrefix != "" {
+		conditions = append(conditions, "`username` like ?"+likeEscape)
+		args = append(args, escapeLike(filter.UsernamePrefix)+"%")
+	}
+	if filter.DescriptionContains != "" {
+		conditions = append(conditions, "`description` like ?"+likeEscape)
+		args = append(args, "%"+escapeLike(filter.DescriptionContains)+"%")
+	}
+	if !filter.UpdatedSince.IsZero() {
+		conditions = append(conditions, "`updated_on` >= ?")
+		args = append(args, filter.UpdatedSince)
+	}
+	if len(filter.MappingSystems) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(filter.MappingSystems)), ", ")
+		conditions = append(conditions, "exists (select 1 from `ftp_mapping` where `ftp_mapping`.`ftp_id` = `ftp_account`.`id` and `ftp_mapping`.`system` in ("+placeholders+"))")
+		for _, system := range filter.MappingSystems {
+			args = append(args, system)
+		}
 	}
 
-	qry = "select `id`, `username`, `description` from `ftp_account`" + filterClause + " order by `id`"
+	filterClause := " where " + strings.Join(conditions, " and ")
+
+	// read the position to start from when paging by cursor
+	var cursor *Cursor
+	if filter.Cursor != "" {
+		c, cursorErr := ParseCursor(filter.Cursor, filter.Sort)
+		if cursorErr != nil {
+			return users, cursorErr
+		}
+		cursor = &c
+	}
 
 	// set default page and page_size if not provided
 	if pageSize == 0 {
 		pageSize = 30
 	}
-	if page == 0 {
+	if page == 0 || cursor != nil {
 		page = 1
 	}
 
-	// set the offset into the ftp_users data set
-	offset := (page - 1) * pageSize
+	// get total number of user accounts
+	if !filter.SkipTotal {
+		qry := "select count(`id`) from `ftp_account`" + filterClause
 
-	users.TotalPages = users.TotalItems / pageSize
-	if users.TotalItems%pageSize > 0 {
-		users.TotalPages++
-	}
+		err = db.QueryRowForDriver(ctx, qry, args...).Scan(&users.TotalItems)
+		if err != nil {
+			log.Error(err.Error())
+			return users, err
+		}
 
-	// add the limit clause to the query and get proper argument order
-	qry += getLimitClauseForDriver(pageSize, offset)
+		users.TotalPages = users.TotalItems / pageSize
+		if users.TotalItems%pageSize > 0 {
+			users.TotalPages++
+		}
+	}
 
-	var results *sql.Rows
-	if search != "" {
-		results, err = db.QueryForDriver(qry, filter, filter)
-	} else {
-		results, err = db.QueryForDriver(qry)
+	// set the offset into the ftp_users data set, or the rows past the cursor
+	offset := (page - 1) * pageSize
+	before := false
+	if cursor != nil {
+		condition, cursorArgs := keysetCondition(*cursor)
+		filterClause += " and " + condition
+		args = append(args, cursorArgs...)
+		before = cursor.Before
 	}
 
+	// read one row past the page to learn whether there is another page
+	qry := "select " + ftpUserColumns + " from `ftp_account`" + filterClause + getOrderClause(filter.Sort, before) +
+		db.dialect().LimitOffset(pageSize+1, offset)
+
+	results, err := db.QueryForDriver(ctx, qry, args...)
 	if err != nil {
 		log.Error(err.Error())
 		return users, err
@@ -497,7 +1040,7 @@ func (db *Database) FtpUserGetSelection(page uint32, pageSize uint32, search str
 
 	for results.Next() {
 		var user FtpUser
-		err = results.Scan(&user.ID, &user.Username, &user.Description)
+		err = scanFtpUser(results, &user)
 		if err != nil {
 			break
 		}
@@ -517,20 +1060,65 @@ func (db *Database) FtpUserGetSelection(page uint32, pageSize uint32, search str
 		return users, err
 	}
 
+	more := uint32(len(users.Ftpusers)) > pageSize
+	if more {
+		users.Ftpusers = users.Ftpusers[:pageSize]
+	}
+	if before {
+		for i, j := 0, len(users.Ftpusers)-1; i < j; i, j = i+1, j-1 {
+			users.Ftpusers[i], users.Ftpusers[j] = users.Ftpusers[j], users.Ftpusers[i]
+		}
+	}
+	setCursors(&users, filter.Sort, cursor, more, offset > 0)
+
 	return users, nil
 }
 
+// setCursors - set the Next and Prev tokens of a page read from cursor, or by index when cursor is nil
+// - more reports a row beyond the page in the direction read, skipped a row before a page read by index
+// - a page read from a cursor always has rows on the side of the cursor, even if they have since been deleted
+func setCursors(users *FtpUsers, sort string, cursor *Cursor, more bool, skipped bool) {
+	if len(users.Ftpusers) == 0 {
+		return
+	}
+
+	next, prev := more, skipped
+	if cursor != nil {
+		next, prev = more || cursor.Before, !cursor.Before || more
+	}
+
+	if next {
+		users.Next = NewCursor(users.Ftpusers[len(users.Ftpusers)-1], sort, false)
+	}
+	if prev {
+		users.Prev = NewCursor(users.Ftpusers[0], sort, true)
+	}
+}
+
 // FtpUserGet - retrieve the ftp_account entry associated with id
-func (db *Database) FtpUserGet(id uint32) (FtpUser, error) {
+// - read from a replica when there is one
+func (db *Database) FtpUserGet(ctx context.Context, id uint32) (FtpUser, error) {
+	var user FtpUser
+	err := db.read(ctx, func(r *Database) (err error) {
+		user, err = r.ftpUserGet(ctx, id)
+		return err
+	})
+	return user, err
+}
+
+func (db *Database) ftpUserGet(ctx context.Context, id uint32) (FtpUser, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
 	var user FtpUser
 
-	if dbErr := db.checkDBConnection(); dbErr != nil {
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
 		return user, dbErr
 	}
 
-	qry := "select `id`, `username`, `description` from `ftp_account` where `id` = ?"
+	qry := "select " + ftpUserColumns + " from `ftp_account` where `id` = ? and `deleted_at` is null"
 
-	results, err := db.QueryForDriver(qry, id)
+	results, err := db.QueryForDriver(ctx, qry, id)
 	if err != nil {
 		log.Error(err.Error())
 		return user, err
@@ -538,7 +1126,7 @@ func (db *Database) FtpUserGet(id uint32) (FtpUser, error) {
 	defer results.Close()
 
 	if results.Next() {
-		err = results.Scan(&user.ID, &user.Username, &user.Description)
+		err = scanFtpUser(results, &user)
 		if err != nil {
 			return user, err
 		}
@@ -549,54 +1137,108 @@ func (db *Database) FtpUserGet(id uint32) (FtpUser, error) {
 			return user, err
 		}
 
-		err = errors.New(ErrUserNotFound)
+		err = ErrUserNotFound
 		return user, err
 	}
 	return user, nil
 }
 
-// FtpUserCreate - create a ftp_account with the provided parameters
-func (db *Database) FtpUserCreate(user FtpUser) (uint32, error) {
-	if dbErr := db.checkDBConnection(); dbErr != nil {
-		return 0, dbErr
+// FtpUserCreate - create a ftp_account with the provided parameters and return the created row
+// - the insert and the read back share a transaction so the row returned is the one inserted
+func (db *Database) FtpUserCreate(ctx context.Context, user FtpUser) (FtpUser, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	var created FtpUser
+
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
+		return created, dbErr
 	}
 
-	qry := "insert into `ftp_account` (`username`, `description`, `password`) values (?, ?, ?)"
+	err := db.inTx(ctx, func(tx *Database) error {
+		id, err := tx.insertFtpUser(ctx, user)
+		if err != nil {
+			if isUniqueViolation(err) {
+				return ErrFTPAccountExists
+			}
+			return err
+		}
+
+		qry := "select " + ftpUserColumns + " from `ftp_account` where `id` = ?"
 
-	_, err := db.ExecForDriver(qry, user.Username, user.Description, user.Password)
+		return scanFtpUser(tx.QueryRowForDriver(ctx, qry, id), &created)
+	})
 	if err != nil {
-		if checkPrimaryKeyErr(err) {
-			e := errors.New(ErrFTPAccountExists)
-			return 0, e
+		if err != ErrFTPAccountExists {
+			log.Error(err.Error())
 		}
-		log.Error(err.Error())
-		return 0, err
+		return FtpUser{}, contextErr(ctx, err)
 	}
 
-	var id int
-	qry = "select min(`id`) from `ftp_account` where `username` = ?"
+	return created, nil
+}
+
+// insertFtpUser - insert the ftp_account for user and return its generated id
+// - the id is read through returning where the dialect supports it, otherwise through LastInsertId
+func (db *Database) insertFtpUser(ctx context.Context, user FtpUser) (int64, error) {
+	qry := "insert into `ftp_account` (`username`, `description`, `password`) values (?, ?, ?)"
 
-	row := db.QueryRowForDriver(qry, user.Username)
+	var id int64
+
+	if returning := db.dialect().Returning("id"); returning != "" {
+		err := db.QueryRowForDriver(ctx, qry+returning, user.Username, user.Description, user.Password).Scan(&id)
+		return id, err
+	}
 
-	err = row.Scan(&id)
+	result, err := db.ExecForDriver(ctx, qry, user.Username, user.Description, user.Password)
 	if err != nil {
-		log.Error(err.Error())
-		return 0, err
+		return id, err
 	}
 
-	return uint32(id), nil
+	return result.LastInsertId()
 }
 
 // FtpUserUpdate - update an ftp_account specified by the ftp user provided
-func (db *Database) FtpUserUpdate(user FtpUser) error {
-	if dbErr := db.checkDBConnection(); dbErr != nil {
+// - when user.UpdatedOn is set the account is only updated if it has not been modified since
+func (db *Database) FtpUserUpdate(ctx context.Context, user FtpUser) error {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
 		return dbErr
 	}
 
-	qry := "update `ftp_account` set `username` = ?, `description` = ?, `updated_on` = current_timestamp where `id` = ?"
+	qry := "update `ftp_account` set `username` = ?, `description` = ?, `updated_on` = current_timestamp(6) where `id` = ? and `deleted_at` is null"
+	qry, args := versionClause(qry, []interface{}{user.Username, user.Description, user.ID}, user.UpdatedOn)
+
+	return db.ftpUserWrite(ctx, user.ID, user.UpdatedOn, qry, args...)
+}
+
+// FtpUserDelete - soft delete the ftp_account specified by the id provided
+// - the account and its mappings are kept until purged so the account can be restored
+// - when version is set the account is only deleted if it has not been modified since
+func (db *Database) FtpUserDelete(ctx context.Context, id uint32, version *time.Time) error {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
+		return dbErr
+	}
+
+	qry, args := versionClause("update `ftp_account` set `deleted_at` = current_timestamp where `id` = ? and `deleted_at` is null", []interface{}{id}, version)
+
+	return db.ftpUserWrite(ctx, id, version, qry, args...)
+}
 
-	result, err := db.ExecForDriver(qry, user.Username, user.Description, user.ID)
+// ftpUserWrite - execute a write against a single active ftp_account
+// - ErrFTPAccountModified is returned when a versioned write matches nothing but the account exists
+// - ErrFTPAccountExists is returned when the write would duplicate another account's username
+func (db *Database) ftpUserWrite(ctx context.Context, id uint32, version *time.Time, qry string, args ...interface{}) error {
+	result, err := db.ExecForDriver(ctx, qry, args...)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrFTPAccountExists
+		}
 		log.Error(err.Error())
 		return err
 	}
@@ -608,22 +1250,37 @@ func (db *Database) FtpUserUpdate(user FtpUser) error {
 	}
 
 	if rows == 0 {
-		e := errors.New(ErrFTPAccountNotFound)
-		return e
+		if version != nil {
+			var count int
+			qry = "select count(`id`) from `ftp_account` where `id` = ? and `deleted_at` is null"
+			err = db.QueryRowForDriver(ctx, qry, id).Scan(&count)
+			if err != nil {
+				log.Error(err.Error())
+				return err
+			}
+			if count > 0 {
+				return ErrFTPAccountModified
+			}
+		}
+
+		return ErrFTPAccountNotFound
 	}
 
 	return nil
 }
 
-// FtpUserDelete - delete the ftp_account specified by the id provided
-func (db *Database) FtpUserDelete(id uint32) error {
-	if dbErr := db.checkDBConnection(); dbErr != nil {
+// FtpUserRestore - restore the soft deleted ftp_account specified by the id provided along with its mappings
+func (db *Database) FtpUserRestore(ctx context.Context, id uint32) error {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
 		return dbErr
 	}
 
-	qry := "delete from `ftp_account` where `id` = ?"
+	qry := "update `ftp_account` set `deleted_at` = null where `id` = ? and `deleted_at` is not null"
 
-	result, err := db.ExecForDriver(qry, id)
+	result, err := db.ExecForDriver(ctx, qry, id)
 	if err != nil {
 		log.Error(err.Error())
 		return err
@@ -636,22 +1293,61 @@ func (db *Database) FtpUserDelete(id uint32) error {
 	}
 
 	if rows == 0 {
-		e := errors.New(ErrFTPAccountNotFound)
-		return e
+		return ErrDeletedFTPAccount
 	}
 
 	return nil
 }
 
+// FtpUserPurge - permanently remove the ftp_account entries soft deleted before the provided time
+// - the mappings of the purged accounts are removed by the on delete cascade constraint
+func (db *Database) FtpUserPurge(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
+		return 0, dbErr
+	}
+
+	qry := "delete from `ftp_account` where `deleted_at` < ?"
+
+	result, err := db.ExecForDriver(ctx, qry, before)
+	if err != nil {
+		log.Error(err.Error())
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
 // FtpUserUpdatePassword - update the password on an ftp_account specified by the ftp user provided
-func (db *Database) FtpUserUpdatePassword(user FtpUser) error {
-	if dbErr := db.checkDBConnection(); dbErr != nil {
+// - when user.UpdatedOn is set the password is only updated if the account has not been modified since
+func (db *Database) FtpUserUpdatePassword(ctx context.Context, user FtpUser) error {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
 		return dbErr
 	}
 
-	qry := "update `ftp_account` set `password` = ?, `updated_on` = current_timestamp where `id` = ?"
+	qry := "update `ftp_account` set `password` = ?, `updated_on` = current_timestamp(6) where `id` = ? and `deleted_at` is null"
+	qry, args := versionClause(qry, []interface{}{user.Password, user.ID}, user.UpdatedOn)
 
-	result, err := db.ExecForDriver(qry, user.Password, user.ID)
+	return db.ftpUserWrite(ctx, user.ID, user.UpdatedOn, qry, args...)
+}
+
+// FtpUserRecordLogin - record a successful login from ip against the ftp_account specified by id
+func (db *Database) FtpUserRecordLogin(ctx context.Context, id uint32, ip string) error {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
+		return dbErr
+	}
+
+	qry := "update `ftp_account` set `last_login_at` = current_timestamp, `last_login_ip` = ?, `login_count` = `login_count` + 1 where `id` = ? and `deleted_at` is null"
+
+	result, err := db.ExecForDriver(ctx, qry, ip, id)
 	if err != nil {
 		log.Error(err.Error())
 		return err
@@ -664,8 +1360,7 @@ func (db *Database) FtpUserUpdatePassword(user FtpUser) error {
 	}
 
 	if rows == 0 {
-		e := errors.New(ErrFTPAccountNotFound)
-		return e
+		return ErrFTPAccountNotFound
 	}
 
 	return nil
@@ -673,19 +1368,32 @@ func (db *Database) FtpUserUpdatePassword(user FtpUser) error {
 
 // SystemIDUserRetrieve - retrieve all of the SystemID and Username
 // pairs associated with the provided system
-func (db *Database) SystemIDUserRetrieve(system string) (map[string]string, error) {
+// - read from a replica when there is one
+func (db *Database) SystemIDUserRetrieve(ctx context.Context, system string) (map[string]string, error) {
+	var result map[string]string
+	err := db.read(ctx, func(r *Database) (err error) {
+		result, err = r.systemIDUserRetrieve(ctx, system)
+		return err
+	})
+	return result, err
+}
+
+func (db *Database) systemIDUserRetrieve(ctx context.Context, system string) (map[string]string, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
 	result := make(map[string]string)
 
-	if dbErr := db.checkDBConnection(); dbErr != nil {
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
 		return result, dbErr
 	}
 
 	qry := "select distinct m.`id`, a.`username` "
 	qry += "from `ftp_mapping` m "
 	qry += "inner join `ftp_account` a on m.`ftp_id` = a.`id` "
-	qry += "where m.`system` = ?"
+	qry += "where m.`system` = ? and a.`deleted_at` is null"
 
-	results, err := db.QueryForDriver(qry, system)
+	results, err := db.QueryForDriver(ctx, qry, system)
 	if err != nil {
 		log.Error(err.Error())
 		return result, err
@@ -0,0 +1,1120 @@
+// Package datastoretest provides a conformance suite for implementations of data.Datastore
+//
+// The suite checks behaviour rather than queries, so every backend can be held to the same rules:
+//
+//	func TestConformance(t *testing.T) {
+//		datastoretest.Run(t, func(t *testing.T, opts data.Options) data.Datastore {
+//			return memory.NewWithOptions(opts)
+//		})
+//	}
+package datastoretest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halt-joe/ftp-user-svc/data"
+)
+
+// ctx - the context of every call made by the suite
+var ctx = context.Background()
+
+// NewStore - return an empty Datastore configured with opts for a single test, any cleanup is registered with t
+type NewStore func(t *testing.T, opts data.Options) data.Datastore
+
+// Run - run every conformance test against a fresh Datastore from newStore
+// - each test uses the data.DefaultOptions changed by its options
+func Run(t *testing.T, newStore NewStore) {
+	tests := []struct {
+		name    string
+		options func(opts *data.Options)
+		test    func(t *testing.T, db data.Datastore)
+	}{
+		{name: "Create", test: testCreate},
+		{name: "Update", test: testUpdate},
+		{name: "UpdatePassword", test: testUpdatePassword},
+		{name: "Delete", test: testDelete},
+		{name: "Cascade", test: testCascade},
+		{name: "Pagination", test: testPagination},
+		{name: "CursorPagination", test: testCursorPagination},
+		{name: "Search", test: testSearch},
+		{name: "Filter", test: testFilter},
+		{name: "MappingUpsert", test: testMappingUpsert},
+		{name: "MappingDelete", test: testMappingDelete},
+		{name: "MappingSelection", test: testMappingSelection},
+		{name: "Systems", test: testSystems},
+		{name: "RequireRegisteredSystems", options: func(opts *data.Options) { opts.RequireRegisteredSystems = true }, test: testRequireRegisteredSystems},
+		{name: "LookupFolders", test: testLookupFolders},
+		{name: "LoginHistory", test: testLoginHistory},
+		{name: "LoginHistoryLimit", options: func(opts *data.Options) { opts.LoginHistoryLimit = 2 }, test: testLoginHistoryLimit},
+		{name: "AuditLog", test: testAuditLog},
+		{name: "CancelledContext", test: testCancelledContext},
+		{name: "Transaction", test: testTransaction},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := data.DefaultOptions()
+			if tt.options != nil {
+				tt.options(&opts)
+			}
+			tt.test(t, newStore(t, opts))
+		})
+	}
+}
+
+// tick - wait long enough for the next write to record a later time
+// - backends may only keep millisecond precision so writes within the same millisecond share a version
+func tick() {
+	time.Sleep(2 * time.Millisecond)
+}
+
+// expectErr - fail unless err is the data package error expected
+func expectErr(t *testing.T, err error, expected error) {
+	t.Helper()
+
+	if !errors.Is(err, expected) {
+		t.Errorf("expected error %q but received %v", expected, err)
+	}
+}
+
+// createUser - create an account and fail the test if it cannot be created
+func createUser(t *testing.T, db data.Datastore, username string, description string) uint32 {
+	t.Helper()
+
+	user, err := db.FtpUserCreate(ctx, data.FtpUser{Username: username, Description: description, Password: username + "-password"})
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserCreate %s", err)
+	}
+
+	return user.ID
+}
+
+// createMapping - create a mapping and fail the test unless it is inserted
+func createMapping(t *testing.T, db data.Datastore, system string, systemID string, ftpID uint32) {
+	t.Helper()
+
+	result, err := db.MappingCreate(ctx, data.NewMapping{System: system, SystemID: systemID, FTPAccountID: ftpID})
+	if err != nil {
+		t.Fatalf("unexpected error from MappingCreate %s", err)
+	}
+	if result != data.MappingInserted {
+		t.Fatalf("expected mapping %s %s to be inserted but received %d", system, systemID, result)
+	}
+}
+
+// selectionIDs - the ids of the accounts returned by FtpUserGetSelection in order
+func selectionIDs(t *testing.T, db data.Datastore, page uint32, pageSize uint32, search string, filter data.FtpUserFilter) ([]uint32, data.FtpUsers) {
+	t.Helper()
+
+	users, err := db.FtpUserGetSelection(ctx, page, pageSize, search, filter)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserGetSelection %s", err)
+	}
+
+	var ids []uint32
+	for _, user := range users.Ftpusers {
+		ids = append(ids, user.ID)
+	}
+
+	return ids, users
+}
+
+// expectIDs - fail unless the ids are those expected in the same order
+func expectIDs(t *testing.T, ids []uint32, expected []uint32) {
+	t.Helper()
+
+	if fmt.Sprint(ids) != fmt.Sprint(expected) {
+		t.Errorf("expected ids %v but received %v", expected, ids)
+	}
+}
+
+func testCreate(t *testing.T, db data.Datastore) {
+	first := createUser(t, db, "first", "The first user")
+	second := createUser(t, db, "second", "The second user")
+	if first == 0 || second == 0 || first == second {
+		t.Fatalf("expected distinct non zero ids but received %d and %d", first, second)
+	}
+
+	user, err := db.FtpUserGet(ctx, first)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserGet %s", err)
+	}
+	if user.ID != first || user.Username != "first" || user.Description != "The first user" {
+		t.Errorf("unexpected user returned %+v", user)
+	}
+	if user.Password != "" {
+		t.Errorf("expected the password to be withheld but received %s", user.Password)
+	}
+	if user.UpdatedOn == nil || user.DeletedAt != nil || user.LastLoginAt != nil || user.LoginCount != 0 {
+		t.Errorf("unexpected state for a new user %+v", user)
+	}
+
+	// the created row is returned as it would be read back
+	created, err := db.FtpUserCreate(ctx, data.FtpUser{Username: "third", Description: "The third user", Password: "secret"})
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserCreate %s", err)
+	}
+	read, err := db.FtpUserGet(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserGet %s", err)
+	}
+	if created.ID <= second || created.Password != "" || created.UpdatedOn == nil || !created.UpdatedOn.Equal(*read.UpdatedOn) {
+		t.Errorf("expected the created row %+v to match the stored row %+v", created, read)
+	}
+
+	_, err = db.FtpUserCreate(ctx, data.FtpUser{Username: "first", Description: "A duplicate", Password: "secret"})
+	expectErr(t, err, data.ErrFTPAccountExists)
+
+	_, err = db.FtpUserGet(ctx, second+1000)
+	expectErr(t, err, data.ErrUserNotFound)
+}
+
+func testUpdate(t *testing.T, db data.Datastore) {
+	id := createUser(t, db, "user", "A user")
+	createUser(t, db, "other", "Another user")
+
+	user, err := db.FtpUserGet(ctx, id)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserGet %s", err)
+	}
+	read := *user.UpdatedOn
+
+	tick()
+	user.Username = "renamed"
+	user.Description = "A renamed user"
+	err = db.FtpUserUpdate(ctx, user)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserUpdate %s", err)
+	}
+
+	updated, err := db.FtpUserGet(ctx, id)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserGet %s", err)
+	}
+	if updated.Username != "renamed" || updated.Description != "A renamed user" {
+		t.Errorf("unexpected user returned after update %+v", updated)
+	}
+	if !updated.UpdatedOn.After(read) {
+		t.Errorf("expected the version to move on from %s but received %s", read, updated.UpdatedOn)
+	}
+
+	// the version read before the update is now stale
+	user.UpdatedOn = &read
+	expectErr(t, db.FtpUserUpdate(ctx, user), data.ErrFTPAccountModified)
+
+	// an unconditional write ignores the version
+	user.UpdatedOn = nil
+	user.Description = "An unconditional update"
+	if err = db.FtpUserUpdate(ctx, user); err != nil {
+		t.Errorf("unexpected error from unconditional FtpUserUpdate %s", err)
+	}
+
+	user.Username = "other"
+	expectErr(t, db.FtpUserUpdate(ctx, user), data.ErrFTPAccountExists)
+
+	expectErr(t, db.FtpUserUpdate(ctx, data.FtpUser{ID: id + 1000, Username: "missing"}), data.ErrFTPAccountNotFound)
+}
+
+func testUpdatePassword(t *testing.T, db data.Datastore) {
+	id := createUser(t, db, "user", "A user")
+	createMapping(t, db, data.LookupSystem, "folder", id)
+
+	user, err := db.FtpUserGet(ctx, id)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserGet %s", err)
+	}
+
+	tick()
+	err = db.FtpUserUpdatePassword(ctx, data.FtpUser{ID: id, Password: "changed", UpdatedOn: user.UpdatedOn})
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserUpdatePassword %s", err)
+	}
+
+	lookup, err := db.FtpUserLookup(ctx, "user")
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserLookup %s", err)
+	}
+	if lookup.Password != "changed" {
+		t.Errorf("expected the changed password but received %s", lookup.Password)
+	}
+
+	expectErr(t, db.FtpUserUpdatePassword(ctx, data.FtpUser{ID: id, Password: "stale", UpdatedOn: user.UpdatedOn}), data.ErrFTPAccountModified)
+	expectErr(t, db.FtpUserUpdatePassword(ctx, data.FtpUser{ID: id + 1000, Password: "missing"}), data.ErrFTPAccountNotFound)
+}
+
+func testDelete(t *testing.T, db data.Datastore) {
+	id := createUser(t, db, "user", "A user")
+	kept := createUser(t, db, "kept", "A kept user")
+
+	user, err := db.FtpUserGet(ctx, id)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserGet %s", err)
+	}
+	stale := user.UpdatedOn.Add(-time.Second)
+	expectErr(t, db.FtpUserDelete(ctx, id, &stale), data.ErrFTPAccountModified)
+
+	err = db.FtpUserDelete(ctx, id, user.UpdatedOn)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserDelete %s", err)
+	}
+
+	_, err = db.FtpUserGet(ctx, id)
+	expectErr(t, err, data.ErrUserNotFound)
+	expectErr(t, db.FtpUserDelete(ctx, id, nil), data.ErrFTPAccountNotFound)
+	expectErr(t, db.FtpUserUpdate(ctx, data.FtpUser{ID: id, Username: "user"}), data.ErrFTPAccountNotFound)
+	expectErr(t, db.FtpUserRecordLogin(ctx, id, "10.0.0.1"), data.ErrFTPAccountNotFound)
+
+	// soft deleted accounts keep their username
+	_, err = db.FtpUserCreate(ctx, data.FtpUser{Username: "user", Password: "secret"})
+	expectErr(t, err, data.ErrFTPAccountExists)
+
+	ids, users := selectionIDs(t, db, 0, 0, "", data.FtpUserFilter{})
+	expectIDs(t, ids, []uint32{kept})
+
+	ids, users = selectionIDs(t, db, 0, 0, "", data.FtpUserFilter{Deleted: true})
+	expectIDs(t, ids, []uint32{id})
+	if len(users.Ftpusers) == 1 && users.Ftpusers[0].DeletedAt == nil {
+		t.Errorf("expected the deleted user to have deleted_at set")
+	}
+
+	expectErr(t, db.FtpUserRestore(ctx, kept), data.ErrDeletedFTPAccount)
+
+	err = db.FtpUserRestore(ctx, id)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserRestore %s", err)
+	}
+	if _, err = db.FtpUserGet(ctx, id); err != nil {
+		t.Errorf("unexpected error from FtpUserGet after restore %s", err)
+	}
+	expectErr(t, db.FtpUserRestore(ctx, id), data.ErrDeletedFTPAccount)
+}
+
+func testCascade(t *testing.T, db data.Datastore) {
+	id := createUser(t, db, "user", "A user")
+	createMapping(t, db, data.LookupSystem, "123", id)
+	createMapping(t, db, "OtherSys", "abc", id)
+
+	other := createUser(t, db, "other", "Another user")
+	createMapping(t, db, data.LookupSystem, "456", other)
+
+	if err := db.FtpUserDelete(ctx, id, nil); err != nil {
+		t.Fatalf("unexpected error from FtpUserDelete %s", err)
+	}
+
+	// the mappings of a soft deleted account are hidden until it is restored or purged
+	_, err := db.MappingRetrieve(ctx, data.LookupSystem, "123")
+	expectErr(t, err, data.ErrMappingNotFound)
+
+	ids, err := db.SystemIDUserRetrieve(ctx, data.LookupSystem)
+	if err != nil {
+		t.Fatalf("unexpected error from SystemIDUserRetrieve %s", err)
+	}
+	if fmt.Sprint(ids) != fmt.Sprint(map[string]string{"456": "other"}) {
+		t.Errorf("unexpected system ids returned %v", ids)
+	}
+
+	result, err := db.MappingCreate(ctx, data.NewMapping{System: data.LookupSystem, SystemID: "789", FTPAccountID: id})
+	if err != nil || result != data.MappingFTPAccountNotFound {
+		t.Errorf("expected a mapping to a deleted account to be not found but received %d %v", result, err)
+	}
+	result, err = db.MappingCreate(ctx, data.NewMapping{System: data.LookupSystem, SystemID: "456", FTPAccountID: id})
+	if err != nil || result != data.MappingFTPAccountNotFound {
+		t.Errorf("expected moving a mapping to a deleted account to be not found but received %d %v", result, err)
+	}
+
+	// accounts deleted after the purge time are kept
+	purged, err := db.FtpUserPurge(ctx, time.Now().Add(-time.Hour))
+	if err != nil || purged != 0 {
+		t.Errorf("expected nothing purged but received %d %v", purged, err)
+	}
+
+	purged, err = db.FtpUserPurge(ctx, time.Now().Add(time.Minute))
+	if err != nil || purged != 1 {
+		t.Fatalf("expected one account purged but received %d %v", purged, err)
+	}
+
+	expectErr(t, db.FtpUserRestore(ctx, id), data.ErrDeletedFTPAccount)
+
+	// the username is released and the mappings went with the account
+	recreated := createUser(t, db, "user", "A recreated user")
+	createMapping(t, db, data.LookupSystem, "123", recreated)
+	createMapping(t, db, "OtherSys", "abc", recreated)
+
+	ids, err = db.SystemIDUserRetrieve(ctx, data.LookupSystem)
+	if err != nil {
+		t.Fatalf("unexpected error from SystemIDUserRetrieve %s", err)
+	}
+	if fmt.Sprint(ids) != fmt.Sprint(map[string]string{"123": "user", "456": "other"}) {
+		t.Errorf("unexpected system ids returned %v", ids)
+	}
+}
+
+func testPagination(t *testing.T, db data.Datastore) {
+	var all []uint32
+	for i := 1; i <= 5; i++ {
+		all = append(all, createUser(t, db, fmt.Sprintf("user%d", i), "A user"))
+	}
+
+	tests := []struct {
+		name     string
+		page     uint32
+		pageSize uint32
+		expected []uint32
+		pages    uint32
+	}{
+		{name: "Defaults", expected: all, pages: 1},
+		{name: "First page", page: 1, pageSize: 2, expected: all[:2], pages: 3},
+		{name: "Last page", page: 3, pageSize: 2, expected: all[4:], pages: 3},
+		{name: "Past the end", page: 4, pageSize: 2, expected: nil, pages: 3},
+		{name: "Exact pages", page: 1, pageSize: 5, expected: all, pages: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, users := selectionIDs(t, db, tt.page, tt.pageSize, "", data.FtpUserFilter{})
+			expectIDs(t, ids, tt.expected)
+			if users.TotalItems != 5 || users.TotalPages != tt.pages {
+				t.Errorf("expected 5 items on %d pages but received %d on %d", tt.pages, users.TotalItems, users.TotalPages)
+			}
+			for _, user := range users.Ftpusers {
+				if user.Password != "" {
+					t.Errorf("expected the password of %s to be withheld", user.Username)
+				}
+			}
+		})
+	}
+
+	ids, _ := selectionIDs(t, db, 1, 2, "", data.FtpUserFilter{Sort: "-id"})
+	expectIDs(t, ids, []uint32{all[4], all[3]})
+}
+
+func testCursorPagination(t *testing.T, db data.Datastore) {
+	var ids []uint32
+	for i, username := range []string{"echo", "delta", "charlie", "bravo", "alpha"} {
+		ids = append(ids, createUser(t, db, username, "A user"))
+		for n := 0; n < i%3; n++ {
+			tick()
+			if err := db.FtpUserRecordLogin(ctx, ids[i], "10.0.0.1"); err != nil {
+				t.Fatalf("unexpected error from FtpUserRecordLogin %s", err)
+			}
+		}
+	}
+
+	for _, sort := range []string{"", "-id", "username", "-username", "login_count", "-login_count", "last_login_at", "-last_login_at", "login_count,-username", "-login_count,-last_login_at,id", "-updated_on"} {
+		t.Run("Sort "+sort, func(t *testing.T) {
+			expected, _ := selectionIDs(t, db, 0, 0, "", data.FtpUserFilter{Sort: sort})
+
+			// forward from the first page by index
+			var forward []uint32
+			var pages []data.FtpUsers
+			filter := data.FtpUserFilter{Sort: sort}
+			for page := 0; page < 5; page++ {
+				pageIDs, users := selectionIDs(t, db, 1, 2, "", filter)
+				forward = append(forward, pageIDs...)
+				pages = append(pages, users)
+				if users.Next == "" {
+					break
+				}
+				filter.Cursor = users.Next
+			}
+			expectIDs(t, forward, expected)
+			if len(pages) != 3 || pages[0].Prev != "" || pages[2].Prev == "" {
+				t.Errorf("expected 3 pages with a previous page after the first but received %+v", pages)
+			}
+
+			// back from the last page
+			last := pages[len(pages)-1]
+			backward := []uint32{last.Ftpusers[0].ID}
+			filter.Cursor = last.Prev
+			for filter.Cursor != "" {
+				pageIDs, users := selectionIDs(t, db, 0, 2, "", filter)
+				if users.Next == "" {
+					t.Error("expected a next page when reading back")
+				}
+				backward = append(pageIDs, backward...)
+				filter.Cursor = users.Prev
+			}
+			expectIDs(t, backward, expected)
+		})
+	}
+
+	t.Run("Rows Added While Paging", func(t *testing.T) {
+		first, users := selectionIDs(t, db, 0, 2, "", data.FtpUserFilter{})
+		added := createUser(t, db, "aaron", "A user")
+
+		rest, _ := selectionIDs(t, db, 0, 10, "", data.FtpUserFilter{Cursor: users.Next})
+		expectIDs(t, append(first, rest...), append(append([]uint32(nil), ids...), added))
+	})
+
+	t.Run("Skip Total", func(t *testing.T) {
+		_, users := selectionIDs(t, db, 0, 2, "", data.FtpUserFilter{SkipTotal: true})
+		if users.TotalItems != 0 || users.TotalPages != 0 || users.Next == "" {
+			t.Errorf("expected no totals and a next page but received %+v", users)
+		}
+	})
+
+	t.Run("Invalid Cursor", func(t *testing.T) {
+		_, users := selectionIDs(t, db, 0, 2, "", data.FtpUserFilter{Sort: "username"})
+
+		for _, filter := range []data.FtpUserFilter{{Cursor: "not a cursor"}, {Cursor: users.Next, Sort: "-username"}} {
+			_, err := db.FtpUserGetSelection(ctx, 0, 2, "", filter)
+			expectErr(t, err, data.ErrInvalidCursor)
+		}
+	})
+}
+
+func testSearch(t *testing.T, db data.Datastore) {
+	alpha := createUser(t, db, "alpha", "Billing export")
+	bravo := createUser(t, db, "bravo", "Invoices")
+	charlie := createUser(t, db, "charlie", "Billing import")
+	delta := createUser(t, db, "delta_one", "Discount 50% off")
+
+	tests := []struct {
+		name     string
+		search   string
+		mode     string
+		expected []uint32
+	}{
+		{name: "Username", search: "rav", expected: []uint32{bravo}},
+		{name: "Description", search: "Billing", expected: []uint32{alpha, charlie}},
+		{name: "Either", search: "ha", expected: []uint32{alpha, charlie}},
+		{name: "No match", search: "echo", expected: nil},
+		{name: "Underscore", search: "_", expected: []uint32{delta}},
+		{name: "Percent", search: "%", expected: []uint32{delta}},
+		{name: "Prefix", search: "bil", mode: data.SearchPrefix, expected: []uint32{alpha, charlie}},
+		{name: "Prefix not at start", search: "ill", mode: data.SearchPrefix, expected: nil},
+		{name: "Prefix wildcard", search: "delta%", mode: data.SearchPrefix, expected: nil},
+		{name: "Wildcard", search: "b*t", mode: data.SearchWildcard, expected: []uint32{alpha, charlie}},
+		{name: "Wildcard one character", search: "?ravo", mode: data.SearchWildcard, expected: []uint32{bravo}},
+		{name: "Wildcard whole text", search: "50%", mode: data.SearchWildcard, expected: nil},
+		{name: "Wildcard literal", search: "*50%*", mode: data.SearchWildcard, expected: []uint32{delta}},
+		{name: "Words", search: "bill imp", mode: data.SearchWords, expected: []uint32{charlie}},
+		{name: "Words in either", search: "export alp", mode: data.SearchWords, expected: []uint32{alpha}},
+		{name: "Words inside a word", search: "ling", mode: data.SearchWords, expected: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, users := selectionIDs(t, db, 0, 0, tt.search, data.FtpUserFilter{SearchMode: tt.mode})
+			expectIDs(t, ids, tt.expected)
+			if users.TotalItems != uint32(len(tt.expected)) {
+				t.Errorf("expected %d total items but received %d", len(tt.expected), users.TotalItems)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name   string
+		search string
+		mode   string
+	}{
+		{name: "Too long", search: strings.Repeat("a", data.MaxSearchLength+1)},
+		{name: "Unknown mode", search: "alpha", mode: "fuzzy"},
+		{name: "Only wildcards", search: "*?*", mode: data.SearchWildcard},
+		{name: "No words", search: "+-*", mode: data.SearchWords},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := db.FtpUserGetSelection(ctx, 0, 0, tt.search, data.FtpUserFilter{SearchMode: tt.mode})
+			expectErr(t, err, data.ErrInvalidSearch)
+		})
+	}
+}
+
+func testFilter(t *testing.T, db data.Datastore) {
+	never := createUser(t, db, "never", "Never logged in")
+	once := createUser(t, db, "once", "Logged in once")
+	twice := createUser(t, db, "twice", "Logged in twice")
+
+	for _, id := range []uint32{once, twice, twice} {
+		if err := db.FtpUserRecordLogin(ctx, id, "10.0.0.1"); err != nil {
+			t.Fatalf("unexpected error from FtpUserRecordLogin %s", err)
+		}
+	}
+
+	user, err := db.FtpUserGet(ctx, twice)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserGet %s", err)
+	}
+	if user.LoginCount != 2 || user.LastLoginIP != "10.0.0.1" || user.LastLoginAt == nil {
+		t.Errorf("unexpected login tracking returned %+v", user)
+	}
+
+	createMapping(t, db, "SysA", "1", once)
+	createMapping(t, db, "SysB", "1", twice)
+
+	// the description change gives never the latest version
+	tick()
+	if err := db.FtpUserUpdate(ctx, data.FtpUser{ID: never, Username: "never", Description: "Never logged in_"}); err != nil {
+		t.Fatalf("unexpected error from FtpUserUpdate %s", err)
+	}
+	updated, err := db.FtpUserGet(ctx, never)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserGet %s", err)
+	}
+
+	one := uint32(1)
+	hourAgo := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name     string
+		filter   data.FtpUserFilter
+		expected []uint32
+	}{
+		{name: "Max login count", filter: data.FtpUserFilter{MaxLoginCount: &one}, expected: []uint32{never, once}},
+		{name: "Logged in after", filter: data.FtpUserFilter{LastLoginAfter: hourAgo}, expected: []uint32{once, twice}},
+		{name: "Logged in before", filter: data.FtpUserFilter{LastLoginBefore: hourAgo}, expected: []uint32{never}},
+		{name: "Sort by login count", filter: data.FtpUserFilter{Sort: "-login_count"}, expected: []uint32{twice, once, never}},
+		{name: "Sort by username", filter: data.FtpUserFilter{Sort: "-username"}, expected: []uint32{twice, once, never}},
+		{name: "Username", filter: data.FtpUserFilter{Username: "once"}, expected: []uint32{once}},
+		{name: "Username prefix", filter: data.FtpUserFilter{UsernamePrefix: "tw"}, expected: []uint32{twice}},
+		{name: "Username prefix wildcard", filter: data.FtpUserFilter{UsernamePrefix: "_nce"}, expected: nil},
+		{name: "Description contains", filter: data.FtpUserFilter{DescriptionContains: "in t"}, expected: []uint32{twice}},
+		{name: "Description contains wildcard", filter: data.FtpUserFilter{DescriptionContains: "in_"}, expected: []uint32{never}},
+		{name: "Updated since", filter: data.FtpUserFilter{UpdatedSince: *updated.UpdatedOn}, expected: []uint32{never}},
+		{name: "Has mapping in", filter: data.FtpUserFilter{MappingSystems: []string{"SysA", "SysB"}}, expected: []uint32{once, twice}},
+		{name: "Has mapping in one", filter: data.FtpUserFilter{MappingSystems: []string{"SysB"}}, expected: []uint32{twice}},
+		{name: "Sort by updated", filter: data.FtpUserFilter{Sort: "-updated_on,-id"}, expected: []uint32{never, twice, once}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, _ := selectionIDs(t, db, 0, 0, "", tt.filter)
+			expectIDs(t, ids, tt.expected)
+		})
+	}
+}
+
+func testMappingUpsert(t *testing.T, db data.Datastore) {
+	first := createUser(t, db, "first", "The first user")
+	second := createUser(t, db, "second", "The second user")
+
+	createMapping(t, db, data.LookupSystem, "123", first)
+
+	mapping, err := db.MappingRetrieve(ctx, data.LookupSystem, "123")
+	if err != nil {
+		t.Fatalf("unexpected error from MappingRetrieve %s", err)
+	}
+	if mapping.System != data.LookupSystem || mapping.ID != "123" || mapping.FTPAccount.ID != first || mapping.FTPAccount.Username != "first" || mapping.UpdatedOn == nil {
+		t.Errorf("unexpected mapping returned %+v", mapping)
+	}
+	read := *mapping.UpdatedOn
+
+	tests := []struct {
+		name     string
+		mapping  data.NewMapping
+		expected int
+	}{
+		{name: "Update", mapping: data.NewMapping{System: data.LookupSystem, SystemID: "123", FTPAccountID: second}, expected: data.MappingUpdated},
+		{name: "Same account", mapping: data.NewMapping{System: data.LookupSystem, SystemID: "123", FTPAccountID: second}, expected: data.MappingUpdated},
+		{name: "Missing account", mapping: data.NewMapping{System: data.LookupSystem, SystemID: "456", FTPAccountID: second + 1000}, expected: data.MappingFTPAccountNotFound},
+		{name: "Update to a missing account", mapping: data.NewMapping{System: data.LookupSystem, SystemID: "123", FTPAccountID: second + 1000}, expected: data.MappingFTPAccountNotFound},
+		{name: "Stale version", mapping: data.NewMapping{System: data.LookupSystem, SystemID: "123", FTPAccountID: first, UpdatedOn: &read}, expected: data.MappingModified},
+		{name: "Versioned without a mapping", mapping: data.NewMapping{System: data.LookupSystem, SystemID: "789", FTPAccountID: first, UpdatedOn: &read}, expected: data.MappingModified},
+		{name: "Same id in another system", mapping: data.NewMapping{System: "OtherSys", SystemID: "123", FTPAccountID: first}, expected: data.MappingInserted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tick()
+			result, err := db.MappingCreate(ctx, tt.mapping)
+			if err != nil {
+				t.Fatalf("unexpected error from MappingCreate %s", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected result %d but received %d", tt.expected, result)
+			}
+		})
+	}
+
+	mapping, err = db.MappingRetrieve(ctx, data.LookupSystem, "123")
+	if err != nil {
+		t.Fatalf("unexpected error from MappingRetrieve %s", err)
+	}
+	if mapping.FTPAccount.ID != second || !mapping.UpdatedOn.After(read) {
+		t.Errorf("expected the mapping to move to %d with a later version but received %+v", second, mapping)
+	}
+
+	// the current version allows the update
+	tick()
+	result, err := db.MappingCreate(ctx, data.NewMapping{System: data.LookupSystem, SystemID: "123", FTPAccountID: first, UpdatedOn: mapping.UpdatedOn})
+	if err != nil || result != data.MappingUpdated {
+		t.Errorf("expected a versioned update but received %d %v", result, err)
+	}
+
+	_, err = db.MappingRetrieve(ctx, data.LookupSystem, "456")
+	expectErr(t, err, data.ErrMappingNotFound)
+}
+
+func testMappingDelete(t *testing.T, db data.Datastore) {
+	id := createUser(t, db, "user", "A user")
+	createMapping(t, db, data.LookupSystem, "123", id)
+	createMapping(t, db, data.LookupSystem, "456", id)
+
+	mapping, err := db.MappingRetrieve(ctx, data.LookupSystem, "123")
+	if err != nil {
+		t.Fatalf("unexpected error from MappingRetrieve %s", err)
+	}
+
+	stale := mapping.UpdatedOn.Add(-time.Second)
+	_, err = db.MappingDelete(ctx, data.LookupSystem, "123", &stale)
+	expectErr(t, err, data.ErrMappingModified)
+
+	rows, err := db.MappingDelete(ctx, data.LookupSystem, "123", mapping.UpdatedOn)
+	if err != nil || rows != 1 {
+		t.Errorf("expected one mapping deleted but received %d %v", rows, err)
+	}
+
+	rows, err = db.MappingDelete(ctx, data.LookupSystem, "123", nil)
+	if err != nil || rows != 0 {
+		t.Errorf("expected nothing deleted but received %d %v", rows, err)
+	}
+
+	// a versioned delete of a missing mapping is not a conflict
+	rows, err = db.MappingDelete(ctx, data.LookupSystem, "123", &stale)
+	if err != nil || rows != 0 {
+		t.Errorf("expected nothing deleted but received %d %v", rows, err)
+	}
+
+	ids, err := db.SystemIDUserRetrieve(ctx, data.LookupSystem)
+	if err != nil {
+		t.Fatalf("unexpected error from SystemIDUserRetrieve %s", err)
+	}
+	if fmt.Sprint(ids) != fmt.Sprint(map[string]string{"456": "user"}) {
+		t.Errorf("unexpected system ids returned %v", ids)
+	}
+}
+
+func testMappingSelection(t *testing.T, db data.Datastore) {
+	mapped := createUser(t, db, "mapped", "Mapped account")
+	other := createUser(t, db, "other", "Other account")
+	deleted := createUser(t, db, "deleted", "Deleted account")
+
+	createMapping(t, db, "SysB", "1", mapped)
+	createMapping(t, db, "SysA", "2", mapped)
+	createMapping(t, db, "SysA", "1", mapped)
+	createMapping(t, db, "SysA", "3", other)
+	createMapping(t, db, "SysA", "10", other)
+	createMapping(t, db, "SysC", "1", deleted)
+	if err := db.FtpUserDelete(ctx, deleted, nil); err != nil {
+		t.Fatalf("unexpected error from FtpUserDelete %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		page     uint32
+		pageSize uint32
+		filter   data.MappingFilter
+		expected string
+		expTotal uint32
+		expPages uint32
+	}{
+		{name: "All", expected: "[SysA/1 SysA/10 SysA/2 SysA/3 SysB/1]", expTotal: 5, expPages: 1},
+		{name: "Account", filter: data.MappingFilter{FTPAccountID: mapped}, expected: "[SysA/1 SysA/2 SysB/1]", expTotal: 3, expPages: 1},
+		{name: "Account page", page: 2, pageSize: 2, filter: data.MappingFilter{FTPAccountID: mapped}, expected: "[SysB/1]", expTotal: 3, expPages: 2},
+		{name: "Deleted account", filter: data.MappingFilter{FTPAccountID: deleted}, expected: "[]", expTotal: 0, expPages: 0},
+		{name: "System", filter: data.MappingFilter{System: "SysA"}, expected: "[SysA/1 SysA/10 SysA/2 SysA/3]", expTotal: 4, expPages: 1},
+		{name: "System page", page: 2, pageSize: 3, filter: data.MappingFilter{System: "SysA"}, expected: "[SysA/3]", expTotal: 4, expPages: 2},
+		{name: "System id prefix", filter: data.MappingFilter{System: "SysA", IDPrefix: "1"}, expected: "[SysA/1 SysA/10]", expTotal: 2, expPages: 1},
+		{name: "System id prefix wildcard", filter: data.MappingFilter{System: "SysA", IDPrefix: "_"}, expected: "[]", expTotal: 0, expPages: 0},
+		{name: "Deleted account system", filter: data.MappingFilter{System: "SysC"}, expected: "[]", expTotal: 0, expPages: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mappings, err := db.MappingGetSelection(ctx, tt.page, tt.pageSize, tt.filter)
+			if err != nil {
+				t.Fatalf("unexpected error from MappingGetSelection %s", err)
+			}
+
+			keys := []string{}
+			for _, mapping := range mappings.Mappings {
+				keys = append(keys, mapping.System+"/"+mapping.ID)
+				if mapping.FTPAccount.ID == mapped && (mapping.FTPAccount.Username != "mapped" || mapping.FTPAccount.Description != "Mapped account") {
+					t.Errorf("unexpected account %+v returned", mapping.FTPAccount)
+				}
+				if mapping.UpdatedOn == nil {
+					t.Errorf("expected the version of %s/%s to be returned", mapping.System, mapping.ID)
+				}
+			}
+			if fmt.Sprint(keys) != tt.expected {
+				t.Errorf("expected mappings %s but received %v", tt.expected, keys)
+			}
+			if mappings.TotalItems != tt.expTotal || mappings.TotalPages != tt.expPages {
+				t.Errorf("expected %d items on %d pages but received %d items on %d pages", tt.expTotal, tt.expPages, mappings.TotalItems, mappings.TotalPages)
+			}
+		})
+	}
+}
+
+func testSystems(t *testing.T, db data.Datastore) {
+	first := createUser(t, db, "first", "First account")
+	second := createUser(t, db, "second", "Second account")
+
+	createMapping(t, db, "SysA", "1", first)
+	createMapping(t, db, "SysA", "2", first)
+	createMapping(t, db, "SysB", "1", first)
+	createMapping(t, db, "SysB", "2", second)
+
+	for _, system := range []struct{ name, description string }{{"SysA", ""}, {"SysC", "Billing"}, {"SysA", "Alpha"}} {
+		if err := db.SystemRegister(ctx, system.name, system.description); err != nil {
+			t.Fatalf("unexpected error from SystemRegister %s", err)
+		}
+	}
+
+	expectSystems := func(t *testing.T, expected string) {
+		t.Helper()
+
+		systems, err := db.SystemGetSelection(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error from SystemGetSelection %s", err)
+		}
+
+		var summaries []string
+		for _, system := range systems.Systems {
+			summaries = append(summaries, fmt.Sprintf("%s:%d/%d/%t/%s", system.Name, system.Mappings, system.FTPAccounts, system.Registered, system.Description))
+			if system.Registered != (system.RegisteredAt != nil) {
+				t.Errorf("expected the registration time of %s only when it is registered", system.Name)
+			}
+		}
+		if fmt.Sprint(summaries) != expected {
+			t.Errorf("expected systems %s but received %v", expected, summaries)
+		}
+	}
+
+	t.Run("Counts", func(t *testing.T) {
+		expectSystems(t, "[SysA:2/1/true/Alpha SysB:2/2/false/ SysC:0/0/true/Billing]")
+	})
+
+	t.Run("Deleted account", func(t *testing.T) {
+		if err := db.FtpUserDelete(ctx, second, nil); err != nil {
+			t.Fatalf("unexpected error from FtpUserDelete %s", err)
+		}
+		expectSystems(t, "[SysA:2/1/true/Alpha SysB:1/1/false/ SysC:0/0/true/Billing]")
+	})
+
+	t.Run("Map registered", func(t *testing.T) {
+		createMapping(t, db, "SysC", "1", first)
+	})
+
+	t.Run("Registration", func(t *testing.T) {
+		system, err := db.SystemRegistrationGet(ctx, "SysC")
+		if err != nil {
+			t.Fatalf("unexpected error from SystemRegistrationGet %s", err)
+		}
+		if system.Name != "SysC" || system.Description != "Billing" || !system.Registered || system.RegisteredAt == nil {
+			t.Errorf("unexpected system returned %+v", system)
+		}
+
+		_, err = db.SystemRegistrationGet(ctx, "SysB")
+		expectErr(t, err, data.ErrSystemNotFound)
+	})
+
+	t.Run("Unregister", func(t *testing.T) {
+		if err := db.SystemUnregister(ctx, "SysA"); err != nil {
+			t.Fatalf("unexpected error from SystemUnregister %s", err)
+		}
+		expectErr(t, db.SystemUnregister(ctx, "SysA"), data.ErrSystemNotFound)
+		expectSystems(t, "[SysA:2/1/false/ SysB:1/1/false/ SysC:1/1/true/Billing]")
+	})
+}
+
+func testRequireRegisteredSystems(t *testing.T, db data.Datastore) {
+	id := createUser(t, db, "first", "First account")
+
+	if err := db.SystemRegister(ctx, "SysA", ""); err != nil {
+		t.Fatalf("unexpected error from SystemRegister %s", err)
+	}
+
+	_, err := db.MappingCreate(ctx, data.NewMapping{System: "SysB", SystemID: "1", FTPAccountID: id})
+	expectErr(t, err, data.ErrSystemNotRegistered)
+
+	createMapping(t, db, "SysA", "1", id)
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error from Begin %s", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.MappingCreate(ctx, data.NewMapping{System: "SysB", SystemID: "1", FTPAccountID: id})
+	expectErr(t, err, data.ErrSystemNotRegistered)
+}
+
+func testLookupFolders(t *testing.T, db data.Datastore) {
+	multi := createUser(t, db, "multi", "Several folders")
+	createMapping(t, db, data.LookupSystem, "123", multi)
+	createMapping(t, db, data.LookupSystem, "456", multi)
+	createMapping(t, db, "OtherSys", "789", multi)
+
+	single := createUser(t, db, "single", "One folder")
+	createMapping(t, db, data.LookupSystem, "abc", single)
+
+	unmapped := createUser(t, db, "unmapped", "No folders")
+	createMapping(t, db, "OtherSys", "def", unmapped)
+
+	user, err := db.FtpUserLookup(ctx, "multi")
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserLookup %s", err)
+	}
+	if user.ID != int64(multi) || user.Username != "multi" || user.Password != "multi-password" {
+		t.Errorf("unexpected user returned %+v", user)
+	}
+
+	var folders []string
+	for _, vf := range user.VirtualFolders {
+		folders = append(folders, vf.Name)
+		if vf.VirtualPath != "/"+vf.Name || vf.FsConfig.AzBlobConfig.KeyPrefix != vf.Name+"/" {
+			t.Errorf("unexpected virtual folder %s mapped to %s with prefix %s", vf.Name, vf.VirtualPath, vf.FsConfig.AzBlobConfig.KeyPrefix)
+		}
+	}
+	sort.Strings(folders)
+	if fmt.Sprint(folders) != fmt.Sprint([]string{"123", "456"}) {
+		t.Errorf("expected folders for the %s mappings but received %v", data.LookupSystem, folders)
+	}
+
+	// a single folder is mapped to the root
+	user, err = db.FtpUserLookup(ctx, "single")
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserLookup %s", err)
+	}
+	if len(user.VirtualFolders) != 0 || user.FsConfig.AzBlobConfig.KeyPrefix != "abc/" {
+		t.Errorf("expected the root to be mapped to abc/ but received %q with %d folders", user.FsConfig.AzBlobConfig.KeyPrefix, len(user.VirtualFolders))
+	}
+
+	_, err = db.FtpUserLookup(ctx, "unmapped")
+	expectErr(t, err, data.ErrUserNotFound)
+
+	_, err = db.FtpUserLookup(ctx, "missing")
+	expectErr(t, err, data.ErrUserNotFound)
+
+	if err = db.FtpUserDelete(ctx, single, nil); err != nil {
+		t.Fatalf("unexpected error from FtpUserDelete %s", err)
+	}
+	_, err = db.FtpUserLookup(ctx, "single")
+	expectErr(t, err, data.ErrUserNotFound)
+}
+
+func testLoginHistory(t *testing.T, db data.Datastore) {
+	attempts := []data.LoginAttempt{
+		{Username: "user", FTPAccountID: 1, IP: "10.0.0.1", Protocol: "SSH", Status: "success", RequestID: "first"},
+		{Username: "unknown", IP: "10.0.0.2", Protocol: "FTP", Status: "unknown_user", RequestID: "second"},
+		{Username: "user", FTPAccountID: 1, IP: "10.0.0.1", Protocol: "SSH", Status: "bad_password", RequestID: "third"},
+	}
+	for _, attempt := range attempts {
+		if err := db.LoginAttemptCreate(ctx, attempt); err != nil {
+			t.Fatalf("unexpected error from LoginAttemptCreate %s", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		filter   data.LoginAttemptFilter
+		expected []string
+	}{
+		{name: "Most recent first", expected: []string{"third", "second", "first"}},
+		{name: "Account", filter: data.LoginAttemptFilter{FTPAccountID: 1}, expected: []string{"third", "first"}},
+		{name: "Username", filter: data.LoginAttemptFilter{Username: "unknown"}, expected: []string{"second"}},
+		{name: "Status", filter: data.LoginAttemptFilter{Status: "success"}, expected: []string{"first"}},
+		{name: "Since", filter: data.LoginAttemptFilter{Since: time.Now().Add(time.Hour)}, expected: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := db.LoginAttemptGetSelection(ctx, 0, 0, tt.filter)
+			if err != nil {
+				t.Fatalf("unexpected error from LoginAttemptGetSelection %s", err)
+			}
+
+			var ids []string
+			for _, attempt := range result.Logins {
+				ids = append(ids, attempt.RequestID)
+				if attempt.ID == 0 || attempt.AttemptedAt.IsZero() {
+					t.Errorf("expected the id and time to be set but received %+v", attempt)
+				}
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.expected) || result.TotalItems != uint32(len(tt.expected)) {
+				t.Errorf("expected %v but received %v of %d", tt.expected, ids, result.TotalItems)
+			}
+		})
+	}
+
+	result, err := db.LoginAttemptGetSelection(ctx, 2, 2, data.LoginAttemptFilter{})
+	if err != nil {
+		t.Fatalf("unexpected error from LoginAttemptGetSelection %s", err)
+	}
+	if len(result.Logins) != 1 || result.Logins[0].RequestID != "first" || result.TotalPages != 2 {
+		t.Errorf("unexpected second page returned %+v", result)
+	}
+
+	purged, err := db.LoginAttemptPurge(ctx, time.Now().Add(-time.Hour))
+	if err != nil || purged != 0 {
+		t.Errorf("expected nothing purged but received %d %v", purged, err)
+	}
+	purged, err = db.LoginAttemptPurge(ctx, time.Now().Add(time.Minute))
+	if err != nil || purged != 3 {
+		t.Errorf("expected three attempts purged but received %d %v", purged, err)
+	}
+}
+
+func testLoginHistoryLimit(t *testing.T, db data.Datastore) {
+	for _, attempt := range []data.LoginAttempt{
+		{Username: "flood", Status: "bad_password", RequestID: "first"},
+		{Username: "other", Status: "bad_password", RequestID: "other"},
+		{Username: "flood", Status: "bad_password", RequestID: "second"},
+		{Username: "flood", Status: "bad_password", RequestID: "third"},
+	} {
+		if err := db.LoginAttemptCreate(ctx, attempt); err != nil {
+			t.Fatalf("unexpected error from LoginAttemptCreate %s", err)
+		}
+	}
+
+	purged, err := db.LoginAttemptPurge(ctx, time.Time{})
+	if err != nil || purged != 1 {
+		t.Errorf("expected one attempt purged but received %d %v", purged, err)
+	}
+
+	result, err := db.LoginAttemptGetSelection(ctx, 0, 0, data.LoginAttemptFilter{})
+	if err != nil {
+		t.Fatalf("unexpected error from LoginAttemptGetSelection %s", err)
+	}
+
+	var ids []string
+	for _, attempt := range result.Logins {
+		ids = append(ids, attempt.RequestID)
+	}
+	if expected := "[third second other]"; fmt.Sprint(ids) != expected {
+		t.Errorf("expected %s but received %v", expected, ids)
+	}
+}
+
+func testAuditLog(t *testing.T, db data.Datastore) {
+	entries := []data.AuditEntry{
+		{Actor: "admin", Action: data.AuditActionCreate, Entity: data.AuditEntityFTPAccount, EntityID: "1", After: []byte(`{"id":1}`), RequestID: "first"},
+		{Actor: "billing", Action: data.AuditActionCreate, Entity: data.AuditEntityMapping, EntityID: "BillSys1/123", After: []byte(`{"ftp_id":1}`), RequestID: "second"},
+		{Actor: "admin", Action: data.AuditActionDelete, Entity: data.AuditEntityFTPAccount, EntityID: "1", Before: []byte(`{"id":1}`), RequestID: "third"},
+	}
+	for _, entry := range entries {
+		if err := db.AuditCreate(ctx, entry); err != nil {
+			t.Fatalf("unexpected error from AuditCreate %s", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		filter   data.AuditFilter
+		expected []string
+	}{
+		{name: "Most recent first", expected: []string{"third", "second", "first"}},
+		{name: "Actor", filter: data.AuditFilter{Actor: "admin"}, expected: []string{"third", "first"}},
+		{name: "Action", filter: data.AuditFilter{Action: data.AuditActionDelete}, expected: []string{"third"}},
+		{name: "Entity", filter: data.AuditFilter{Entity: data.AuditEntityFTPAccount, EntityID: "1"}, expected: []string{"third", "first"}},
+		{name: "Until", filter: data.AuditFilter{Until: time.Now().Add(-time.Hour)}, expected: nil},
+		{name: "Since", filter: data.AuditFilter{Since: time.Now().Add(-time.Hour)}, expected: []string{"third", "second", "first"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := db.AuditGetSelection(ctx, 0, 0, tt.filter)
+			if err != nil {
+				t.Fatalf("unexpected error from AuditGetSelection %s", err)
+			}
+
+			var ids []string
+			for _, entry := range result.Entries {
+				ids = append(ids, entry.RequestID)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.expected) || result.TotalItems != uint32(len(tt.expected)) {
+				t.Errorf("expected %v but received %v of %d", tt.expected, ids, result.TotalItems)
+			}
+		})
+	}
+
+	result, err := db.AuditGetSelection(ctx, 0, 0, data.AuditFilter{Action: data.AuditActionDelete})
+	if err != nil || len(result.Entries) != 1 {
+		t.Fatalf("expected the delete entry but received %+v %v", result, err)
+	}
+	if string(result.Entries[0].Before) != `{"id":1}` || result.Entries[0].After != nil {
+		t.Errorf("expected only the before snapshot but received %s and %s", result.Entries[0].Before, result.Entries[0].After)
+	}
+}
+
+func testCancelledContext(t *testing.T, db data.Datastore) {
+	id := createUser(t, db, "user", "A user")
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err := db.FtpUserGet(cancelled, id)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v from FtpUserGet but received %v", context.Canceled, err)
+	}
+
+	err = db.FtpUserUpdate(cancelled, data.FtpUser{ID: id, Username: "renamed"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v from FtpUserUpdate but received %v", context.Canceled, err)
+	}
+
+	_, err = db.FtpUserGetSelection(cancelled, 0, 0, "", data.FtpUserFilter{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v from FtpUserGetSelection but received %v", context.Canceled, err)
+	}
+
+	// nothing was changed by the cancelled update
+	user, err := db.FtpUserGet(ctx, id)
+	if err != nil || user.Username != "user" {
+		t.Errorf("expected the user to be unchanged but received %+v %v", user, err)
+	}
+}
+
+func testTransaction(t *testing.T, db data.Datastore) {
+	// changes rolled back are discarded together
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error from Begin %s", err)
+	}
+	id := createUser(t, tx, "discarded", "A discarded user")
+	createMapping(t, tx, data.LookupSystem, "100", id)
+
+	_, err = tx.Begin(ctx)
+	expectErr(t, err, data.ErrNestedTransaction)
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("unexpected error from Rollback %s", err)
+	}
+	_, err = db.FtpUserGet(ctx, id)
+	expectErr(t, err, data.ErrUserNotFound)
+	_, err = db.MappingRetrieve(ctx, data.LookupSystem, "100")
+	expectErr(t, err, data.ErrMappingNotFound)
+
+	// changes committed are kept together, the username is free again after the rollback
+	tx, err = db.Begin(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error from Begin %s", err)
+	}
+	id = createUser(t, tx, "discarded", "A kept user")
+	createMapping(t, tx, data.LookupSystem, "100", id)
+	createMapping(t, tx, data.LookupSystem, "200", id)
+
+	// a failed write does not end the transaction
+	_, err = tx.FtpUserCreate(ctx, data.FtpUser{Username: "discarded", Description: "A duplicate", Password: "secret"})
+	expectErr(t, err, data.ErrFTPAccountExists)
+	status, err := tx.MappingCreate(ctx, data.NewMapping{System: data.LookupSystem, SystemID: "200", FTPAccountID: id})
+	if err != nil || status != data.MappingUpdated {
+		t.Errorf("expected status %d from MappingCreate but received %d %v", data.MappingUpdated, status, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("unexpected error from Commit %s", err)
+	}
+	if !errors.Is(tx.Rollback(), sql.ErrTxDone) {
+		t.Errorf("expected %v from Rollback after Commit", sql.ErrTxDone)
+	}
+
+	ids, err := db.SystemIDUserRetrieve(ctx, data.LookupSystem)
+	if err != nil {
+		t.Fatalf("unexpected error from SystemIDUserRetrieve %s", err)
+	}
+	if len(ids) != 2 || ids["100"] != "discarded" || ids["200"] != "discarded" {
+		t.Errorf("expected both mappings to be committed but received %v", ids)
+	}
+}
@@ -0,0 +1,279 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Dialect - the SQL that differs between the supported databases
+// - queries are written once with backtick quoted identifiers, ? placeholders and current_timestamp,
+// Bind rewrites them for the dialect of the Database that runs them
+type Dialect interface {
+	// DriverName - the database/sql driver and the directory of the dialect's migrations
+	DriverName() string
+
+	// QuoteIdent - quote the identifier name
+	QuoteIdent(name string) string
+
+	// Placeholder - the placeholder of the nth argument, counting from 1
+	Placeholder(n int) string
+
+	// Now - the current time, with microsecond precision when precise is set
+	Now(precise bool) string
+
+	// LimitOffset - the clause selecting limit rows after skipping offset rows
+	LimitOffset(limit uint32, offset uint32) string
+
+	// Upsert - the clause that turns an insert into an update of the assignments when a row with the same keys exists
+	// - keys and assignments are written in the form passed to Bind
+	Upsert(keys []string, assignments string) string
+
+	// Excluded - the value an upserted insert would have given column, for use in the assignments of Upsert
+	Excluded(column string) string
+
+	// Returning - the clause returning column from an insert, empty when ids are reported through LastInsertId
+	Returning(column string) string
+
+	// Inserted - an expression returned by an upsert that is true when the row was inserted rather than updated
+	// - empty when the dialect has none, MySQL reports it through rows affected and SQLite needs a check before the upsert
+	Inserted() string
+
+	// Arg - the value stored by the driver for arg
+	Arg(arg interface{}) interface{}
+
+	// TableExists - a query counting the tables of the current database or schema named by its one argument
+	TableExists() string
+}
+
+// Supported Dialects
+var (
+	MySQL      Dialect = mysqlDialect{}
+	PostgreSQL Dialect = postgresDialect{}
+	SQLite     Dialect = sqliteDialect{}
+)
+
+// DialectFor - the Dialect of the database/sql driver named driverName
+func DialectFor(driverName string) (Dialect, error) {
+	for _, d := range []Dialect{MySQL, PostgreSQL, SQLite} {
+		if d.DriverName() == driverName {
+			return d, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no dialect for driver %s", driverName)
+}
+
+// Bind - rewrite query for dialect d
+// - backtick quoted identifiers are quoted for d, each ? becomes the placeholder of its position
+// and current_timestamp, optionally with (6), becomes d.Now
+// - string literals are copied unchanged so a ? or ` inside one is left alone
+func Bind(d Dialect, query string) string {
+	var (
+		b strings.Builder
+		n int
+	)
+	b.Grow(len(query))
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"':
+			// a quoted literal ends at the next unpaired quote, '' is an escaped quote
+			end := i + 1
+			for end < len(query) {
+				if query[end] == c {
+					if end+1 < len(query) && query[end+1] == c {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			if end < len(query) {
+				end++
+			}
+			b.WriteString(query[i:end])
+			i = end
+
+		case c == '`':
+			end := strings.IndexByte(query[i+1:], '`')
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(d.QuoteIdent(query[i+1 : i+1+end]))
+			i += end + 2
+
+		case c == '?':
+			n++
+			b.WriteString(d.Placeholder(n))
+			i++
+
+		case isIdentByte(c):
+			end := i
+			for end < len(query) && isIdentByte(query[end]) {
+				end++
+			}
+			word := query[i:end]
+			if strings.EqualFold(word, "current_timestamp") && (i == 0 || query[i-1] != '.') {
+				precise := strings.HasPrefix(query[end:], "(6)")
+				if precise {
+					end += len("(6)")
+				}
+				word = d.Now(precise)
+			}
+			b.WriteString(word)
+			i = end
+
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return b.String()
+}
+
+// isIdentByte - whether c can be part of an unquoted identifier or keyword
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// bindArgs - the values stored by the driver for args
+func bindArgs(d Dialect, args []interface{}) []interface{} {
+	result := make([]interface{}, len(args))
+	for i, arg := range args {
+		result[i] = d.Arg(arg)
+	}
+
+	return result
+}
+
+// quoteIdent - quote name with quote, doubling any quote within it
+func quoteIdent(name string, quote string) string {
+	return quote + strings.ReplaceAll(name, quote, quote+quote) + quote
+}
+
+// quoteIdents - the backtick quoted list of names as written for Bind
+func quoteIdents(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteIdent(name, "`")
+	}
+
+	return strings.Join(quoted, ", ")
+}
+
+// mysqlDialect - MySQL 5.7 and later
+type mysqlDialect struct{}
+
+func (mysqlDialect) DriverName() string              { return MySQLDriverName }
+func (mysqlDialect) QuoteIdent(name string) string   { return quoteIdent(name, "`") }
+func (mysqlDialect) Placeholder(n int) string        { return "?" }
+func (mysqlDialect) Returning(column string) string  { return "" }
+func (mysqlDialect) Inserted() string                { return "" }
+func (mysqlDialect) Arg(arg interface{}) interface{} { return arg }
+
+func (mysqlDialect) Now(precise bool) string {
+	if precise {
+		return "current_timestamp(6)"
+	}
+	return "current_timestamp"
+}
+
+func (mysqlDialect) LimitOffset(limit uint32, offset uint32) string {
+	return fmt.Sprintf(" limit %d, %d", offset, limit)
+}
+
+// Upsert - MySQL finds the conflicting row through any unique key so keys are not named
+func (mysqlDialect) Upsert(keys []string, assignments string) string {
+	return " on duplicate key update " + assignments
+}
+
+func (mysqlDialect) Excluded(column string) string {
+	return "values(" + quoteIdent(column, "`") + ")"
+}
+
+func (mysqlDialect) TableExists() string {
+	return "select count(*) from information_schema.tables where table_schema = database() and table_name = ?"
+}
+
+// postgresDialect - PostgreSQL 9.5 and later
+type postgresDialect struct{}
+
+func (postgresDialect) DriverName() string              { return PostgreSQLDriverName }
+func (postgresDialect) QuoteIdent(name string) string   { return quoteIdent(name, `"`) }
+func (postgresDialect) Placeholder(n int) string        { return fmt.Sprintf("$%d", n) }
+func (postgresDialect) Arg(arg interface{}) interface{} { return arg }
+
+func (postgresDialect) Now(precise bool) string {
+	if precise {
+		return "current_timestamp(6)"
+	}
+	return "current_timestamp"
+}
+
+func (postgresDialect) LimitOffset(limit uint32, offset uint32) string {
+	return fmt.Sprintf(" limit %d offset %d", limit, offset)
+}
+
+func (postgresDialect) Upsert(keys []string, assignments string) string {
+	return " on conflict (" + quoteIdents(keys) + ") do update set " + assignments
+}
+
+func (postgresDialect) Excluded(column string) string {
+	return "excluded." + quoteIdent(column, "`")
+}
+
+func (postgresDialect) Returning(column string) string {
+	return " returning " + quoteIdent(column, "`")
+}
+
+// Inserted - an updated row version has the id of the updating transaction in xmax, a new one has none
+func (postgresDialect) Inserted() string {
+	return "(xmax = 0)"
+}
+
+func (postgresDialect) TableExists() string {
+	return "select count(*) from information_schema.tables where table_schema = current_schema() and table_name = ?"
+}
+
+// sqliteDialect - SQLite 3.35 and later
+// - identifiers keep their backticks, SQLite reads a double quoted identifier it cannot resolve as a string
+// - timestamps are stored as UTC text with millisecond precision so they compare and round trip exactly
+type sqliteDialect struct{}
+
+func (sqliteDialect) DriverName() string            { return SQLiteDriverName }
+func (sqliteDialect) QuoteIdent(name string) string { return quoteIdent(name, "`") }
+func (sqliteDialect) Placeholder(n int) string      { return "?" }
+func (sqliteDialect) Now(precise bool) string       { return sqliteNow }
+func (sqliteDialect) Inserted() string              { return "" }
+
+func (sqliteDialect) LimitOffset(limit uint32, offset uint32) string {
+	return fmt.Sprintf(" limit %d offset %d", limit, offset)
+}
+
+func (sqliteDialect) Upsert(keys []string, assignments string) string {
+	return " on conflict (" + quoteIdents(keys) + ") do update set " + assignments
+}
+
+func (sqliteDialect) Excluded(column string) string {
+	return "excluded." + quoteIdent(column, "`")
+}
+
+func (sqliteDialect) Returning(column string) string {
+	return " returning " + quoteIdent(column, "`")
+}
+
+func (sqliteDialect) TableExists() string {
+	return "select count(*) from sqlite_master where type = 'table' and name = ?"
+}
+
+func (sqliteDialect) Arg(arg interface{}) interface{} {
+	if t, ok := arg.(time.Time); ok {
+		return t.UTC().Format(sqliteTimeFormat)
+	}
+	return arg
+}
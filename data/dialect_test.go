@@ -0,0 +1,126 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBind(t *testing.T) {
+	tests := []struct {
+		name     string
+		dialect  Dialect
+		query    string
+		expected string
+	}{
+		{
+			name:     "MySQL unchanged",
+			dialect:  MySQL,
+			query:    "select `id` from `ftp_account` where `username` = ? and `updated_on` = current_timestamp(6)",
+			expected: "select `id` from `ftp_account` where `username` = ? and `updated_on` = current_timestamp(6)",
+		},
+		{
+			name:     "PostgreSQL identifiers and placeholders",
+			dialect:  PostgreSQL,
+			query:    "update `ftp_account` set `description` = ? where `id` = ?",
+			expected: `update "ftp_account" set "description" = $1 where "id" = $2`,
+		},
+		{
+			name:     "PostgreSQL more than ten placeholders",
+			dialect:  PostgreSQL,
+			query:    "values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			expected: "values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)",
+		},
+		{
+			name:     "PostgreSQL string literals unchanged",
+			dialect:  PostgreSQL,
+			query:    "select `id` from `ftp_account` where `description` = 'who''s `there`?' and `username` = ?",
+			expected: `select "id" from "ftp_account" where "description" = 'who''s ` + "`there`" + `?' and "username" = $1`,
+		},
+		{
+			name:     "SQLite timestamps",
+			dialect:  SQLite,
+			query:    "update `ftp_account` set `updated_on` = current_timestamp(6), `deleted_at` = CURRENT_TIMESTAMP where `id` = ?",
+			expected: "update `ftp_account` set `updated_on` = " + sqliteNow + ", `deleted_at` = " + sqliteNow + " where `id` = ?",
+		},
+		{
+			name:     "SQLite timestamp column names unchanged",
+			dialect:  SQLite,
+			query:    "select `current_timestamp_col`, `a`.current_timestamp from `a`",
+			expected: "select `current_timestamp_col`, `a`.current_timestamp from `a`",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := Bind(tt.dialect, tt.query); result != tt.expected {
+				t.Errorf("expected %s but received %s", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestDialectClauses(t *testing.T) {
+	assignments := "`ftp_id` = " + PostgreSQL.Excluded("ftp_id")
+
+	tests := []struct {
+		name     string
+		result   string
+		expected string
+	}{
+		{name: "MySQL limit", result: MySQL.LimitOffset(25, 50), expected: " limit 50, 25"},
+		{name: "PostgreSQL limit", result: PostgreSQL.LimitOffset(25, 50), expected: " limit 25 offset 50"},
+		{name: "SQLite limit", result: SQLite.LimitOffset(25, 50), expected: " limit 25 offset 50"},
+		{name: "MySQL upsert", result: Bind(MySQL, MySQL.Upsert([]string{"system", "id"}, "`ftp_id` = "+MySQL.Excluded("ftp_id"))), expected: " on duplicate key update `ftp_id` = values(`ftp_id`)"},
+		{name: "PostgreSQL upsert", result: Bind(PostgreSQL, PostgreSQL.Upsert([]string{"system", "id"}, assignments)), expected: ` on conflict ("system", "id") do update set "ftp_id" = excluded."ftp_id"`},
+		{name: "MySQL returning", result: MySQL.Returning("id"), expected: ""},
+		{name: "PostgreSQL returning", result: Bind(PostgreSQL, PostgreSQL.Returning("id")), expected: ` returning "id"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.result != tt.expected {
+				t.Errorf("expected %s but received %s", tt.expected, tt.result)
+			}
+		})
+	}
+}
+
+func TestDialectFor(t *testing.T) {
+	for _, d := range []Dialect{MySQL, PostgreSQL, SQLite} {
+		if result, err := DialectFor(d.DriverName()); err != nil || result != d {
+			t.Errorf("expected dialect %s but received %v, %v", d.DriverName(), result, err)
+		}
+	}
+
+	if _, err := DialectFor("oracle"); err == nil {
+		t.Error("expected an error for an unsupported driver")
+	}
+}
+
+func TestSQLiteArg(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.FixedZone("EST", -5*60*60))
+
+	if result := SQLite.Arg(at); result != at.UTC().Format(sqliteTimeFormat) {
+		t.Errorf("expected the UTC text of %s but received %v", at, result)
+	}
+	if result := MySQL.Arg(at); result != at {
+		t.Errorf("expected %s unchanged but received %v", at, result)
+	}
+}
+
+func TestSeparateDatabases(t *testing.T) {
+	first := newSQLiteDB(t)
+	second := newSQLiteDB(t)
+
+	created, err := first.FtpUserCreate(context.Background(), FtpUser{Username: "First", Password: "secret"})
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserCreate %s", err)
+	}
+
+	if _, err := second.FtpUserGet(context.Background(), created.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected %v from the second database but received %v", ErrUserNotFound, err)
+	}
+	if _, err := first.FtpUserGet(context.Background(), created.ID); err != nil {
+		t.Errorf("unexpected error from the first database %s", err)
+	}
+}
@@ -0,0 +1,98 @@
+package data
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// ErrorKind - the class of an expected data operation failure
+// - handlers map each kind to a response status rather than matching messages
+type ErrorKind int
+
+// Error Kinds
+const (
+	KindNotFound ErrorKind = iota + 1
+	KindConflict
+	KindModified
+	KindInvalid
+)
+
+// Error - an expected failure of a data operation
+// - compare against the sentinels below with errors.Is, or use errors.As to read the Kind
+type Error struct {
+	Kind    ErrorKind
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Custom Errors
+var (
+	ErrUserNotFound        = &Error{KindNotFound, "No matching user found"}
+	ErrMappingNotFound     = &Error{KindNotFound, "No matching mapping found"}
+	ErrFTPAccountNotFound  = &Error{KindNotFound, "No matching FTP Account found"}
+	ErrDeletedFTPAccount   = &Error{KindNotFound, "No matching deleted FTP Account found"}
+	ErrFTPAccountExists    = &Error{KindConflict, "An FTP Account for the specified username already exists"}
+	ErrFTPAccountModified  = &Error{KindModified, "The FTP Account has been modified since it was retrieved"}
+	ErrMappingModified     = &Error{KindModified, "The mapping has been modified since it was retrieved"}
+	ErrInvalidCursor       = &Error{KindInvalid, "The cursor is not valid for this listing"}
+	ErrInvalidSearch       = &Error{KindInvalid, "The search is too long or has nothing to match"}
+	ErrSystemNotFound      = &Error{KindNotFound, "No matching registered system found"}
+	ErrSystemNotRegistered = &Error{KindInvalid, "The system is not registered"}
+)
+
+// Driver error codes for constraint violations
+const (
+	mysqlDuplicateEntry  = 1062 // ER_DUP_ENTRY
+	mysqlRowIsReferenced = 1451 // ER_ROW_IS_REFERENCED_2
+	mysqlNoReferencedRow = 1452 // ER_NO_REFERENCED_ROW_2
+
+	pqUniqueViolation     pq.ErrorCode = "23505"
+	pqForeignKeyViolation pq.ErrorCode = "23503"
+)
+
+// isUniqueViolation - whether err is a driver error for a duplicate primary or unique key
+func isUniqueViolation(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == mysqlDuplicateEntry
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == pqUniqueViolation
+	}
+
+	var liteErr *sqlite.Error
+	if errors.As(err, &liteErr) {
+		return liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	}
+
+	return false
+}
+
+// isForeignKeyViolation - whether err is a driver error for a missing or still referenced foreign key
+func isForeignKeyViolation(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == mysqlNoReferencedRow || myErr.Number == mysqlRowIsReferenced
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == pqForeignKeyViolation
+	}
+
+	var liteErr *sqlite.Error
+	if errors.As(err, &liteErr) {
+		return liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
+	}
+
+	return false
+}
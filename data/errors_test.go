@@ -0,0 +1,71 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+func TestConstraintViolations(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		unique     bool
+		foreignKey bool
+	}{
+		{name: "MySQL duplicate entry", err: &mysql.MySQLError{Number: 1062}, unique: true},
+		{name: "MySQL no referenced row", err: &mysql.MySQLError{Number: 1452}, foreignKey: true},
+		{name: "MySQL row is referenced", err: &mysql.MySQLError{Number: 1451}, foreignKey: true},
+		{name: "MySQL other error", err: &mysql.MySQLError{Number: 1064}},
+		{name: "PostgreSQL unique violation", err: &pq.Error{Code: "23505"}, unique: true},
+		{name: "PostgreSQL foreign key violation", err: &pq.Error{Code: "23503"}, foreignKey: true},
+		{name: "PostgreSQL other error", err: &pq.Error{Code: "42601"}},
+		{name: "Wrapped driver error", err: fmt.Errorf("insert failed: %w", &mysql.MySQLError{Number: 1062}), unique: true},
+		{name: "Message alone is not enough", err: errors.New("Error 1062: Duplicate entry 'Test' for key 'username'")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if isUniqueViolation(tt.err) != tt.unique {
+				t.Errorf("expected isUniqueViolation %t for %v", tt.unique, tt.err)
+			}
+			if isForeignKeyViolation(tt.err) != tt.foreignKey {
+				t.Errorf("expected isForeignKeyViolation %t for %v", tt.foreignKey, tt.err)
+			}
+		})
+	}
+}
+
+func TestSQLiteConstraintViolations(t *testing.T) {
+	db := newSQLiteDB(t)
+
+	qry := "insert into `ftp_account` (`username`, `description`, `password`) values (?, ?, ?)"
+	if _, err := db.ExecForDriver(context.Background(), qry, "Test", "A test user", "secret"); err != nil {
+		t.Fatalf("unexpected error from ExecForDriver %s", err)
+	}
+
+	_, err := db.ExecForDriver(context.Background(), qry, "Test", "A duplicate user", "secret")
+	if !isUniqueViolation(err) || isForeignKeyViolation(err) {
+		t.Errorf("expected a unique violation but received %v", err)
+	}
+
+	_, err = db.ExecForDriver(context.Background(), "insert into `ftp_mapping` (`system`, `id`, `ftp_id`) values (?, ?, ?)", "BillSys1", "123", 1000)
+	if !isForeignKeyViolation(err) || isUniqueViolation(err) {
+		t.Errorf("expected a foreign key violation but received %v", err)
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	var dataErr *Error
+	err := fmt.Errorf("update failed: %w", ErrFTPAccountModified)
+
+	if !errors.As(err, &dataErr) || dataErr.Kind != KindModified {
+		t.Errorf("expected a wrapped error of kind %d but received %v", KindModified, err)
+	}
+	if errors.Is(err, ErrMappingModified) {
+		t.Errorf("expected %v not to match %v", err, ErrMappingModified)
+	}
+}
@@ -0,0 +1,199 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// LoginAttempt - type used to represent an entry in the login history
+type LoginAttempt struct {
+	ID           uint64    `json:"id,omitempty"`
+	AttemptedAt  time.Time `json:"attempted_at"`
+	Username     string    `json:"username,omitempty"`
+	FTPAccountID uint32    `json:"ftp_id,omitempty"`
+	IP           string    `json:"ip,omitempty"`
+	Protocol     string    `json:"protocol,omitempty"`
+	Status       string    `json:"status,omitempty"`
+	RequestID    string    `json:"request_id,omitempty"`
+}
+
+// LoginAttempts - type used to return a collection of LoginAttempt structs
+type LoginAttempts struct {
+	Logins     []LoginAttempt `json:"logins,omitempty"`
+	TotalItems uint32         `json:"total_items,omitempty"`
+	TotalPages uint32         `json:"total_pages,omitempty"`
+}
+
+// LoginAttemptFilter - type used to narrow the result set of LoginAttemptGetSelection
+// - zero values are ignored
+type LoginAttemptFilter struct {
+	FTPAccountID uint32
+	Username     string
+	Status       string
+	Since        time.Time
+}
+
+// LoginAttemptCreate - add an entry to the login history, attempted_at is set by the database
+func (db *Database) LoginAttemptCreate(ctx context.Context, attempt LoginAttempt) error {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
+		return dbErr
+	}
+
+	// attempts for unknown usernames are not associated with an account
+	var ftpID sql.NullInt64
+	if attempt.FTPAccountID != 0 {
+		ftpID = sql.NullInt64{Int64: int64(attempt.FTPAccountID), Valid: true}
+	}
+
+	qry := "insert into `ftp_login_history` (`username`, `ftp_id`, `ip`, `protocol`, `status`, `request_id`) values (?, ?, ?, ?, ?, ?)"
+
+	_, err := db.ExecForDriver(ctx, qry, attempt.Username, ftpID, attempt.IP, attempt.Protocol, attempt.Status, attempt.RequestID)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// LoginAttemptGetSelection - retrieve the login history entries matching filter, most recent first
+// - for the specified page and result set size (default page=1 and page_size=30)
+// - a 1 based page index is used
+func (db *Database) LoginAttemptGetSelection(ctx context.Context, page uint32, pageSize uint32, filter LoginAttemptFilter) (attempts LoginAttempts, err error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if err = db.checkDBConnection(ctx); err != nil {
+		return
+	}
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	if filter.FTPAccountID != 0 {
+		conditions = append(conditions, "`ftp_id` = ?")
+		args = append(args, filter.FTPAccountID)
+	}
+	if filter.Username != "" {
+		conditions = append(conditions, "`username` = ?")
+		args = append(args, filter.Username)
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, "`status` = ?")
+		args = append(args, filter.Status)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "`attempted_at` >= ?")
+		args = append(args, filter.Since)
+	}
+
+	filterClause := ""
+	if len(conditions) > 0 {
+		filterClause = " where " + strings.Join(conditions, " and ")
+	}
+
+	qry := "select count(`id`) from `ftp_login_history`" + filterClause
+
+	err = db.QueryRowForDriver(ctx, qry, args...).Scan(&attempts.TotalItems)
+	if err != nil {
+		log.Error(err.Error())
+		return attempts, err
+	}
+
+	// set default page and page_size if not provided
+	if pageSize == 0 {
+		pageSize = 30
+	}
+	if page == 0 {
+		page = 1
+	}
+
+	offset := (page - 1) * pageSize
+
+	attempts.TotalPages = attempts.TotalItems / pageSize
+	if attempts.TotalItems%pageSize > 0 {
+		attempts.TotalPages++
+	}
+
+	qry = "select `id`, `attempted_at`, `username`, `ftp_id`, `ip`, `protocol`, `status`, `request_id` from `ftp_login_history`"
+	qry += filterClause + " order by `attempted_at` desc, `id` desc"
+	qry += db.dialect().LimitOffset(pageSize, offset)
+
+	results, err := db.QueryForDriver(ctx, qry, args...)
+	if err != nil {
+		log.Error(err.Error())
+		return attempts, err
+	}
+	defer results.Close()
+
+	for results.Next() {
+		var (
+			attempt LoginAttempt
+			ftpID   sql.NullInt64
+		)
+		err = results.Scan(&attempt.ID, &attempt.AttemptedAt, &attempt.Username, &ftpID, &attempt.IP, &attempt.Protocol, &attempt.Status, &attempt.RequestID)
+		if err != nil {
+			log.Error(err.Error())
+			return attempts, err
+		}
+		attempt.FTPAccountID = uint32(ftpID.Int64)
+		attempts.Logins = append(attempts.Logins, attempt)
+	}
+
+	err = results.Err()
+	if err != nil {
+		log.Error(err.Error())
+		return attempts, err
+	}
+
+	return attempts, nil
+}
+
+// LoginAttemptPurge - remove the login history entries attempted before the provided time, and the entries
+// of each username beyond Options.LoginHistoryLimit, oldest first
+// - a zero before only removes the entries beyond the limit
+func (db *Database) LoginAttemptPurge(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
+		return 0, dbErr
+	}
+
+	var purged int64
+	if !before.IsZero() {
+		result, err := db.ExecForDriver(ctx, "delete from `ftp_login_history` where `attempted_at` < ?", before)
+		if err != nil {
+			log.Error(err.Error())
+			return 0, err
+		}
+		if purged, err = result.RowsAffected(); err != nil {
+			return 0, err
+		}
+	}
+
+	if db.Options.LoginHistoryLimit == 0 {
+		return purged, nil
+	}
+
+	// the entries are ranked through a derived table as MySQL cannot select from the table deleted from
+	qry := "delete from `ftp_login_history` where `id` in (select `id` from (select `id`, "
+	qry += "row_number() over (partition by `username` order by `id` desc) `newer` from `ftp_login_history`) `ranked` where `newer` > ?)"
+
+	result, err := db.ExecForDriver(ctx, qry, db.Options.LoginHistoryLimit)
+	if err != nil {
+		log.Error(err.Error())
+		return purged, err
+	}
+	limited, err := result.RowsAffected()
+
+	return purged + limited, err
+}
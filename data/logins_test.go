@@ -0,0 +1,148 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLoginAttemptCreate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf(errDBConnectionError, err)
+	}
+	defer db.Close()
+
+	dBase := &Database{DB: db}
+
+	insQuery := "insert into [`\"]ftp_login_history[`\"] \\([`\"]username[`\"], [`\"]ftp_id[`\"], [`\"]ip[`\"], [`\"]protocol[`\"], [`\"]status[`\"], [`\"]request_id[`\"]\\) values \\((\\?|\\$1), (\\?|\\$2), (\\?|\\$3), (\\?|\\$4), (\\?|\\$5), (\\?|\\$6)\\)"
+
+	type params struct {
+		attempt  LoginAttempt
+		expFtpID interface{}
+	}
+	tests := []struct {
+		name      string
+		getParams func(t *testing.T) params
+	}{
+		{
+			name: "Unknown User",
+			getParams: func(t *testing.T) params {
+				return params{
+					attempt:  LoginAttempt{Username: "Bad User", IP: "10.0.0.1", Protocol: "SFTP", Status: "username_not_found", RequestID: "1"},
+					expFtpID: nil,
+				}
+			},
+		},
+		{
+			name: "Known User",
+			getParams: func(t *testing.T) params {
+				return params{
+					attempt:  LoginAttempt{Username: "Test User 1", FTPAccountID: 1, IP: "10.0.0.1", Protocol: "SFTP", Status: "success", RequestID: "2"},
+					expFtpID: int64(1),
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tParams := test.getParams(t)
+			a := tParams.attempt
+
+			ex := mock.ExpectExec(insQuery)
+			ex.WithArgs(a.Username, tParams.expFtpID, a.IP, a.Protocol, a.Status, a.RequestID)
+			ex.WillReturnResult(sqlmock.NewResult(1, 1))
+
+			err := dBase.LoginAttemptCreate(context.Background(), a)
+			if err != nil {
+				t.Errorf("unexpected error from LoginAttemptCreate %s", err)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+func TestLoginAttemptGetSelection(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf(errDBConnectionError, err)
+	}
+	defer db.Close()
+
+	dBase := &Database{DB: db}
+
+	since := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	filterClause := " where [`\"]username[`\"] = (\\?|\\$1) and [`\"]status[`\"] = (\\?|\\$2) and [`\"]attempted_at[`\"] >= (\\?|\\$3)"
+	cntQuery := "select count\\([`\"]id[`\"]\\) from [`\"]ftp_login_history[`\"]" + filterClause
+	selQuery := "select [`\"]id[`\"], [`\"]attempted_at[`\"], [`\"]username[`\"], [`\"]ftp_id[`\"], [`\"]ip[`\"], [`\"]protocol[`\"], [`\"]status[`\"], [`\"]request_id[`\"] from [`\"]ftp_login_history[`\"]"
+	selQuery += filterClause + " order by [`\"]attempted_at[`\"] desc, [`\"]id[`\"] desc"
+
+	attemptedAt := time.Date(2022, 5, 4, 10, 15, 0, 0, time.UTC)
+	cntRows := sqlmock.NewRows([]string{"count"}).AddRow(2)
+	selRows := sqlmock.NewRows([]string{"id", "attempted_at", "username", "ftp_id", "ip", "protocol", "status", "request_id"})
+	selRows = selRows.AddRow(2, attemptedAt, "Test User 1", 1, "10.0.0.1", "SFTP", "bad_password", "2")
+	selRows = selRows.AddRow(1, attemptedAt, "Test User 1", nil, "10.0.0.1", "SFTP", "bad_password", "1")
+
+	mock.ExpectQuery(cntQuery).WithArgs("Test User 1", "bad_password", since).WillReturnRows(cntRows)
+	mock.ExpectQuery(selQuery).WithArgs("Test User 1", "bad_password", since).WillReturnRows(selRows)
+
+	attempts, err := dBase.LoginAttemptGetSelection(context.Background(), 1, 1, LoginAttemptFilter{Username: "Test User 1", Status: "bad_password", Since: since})
+	if err != nil {
+		t.Errorf("unexpected error from LoginAttemptGetSelection %s", err)
+	}
+	if len(attempts.Logins) != 2 {
+		t.Fatalf("%d logins returned.  expected 2", len(attempts.Logins))
+	}
+	if attempts.Logins[0].FTPAccountID != 1 || attempts.Logins[1].FTPAccountID != 0 {
+		t.Errorf("unexpected FTPAccountIDs %d and %d returned", attempts.Logins[0].FTPAccountID, attempts.Logins[1].FTPAccountID)
+	}
+	if attempts.TotalItems != 2 || attempts.TotalPages != 2 {
+		t.Errorf("expected 2 items on 2 pages but received %d items on %d pages", attempts.TotalItems, attempts.TotalPages)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+func TestLoginAttemptPurge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf(errDBConnectionError, err)
+	}
+	defer db.Close()
+
+	dBase := &Database{DB: db, Options: Options{LoginHistoryLimit: 1000}}
+
+	before := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	delQuery := "delete from [`\"]ftp_login_history[`\"] where [`\"]attempted_at[`\"] < (\\?|\\$1)"
+
+	limitQuery := "delete from `ftp_login_history` where `id` in \\(select `id` from \\(select `id`, "
+	limitQuery += "row_number\\(\\) over \\(partition by `username` order by `id` desc\\) `newer` from `ftp_login_history`\\) `ranked` where `newer` > \\?\\)"
+
+	mock.ExpectExec(delQuery).WithArgs(before).WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectExec(limitQuery).WithArgs(uint32(1000)).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(limitQuery).WithArgs(uint32(1000)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rows, err := dBase.LoginAttemptPurge(context.Background(), before)
+	if err != nil {
+		t.Errorf("unexpected error from LoginAttemptPurge %s", err)
+	}
+	if rows != 7 {
+		t.Errorf("unexpected rowcount of %d returned.  expected 7", rows)
+	}
+
+	// a zero time only removes the entries beyond the limit
+	rows, err = dBase.LoginAttemptPurge(context.Background(), time.Time{})
+	if err != nil {
+		t.Errorf("unexpected error from LoginAttemptPurge %s", err)
+	}
+	if rows != 1 {
+		t.Errorf("unexpected rowcount of %d returned.  expected 1", rows)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
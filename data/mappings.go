@@ -0,0 +1,120 @@
+package data
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// Mappings - type used to return a collection of Mapping structs
+type Mappings struct {
+	Mappings   []Mapping `json:"mappings,omitempty"`
+	TotalItems uint32    `json:"total_items,omitempty"`
+	TotalPages uint32    `json:"total_pages,omitempty"`
+}
+
+// MappingFilter - type used to narrow the result set of MappingGetSelection
+// - zero values are ignored
+// - IDPrefix matches the mappings whose system id starts with it, `%` and `_` only match themselves
+type MappingFilter struct {
+	FTPAccountID uint32
+	System       string
+	IDPrefix     string
+}
+
+// MappingGetSelection - retrieve the mappings of active accounts matching filter, ordered by system and id
+// - for the specified page and result set size (default page=1 and page_size=30)
+// - a 1 based page index is used
+// - read from a replica when there is one
+func (db *Database) MappingGetSelection(ctx context.Context, page uint32, pageSize uint32, filter MappingFilter) (Mappings, error) {
+	var mappings Mappings
+	err := db.read(ctx, func(r *Database) (err error) {
+		mappings, err = r.mappingGetSelection(ctx, page, pageSize, filter)
+		return err
+	})
+	return mappings, err
+}
+
+func (db *Database) mappingGetSelection(ctx context.Context, page uint32, pageSize uint32, filter MappingFilter) (mappings Mappings, err error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if err = db.checkDBConnection(ctx); err != nil {
+		return
+	}
+
+	conditions := []string{"a.`deleted_at` is null"}
+	var args []interface{}
+	if filter.FTPAccountID != 0 {
+		conditions = append(conditions, "m.`ftp_id` = ?")
+		args = append(args, filter.FTPAccountID)
+	}
+	if filter.System != "" {
+		conditions = append(conditions, "m.`system` = ?")
+		args = append(args, filter.System)
+	}
+	if filter.IDPrefix != "" {
+		conditions = append(conditions, "m.`id` like ?"+likeEscape)
+		args = append(args, escapeLike(filter.IDPrefix)+"%")
+	}
+
+	fromClause := " from `ftp_mapping` m inner join `ftp_account` a on m.`ftp_id` = a.`id` where " + strings.Join(conditions, " and ")
+
+	qry := "select count(*)" + fromClause
+
+	err = db.QueryRowForDriver(ctx, qry, args...).Scan(&mappings.TotalItems)
+	if err != nil {
+		log.Error(err.Error())
+		return mappings, err
+	}
+
+	// set default page and page_size if not provided
+	if pageSize == 0 {
+		pageSize = 30
+	}
+	if page == 0 {
+		page = 1
+	}
+
+	offset := (page - 1) * pageSize
+
+	mappings.TotalPages = mappings.TotalItems / pageSize
+	if mappings.TotalItems%pageSize > 0 {
+		mappings.TotalPages++
+	}
+
+	qry = "select m.`system`, m.`id`, a.`id`, a.`username`, a.`description`, m.`updated_on`" + fromClause
+	qry += " order by m.`system`, m.`id`"
+	qry += db.dialect().LimitOffset(pageSize, offset)
+
+	results, err := db.QueryForDriver(ctx, qry, args...)
+	if err != nil {
+		log.Error(err.Error())
+		return mappings, err
+	}
+	defer results.Close()
+
+	for results.Next() {
+		var (
+			mapping   Mapping
+			updatedOn time.Time
+		)
+		err = results.Scan(&mapping.System, &mapping.ID, &mapping.FTPAccount.ID, &mapping.FTPAccount.Username, &mapping.FTPAccount.Description, &updatedOn)
+		if err != nil {
+			log.Error(err.Error())
+			return mappings, err
+		}
+		mapping.UpdatedOn = &updatedOn
+		mappings.Mappings = append(mappings.Mappings, mapping)
+	}
+
+	err = results.Err()
+	if err != nil {
+		log.Error(err.Error())
+		return mappings, err
+	}
+
+	return mappings, nil
+}
@@ -0,0 +1,84 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMappingGetSelection(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf(errDBConnectionError, err)
+	}
+	defer db.Close()
+
+	dBase := &Database{DB: db}
+
+	fromClause := " from [`\"]ftp_mapping[`\"] m inner join [`\"]ftp_account[`\"] a on m.[`\"]ftp_id[`\"] = a.[`\"]id[`\"]"
+	fromClause += " where a.[`\"]deleted_at[`\"] is null and m.[`\"]ftp_id[`\"] = (\\?|\\$1)"
+	cntQuery := "select count\\(\\*\\)" + fromClause
+	selQuery := "select m.[`\"]system[`\"], m.[`\"]id[`\"], a.[`\"]id[`\"], a.[`\"]username[`\"], a.[`\"]description[`\"], m.[`\"]updated_on[`\"]" + fromClause
+	selQuery += " order by m.[`\"]system[`\"], m.[`\"]id[`\"] limit 1, 1"
+
+	updatedOn := time.Date(2022, 5, 4, 10, 15, 0, 0, time.UTC)
+	cntRows := sqlmock.NewRows([]string{"count"}).AddRow(2)
+	selRows := sqlmock.NewRows([]string{"system", "id", "ftp_id", "username", "description", "updated_on"})
+	selRows = selRows.AddRow("BillSys1", "1234", 7, "Test", "Test description", updatedOn)
+
+	mock.ExpectQuery(cntQuery).WithArgs(7).WillReturnRows(cntRows)
+	mock.ExpectQuery(selQuery).WithArgs(7).WillReturnRows(selRows)
+
+	mappings, err := dBase.MappingGetSelection(context.Background(), 2, 1, MappingFilter{FTPAccountID: 7})
+	if err != nil {
+		t.Errorf("unexpected error from MappingGetSelection %s", err)
+	}
+	if len(mappings.Mappings) != 1 {
+		t.Fatalf("%d mappings returned.  expected 1", len(mappings.Mappings))
+	}
+	mapping := mappings.Mappings[0]
+	if mapping.System != "BillSys1" || mapping.ID != "1234" || mapping.FTPAccount.ID != 7 || mapping.FTPAccount.Username != "Test" || !mapping.UpdatedOn.Equal(updatedOn) {
+		t.Errorf("unexpected mapping %+v returned", mapping)
+	}
+	if mappings.TotalItems != 2 || mappings.TotalPages != 2 {
+		t.Errorf("expected 2 items on 2 pages but received %d items on %d pages", mappings.TotalItems, mappings.TotalPages)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMappingGetSelectionSystem(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf(errDBConnectionError, err)
+	}
+	defer db.Close()
+
+	dBase := &Database{DB: db}
+
+	fromClause := " from [`\"]ftp_mapping[`\"] m inner join [`\"]ftp_account[`\"] a on m.[`\"]ftp_id[`\"] = a.[`\"]id[`\"]"
+	fromClause += " where a.[`\"]deleted_at[`\"] is null and m.[`\"]system[`\"] = (\\?|\\$1) and m.[`\"]id[`\"] like (\\?|\\$2) escape '!'"
+	cntQuery := "select count\\(\\*\\)" + fromClause
+	selQuery := "select m.[`\"]system[`\"], m.[`\"]id[`\"], a.[`\"]id[`\"], a.[`\"]username[`\"], a.[`\"]description[`\"], m.[`\"]updated_on[`\"]" + fromClause
+	selQuery += " order by m.[`\"]system[`\"], m.[`\"]id[`\"] limit 0, 30"
+
+	cntRows := sqlmock.NewRows([]string{"count"}).AddRow(0)
+	selRows := sqlmock.NewRows([]string{"system", "id", "ftp_id", "username", "description", "updated_on"})
+
+	mock.ExpectQuery(cntQuery).WithArgs("BillSys1", "12!_%").WillReturnRows(cntRows)
+	mock.ExpectQuery(selQuery).WithArgs("BillSys1", "12!_%").WillReturnRows(selRows)
+
+	mappings, err := dBase.MappingGetSelection(context.Background(), 0, 0, MappingFilter{System: "BillSys1", IDPrefix: "12_"})
+	if err != nil {
+		t.Errorf("unexpected error from MappingGetSelection %s", err)
+	}
+	if len(mappings.Mappings) != 0 || mappings.TotalItems != 0 || mappings.TotalPages != 0 {
+		t.Errorf("expected no mappings but received %+v", mappings)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
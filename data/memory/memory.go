@@ -0,0 +1,996 @@
+// Package memory provides an in-memory implementation of data.Datastore
+//
+// The Store keeps the same rules as the database backed data.Database, including unique usernames,
+// soft deletes, row versions, mapping upserts and the removal of mappings with their account,
+// so it can stand in for a database in tests and when running the service as a demo.
+package memory
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sftpgo "github.com/drakkan/sftpgo/v2/dataprovider"
+	"github.com/halt-joe/ftp-user-svc/data"
+)
+
+// ConnectionString - the DBCON value that selects the in-memory Store
+const ConnectionString = "memory://"
+
+// default page size used by the GetSelection methods when none is provided
+const defaultPageSize = 30
+
+// mappingKey - the primary key of a mapping
+type mappingKey struct {
+	system string
+	id     string
+}
+
+// storedMapping - a stored system, system_id and ftp account mapping
+type storedMapping struct {
+	ftpID     uint32
+	updatedOn time.Time
+}
+
+// registeredSystem - a system added with SystemRegister
+type registeredSystem struct {
+	description string
+	createdAt   time.Time
+}
+
+// Store - type that implements the data.Datastore interface in memory, safe for concurrent use
+// - calls fail with the context error when their context has already ended
+// - of the data.Options only RequireRegisteredSystems and LoginHistoryLimit apply to a Store
+type Store struct {
+	mu   sync.RWMutex
+	opts data.Options
+
+	accounts map[uint32]data.FtpUser
+	mappings map[mappingKey]storedMapping
+	systems  map[string]registeredSystem
+	logins   []data.LoginAttempt
+	audit    []data.AuditEntry
+
+	lastAccountID uint32
+	lastLoginID   uint64
+	lastAuditID   uint64
+}
+
+// compile time check that Store satisfies the interface used by the handlers
+var _ data.Datastore = (*Store)(nil)
+
+// New - return an empty Store with the data.DefaultOptions
+func New() *Store {
+	return NewWithOptions(data.DefaultOptions())
+}
+
+// NewWithOptions - return an empty Store configured with opts
+func NewWithOptions(opts data.Options) *Store {
+	return &Store{
+		opts:     opts,
+		accounts: make(map[uint32]data.FtpUser),
+		mappings: make(map[mappingKey]storedMapping),
+		systems:  make(map[string]registeredSystem),
+	}
+}
+
+// now - the time recorded by writes
+func now() *time.Time {
+	t := time.Now().UTC()
+	return &t
+}
+
+// copyTime - copy t so stored times are not shared with callers
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+// copyUser - the copy of a stored account returned to callers, the password is never returned
+func copyUser(user data.FtpUser) data.FtpUser {
+	user.Password = ""
+	user.LastLoginAt = copyTime(user.LastLoginAt)
+	user.DeletedAt = copyTime(user.DeletedAt)
+	user.UpdatedOn = copyTime(user.UpdatedOn)
+	return user
+}
+
+// sameVersion - report whether the version the client read matches the current version
+// - a nil version always matches so the write is unconditional
+func sameVersion(version *time.Time, current time.Time) bool {
+	return version == nil || version.Equal(current)
+}
+
+// pageBounds - the slice bounds of the requested page of total items and the number of pages
+// - for the specified page and result set size (default page=1 and page_size=30)
+// - a 1 based page index is used
+func pageBounds(total int, page uint32, pageSize uint32) (start int, end int, totalPages uint32) {
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	if page == 0 {
+		page = 1
+	}
+
+	totalPages = uint32(total) / pageSize
+	if uint32(total)%pageSize > 0 {
+		totalPages++
+	}
+
+	start = int((page - 1) * pageSize)
+	if start > total {
+		start = total
+	}
+	end = start + int(pageSize)
+	if end > total {
+		end = total
+	}
+
+	return start, end, totalPages
+}
+
+// activeAccount - the account with id when it has not been soft deleted, must be called with the lock held
+func (s *Store) activeAccount(id uint32) (data.FtpUser, bool) {
+	user, ok := s.accounts[id]
+	if !ok || user.DeletedAt != nil {
+		return user, false
+	}
+	return user, true
+}
+
+// usernameTaken - report whether an account other than id uses username, must be called with the lock held
+// - soft deleted accounts keep their username until they are purged
+func (s *Store) usernameTaken(username string, id uint32) bool {
+	for _, user := range s.accounts {
+		if user.ID != id && user.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+// FtpUserLookup - retrieve the sftpgo user for the active account with username and its LookupSystem mappings
+func (s *Store) FtpUserLookup(ctx context.Context, username string) (sftpgo.User, error) {
+	if err := ctx.Err(); err != nil {
+		return sftpgo.User{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.accounts {
+		if user.Username != username || user.DeletedAt != nil {
+			continue
+		}
+
+		var folders []string
+		for key, m := range s.mappings {
+			if key.system == data.LookupSystem && m.ftpID == user.ID {
+				folders = append(folders, key.id)
+			}
+		}
+		if len(folders) == 0 {
+			break
+		}
+		sort.Strings(folders)
+
+		return data.NewLookupUser(user, folders), nil
+	}
+
+	return sftpgo.User{}, data.ErrUserNotFound
+}
+
+// MappingDelete - delete the mapping associated with the provided system and systemid
+// - when version is set the mapping is only deleted if it has not been modified since
+func (s *Store) MappingDelete(ctx context.Context, system string, id string, version *time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := mappingKey{system, id}
+	m, ok := s.mappings[key]
+	if !ok {
+		return 0, nil
+	}
+	if !sameVersion(version, m.updatedOn) {
+		return 0, data.ErrMappingModified
+	}
+
+	delete(s.mappings, key)
+
+	return 1, nil
+}
+
+// MappingRetrieve - retrieve the mapping associated with the provided system and systemid
+func (s *Store) MappingRetrieve(ctx context.Context, system string, id string) (data.Mapping, error) {
+	if err := ctx.Err(); err != nil {
+		return data.Mapping{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	mapping := data.Mapping{System: system, ID: id}
+
+	m, ok := s.mappings[mappingKey{system, id}]
+	if !ok {
+		return mapping, data.ErrMappingNotFound
+	}
+
+	user, ok := s.activeAccount(m.ftpID)
+	if !ok {
+		return mapping, data.ErrMappingNotFound
+	}
+
+	mapping.FTPAccount = data.FtpUser{ID: user.ID, Username: user.Username, Description: user.Description}
+	mapping.UpdatedOn = copyTime(&m.updatedOn)
+
+	return mapping, nil
+}
+
+// MappingCreate - insert or update the mapping for the given system, system_id and ftp_id
+// - soft deleted ftp accounts are treated as not found
+// - when mapping.UpdatedOn is set only the existing mapping with that version is updated
+func (s *Store) MappingCreate(ctx context.Context, mapping data.NewMapping) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return data.MappingError, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.systems[mapping.System]; s.opts.RequireRegisteredSystems && !ok {
+		return data.MappingError, data.ErrSystemNotRegistered
+	}
+
+	key := mappingKey{mapping.System, mapping.SystemID}
+	current, exists := s.mappings[key]
+
+	// a versioned write must not recreate a mapping deleted since it was retrieved
+	if mapping.UpdatedOn != nil && (!exists || !current.updatedOn.Equal(*mapping.UpdatedOn)) {
+		return data.MappingModified, nil
+	}
+
+	if _, ok := s.activeAccount(mapping.FTPAccountID); !ok {
+		return data.MappingFTPAccountNotFound, nil
+	}
+
+	s.mappings[key] = storedMapping{ftpID: mapping.FTPAccountID, updatedOn: *now()}
+
+	if exists {
+		return data.MappingUpdated, nil
+	}
+
+	return data.MappingInserted, nil
+}
+
+// FtpUserGetSelection - retrieve the accounts matching search and filter
+// - for the specified page and result set size (default page=1 and page_size=30)
+// - a 1 based page index is used
+// - search is matched case insensitively against the username or description as filter.SearchMode describes
+func (s *Store) FtpUserGetSelection(ctx context.Context, page uint32, pageSize uint32, search string, filter data.FtpUserFilter) (data.FtpUsers, error) {
+	if err := ctx.Err(); err != nil {
+		return data.FtpUsers{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var users data.FtpUsers
+
+	if !data.ValidSearch(search, filter.SearchMode) {
+		return users, data.ErrInvalidSearch
+	}
+	match := searchMatcher(search, filter.SearchMode)
+
+	var matched []data.FtpUser
+	for _, user := range s.accounts {
+		if (user.DeletedAt != nil) != filter.Deleted {
+			continue
+		}
+		if search != "" && !match(user) {
+			continue
+		}
+		if !filter.LastLoginBefore.IsZero() && user.LastLoginAt != nil && !user.LastLoginAt.Before(filter.LastLoginBefore) {
+			continue
+		}
+		if !filter.LastLoginAfter.IsZero() && (user.LastLoginAt == nil || user.LastLoginAt.Before(filter.LastLoginAfter)) {
+			continue
+		}
+		if filter.MaxLoginCount != nil && user.LoginCount > *filter.MaxLoginCount {
+			continue
+		}
+		if filter.Username != "" && user.Username != filter.Username {
+			continue
+		}
+		if !strings.HasPrefix(user.Username, filter.UsernamePrefix) {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(user.Description), strings.ToLower(filter.DescriptionContains)) {
+			continue
+		}
+		if !filter.UpdatedSince.IsZero() && (user.UpdatedOn == nil || user.UpdatedOn.Before(filter.UpdatedSince)) {
+			continue
+		}
+		if len(filter.MappingSystems) > 0 && !s.hasMappingIn(user.ID, filter.MappingSystems) {
+			continue
+		}
+		matched = append(matched, user)
+	}
+
+	less := ftpUserLess(filter.Sort)
+	sort.Slice(matched, func(i, j int) bool { return less(matched[i], matched[j]) })
+
+	var (
+		start, end int
+		totalPages uint32
+		before     bool
+	)
+	if filter.Cursor == "" {
+		start, end, totalPages = pageBounds(len(matched), page, pageSize)
+	} else {
+		cursor, err := data.ParseCursor(filter.Cursor, filter.Sort)
+		if err != nil {
+			return users, err
+		}
+		_, _, totalPages = pageBounds(len(matched), 1, pageSize)
+
+		if pageSize == 0 {
+			pageSize = defaultPageSize
+		}
+		position := cursor.Position()
+		before = cursor.Before
+		if before {
+			end = sort.Search(len(matched), func(i int) bool { return !less(matched[i], position) })
+			start = end - int(pageSize)
+			if start < 0 {
+				start = 0
+			}
+		} else {
+			start = sort.Search(len(matched), func(i int) bool { return less(position, matched[i]) })
+			end = start + int(pageSize)
+			if end > len(matched) {
+				end = len(matched)
+			}
+		}
+	}
+	if !filter.SkipTotal {
+		users.TotalItems = uint32(len(matched))
+		users.TotalPages = totalPages
+	}
+
+	for _, user := range matched[start:end] {
+		users.Ftpusers = append(users.Ftpusers, copyUser(user))
+	}
+
+	// a page read from a cursor always has rows on the side of the cursor, as for a database
+	if len(users.Ftpusers) > 0 {
+		next, prev := end < len(matched), start > 0
+		if filter.Cursor != "" {
+			next, prev = next || before, prev || !before
+		}
+		if next {
+			users.Next = data.NewCursor(users.Ftpusers[len(users.Ftpusers)-1], filter.Sort, false)
+		}
+		if prev {
+			users.Prev = data.NewCursor(users.Ftpusers[0], filter.Sort, true)
+		}
+	}
+
+	return users, nil
+}
+
+// hasMappingIn - whether the account with id has a mapping in any of systems, must be called with the lock held
+func (s *Store) hasMappingIn(id uint32, systems []string) bool {
+	for key, m := range s.mappings {
+		if m.ftpID != id {
+			continue
+		}
+		for _, system := range systems {
+			if key.system == system {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ftpUserLess - whether a is ordered before b by the FtpUserFilter.Sort keys and then by id
+// - accounts that have never logged in sort before those that have when ordering by last_login_at
+func ftpUserLess(sort string) func(a, b data.FtpUser) bool {
+	fields, _ := data.ParseSort(sort)
+
+	return func(a, b data.FtpUser) bool {
+		for _, field := range fields {
+			if c := compareField(field.Key, a, b); c != 0 {
+				if field.Desc {
+					return c > 0
+				}
+				return c < 0
+			}
+		}
+		return a.ID < b.ID
+	}
+}
+
+// compareField - negative when a sorts before b by the sort key in ascending order
+func compareField(key string, a, b data.FtpUser) int {
+	switch key {
+	case "id":
+		return int(int64(a.ID) - int64(b.ID))
+	case "username":
+		return strings.Compare(a.Username, b.Username)
+	case "login_count":
+		return int(a.LoginCount) - int(b.LoginCount)
+	case "last_login_at":
+		return compareTimes(a.LastLoginAt, b.LastLoginAt)
+	case "updated_on":
+		return compareTimes(a.UpdatedOn, b.UpdatedOn)
+	}
+	return 0
+}
+
+// compareTimes - negative when a is before b, a missing time is before any other
+func compareTimes(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return a.Compare(*b)
+}
+
+// searchMatcher - a case insensitive match of an account against search in mode, like the one FtpUserGetSelection makes in SQL
+func searchMatcher(search string, mode string) func(user data.FtpUser) bool {
+	search = strings.ToLower(search)
+
+	var match func(text string) bool
+	switch mode {
+	case data.SearchPrefix:
+		match = func(text string) bool { return strings.HasPrefix(text, search) }
+
+	case data.SearchWildcard:
+		pattern := strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(regexp.QuoteMeta(search))
+		match = regexp.MustCompile("(?s)^" + pattern + "$").MatchString
+
+	case data.SearchWords:
+		// every word starts the username or description or follows a space in either
+		words := data.SearchWordsOf(search)
+		return func(user data.FtpUser) bool {
+			username, description := strings.ToLower(user.Username), strings.ToLower(user.Description)
+			for _, word := range words {
+				if !strings.HasPrefix(username, word) && !strings.Contains(username, " "+word) &&
+					!strings.HasPrefix(description, word) && !strings.Contains(description, " "+word) {
+					return false
+				}
+			}
+			return true
+		}
+
+	default:
+		match = func(text string) bool { return strings.Contains(text, search) }
+	}
+
+	return func(user data.FtpUser) bool {
+		return match(strings.ToLower(user.Username)) || match(strings.ToLower(user.Description))
+	}
+}
+
+// FtpUserGet - retrieve the active account associated with id
+func (s *Store) FtpUserGet(ctx context.Context, id uint32) (data.FtpUser, error) {
+	if err := ctx.Err(); err != nil {
+		return data.FtpUser{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.activeAccount(id)
+	if !ok {
+		return data.FtpUser{}, data.ErrUserNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+// FtpUserCreate - create an account with the provided parameters and return the created account
+func (s *Store) FtpUserCreate(ctx context.Context, user data.FtpUser) (data.FtpUser, error) {
+	if err := ctx.Err(); err != nil {
+		return data.FtpUser{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.usernameTaken(user.Username, 0) {
+		return data.FtpUser{}, data.ErrFTPAccountExists
+	}
+
+	s.lastAccountID++
+	s.accounts[s.lastAccountID] = data.FtpUser{
+		ID:          s.lastAccountID,
+		Username:    user.Username,
+		Description: user.Description,
+		Password:    user.Password,
+		UpdatedOn:   now(),
+	}
+
+	return copyUser(s.accounts[s.lastAccountID]), nil
+}
+
+// write - apply change to the active account id
+// - ErrFTPAccountModified is returned when version is set and the account has been modified since
+func (s *Store) write(ctx context.Context, id uint32, version *time.Time, change func(user *data.FtpUser) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.activeAccount(id)
+	if !ok {
+		return data.ErrFTPAccountNotFound
+	}
+	if !sameVersion(version, *user.UpdatedOn) {
+		return data.ErrFTPAccountModified
+	}
+
+	err := change(&user)
+	if err != nil {
+		return err
+	}
+	s.accounts[id] = user
+
+	return nil
+}
+
+// FtpUserUpdate - update the username and description of the account specified by the ftp user provided
+// - when user.UpdatedOn is set the account is only updated if it has not been modified since
+func (s *Store) FtpUserUpdate(ctx context.Context, user data.FtpUser) error {
+	return s.write(ctx, user.ID, user.UpdatedOn, func(current *data.FtpUser) error {
+		if s.usernameTaken(user.Username, user.ID) {
+			return data.ErrFTPAccountExists
+		}
+
+		current.Username = user.Username
+		current.Description = user.Description
+		current.UpdatedOn = now()
+		return nil
+	})
+}
+
+// FtpUserDelete - soft delete the account specified by the id provided
+// - the account and its mappings are kept until purged so the account can be restored
+// - when version is set the account is only deleted if it has not been modified since
+func (s *Store) FtpUserDelete(ctx context.Context, id uint32, version *time.Time) error {
+	return s.write(ctx, id, version, func(current *data.FtpUser) error {
+		current.DeletedAt = now()
+		return nil
+	})
+}
+
+// FtpUserRestore - restore the soft deleted account specified by the id provided along with its mappings
+func (s *Store) FtpUserRestore(ctx context.Context, id uint32) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.accounts[id]
+	if !ok || user.DeletedAt == nil {
+		return data.ErrDeletedFTPAccount
+	}
+
+	user.DeletedAt = nil
+	s.accounts[id] = user
+
+	return nil
+}
+
+// FtpUserPurge - permanently remove the accounts soft deleted before the provided time along with their mappings
+func (s *Store) FtpUserPurge(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+	for id, user := range s.accounts {
+		if user.DeletedAt == nil || !user.DeletedAt.Before(before) {
+			continue
+		}
+
+		delete(s.accounts, id)
+		for key, m := range s.mappings {
+			if m.ftpID == id {
+				delete(s.mappings, key)
+			}
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// FtpUserUpdatePassword - update the password on the account specified by the ftp user provided
+// - when user.UpdatedOn is set the password is only updated if the account has not been modified since
+func (s *Store) FtpUserUpdatePassword(ctx context.Context, user data.FtpUser) error {
+	return s.write(ctx, user.ID, user.UpdatedOn, func(current *data.FtpUser) error {
+		current.Password = user.Password
+		current.UpdatedOn = now()
+		return nil
+	})
+}
+
+// FtpUserRecordLogin - record a successful login from ip against the account specified by id
+func (s *Store) FtpUserRecordLogin(ctx context.Context, id uint32, ip string) error {
+	return s.write(ctx, id, nil, func(current *data.FtpUser) error {
+		current.LastLoginAt = now()
+		current.LastLoginIP = ip
+		current.LoginCount++
+		return nil
+	})
+}
+
+// SystemIDUserRetrieve - retrieve all of the SystemID and Username
+// pairs associated with the provided system
+func (s *Store) SystemIDUserRetrieve(ctx context.Context, system string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]string)
+	for key, m := range s.mappings {
+		if key.system != system {
+			continue
+		}
+		if user, ok := s.activeAccount(m.ftpID); ok {
+			result[key.id] = user.Username
+		}
+	}
+
+	return result, nil
+}
+
+// MappingGetSelection - retrieve the mappings of active accounts matching filter, ordered by system and id
+// - for the specified page and result set size (default page=1 and page_size=30)
+// - a 1 based page index is used
+func (s *Store) MappingGetSelection(ctx context.Context, page uint32, pageSize uint32, filter data.MappingFilter) (data.Mappings, error) {
+	if err := ctx.Err(); err != nil {
+		return data.Mappings{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var mappings data.Mappings
+
+	var matched []data.Mapping
+	for key, m := range s.mappings {
+		if filter.FTPAccountID != 0 && m.ftpID != filter.FTPAccountID {
+			continue
+		}
+		if filter.System != "" && key.system != filter.System {
+			continue
+		}
+		if !strings.HasPrefix(key.id, filter.IDPrefix) {
+			continue
+		}
+		user, ok := s.activeAccount(m.ftpID)
+		if !ok {
+			continue
+		}
+		matched = append(matched, data.Mapping{
+			System:     key.system,
+			ID:         key.id,
+			FTPAccount: data.FtpUser{ID: user.ID, Username: user.Username, Description: user.Description},
+			UpdatedOn:  copyTime(&m.updatedOn),
+		})
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].System != matched[j].System {
+			return matched[i].System < matched[j].System
+		}
+		return matched[i].ID < matched[j].ID
+	})
+
+	start, end, totalPages := pageBounds(len(matched), page, pageSize)
+	mappings.TotalItems = uint32(len(matched))
+	mappings.TotalPages = totalPages
+	if start < end {
+		mappings.Mappings = matched[start:end]
+	}
+
+	return mappings, nil
+}
+
+// SystemGetSelection - retrieve every system that is registered or has mappings of active accounts, ordered by name
+func (s *Store) SystemGetSelection(ctx context.Context) (data.Systems, error) {
+	if err := ctx.Err(); err != nil {
+		return data.Systems{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found := make(map[string]*data.System)
+	get := func(name string) *data.System {
+		if found[name] == nil {
+			found[name] = &data.System{Name: name}
+		}
+		return found[name]
+	}
+
+	accounts := make(map[string]map[uint32]bool)
+	for key, m := range s.mappings {
+		if _, ok := s.activeAccount(m.ftpID); !ok {
+			continue
+		}
+		get(key.system).Mappings++
+		if accounts[key.system] == nil {
+			accounts[key.system] = make(map[uint32]bool)
+		}
+		accounts[key.system][m.ftpID] = true
+	}
+	for name, ids := range accounts {
+		found[name].FTPAccounts = uint32(len(ids))
+	}
+
+	for name, registered := range s.systems {
+		system := get(name)
+		system.Description, system.Registered, system.RegisteredAt = registered.description, true, copyTime(&registered.createdAt)
+	}
+
+	systems := data.Systems{Systems: make([]data.System, 0, len(found))}
+	for _, system := range found {
+		systems.Systems = append(systems.Systems, *system)
+	}
+	sort.Slice(systems.Systems, func(i, j int) bool { return systems.Systems[i].Name < systems.Systems[j].Name })
+
+	return systems, nil
+}
+
+// SystemRegistrationGet - retrieve the registration of system, ErrSystemNotFound when it is not registered
+// - Mappings and FTPAccounts are not counted
+func (s *Store) SystemRegistrationGet(ctx context.Context, system string) (data.System, error) {
+	if err := ctx.Err(); err != nil {
+		return data.System{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	registered, ok := s.systems[system]
+	if !ok {
+		return data.System{}, data.ErrSystemNotFound
+	}
+
+	return data.System{Name: system, Description: registered.description, Registered: true, RegisteredAt: copyTime(&registered.createdAt)}, nil
+}
+
+// SystemRegister - register system so it can be mapped when data.Options.RequireRegisteredSystems is set,
+// updating the description of a system already registered
+func (s *Store) SystemRegister(ctx context.Context, system string, description string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	registered, ok := s.systems[system]
+	if !ok {
+		registered.createdAt = *now()
+	}
+	registered.description = description
+	s.systems[system] = registered
+
+	return nil
+}
+
+// SystemUnregister - remove the registration of system, its mappings are kept
+func (s *Store) SystemUnregister(ctx context.Context, system string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.systems[system]; !ok {
+		return data.ErrSystemNotFound
+	}
+	delete(s.systems, system)
+
+	return nil
+}
+
+// LoginAttemptCreate - add an entry to the login history, AttemptedAt is set by the store
+func (s *Store) LoginAttemptCreate(ctx context.Context, attempt data.LoginAttempt) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastLoginID++
+	attempt.ID = s.lastLoginID
+	attempt.AttemptedAt = *now()
+	s.logins = append(s.logins, attempt)
+
+	return nil
+}
+
+// LoginAttemptGetSelection - retrieve the login history entries matching filter, most recent first
+// - for the specified page and result set size (default page=1 and page_size=30)
+// - a 1 based page index is used
+func (s *Store) LoginAttemptGetSelection(ctx context.Context, page uint32, pageSize uint32, filter data.LoginAttemptFilter) (data.LoginAttempts, error) {
+	if err := ctx.Err(); err != nil {
+		return data.LoginAttempts{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var attempts data.LoginAttempts
+
+	// entries are appended in order so walking backwards is most recent first
+	var matched []data.LoginAttempt
+	for i := len(s.logins) - 1; i >= 0; i-- {
+		attempt := s.logins[i]
+		if filter.FTPAccountID != 0 && attempt.FTPAccountID != filter.FTPAccountID {
+			continue
+		}
+		if filter.Username != "" && attempt.Username != filter.Username {
+			continue
+		}
+		if filter.Status != "" && attempt.Status != filter.Status {
+			continue
+		}
+		if !filter.Since.IsZero() && attempt.AttemptedAt.Before(filter.Since) {
+			continue
+		}
+		matched = append(matched, attempt)
+	}
+
+	start, end, totalPages := pageBounds(len(matched), page, pageSize)
+	attempts.TotalItems = uint32(len(matched))
+	attempts.TotalPages = totalPages
+	if start < end {
+		attempts.Logins = matched[start:end]
+	}
+
+	return attempts, nil
+}
+
+// LoginAttemptPurge - remove the login history entries attempted before the provided time, and the entries
+// of each username beyond data.Options.LoginHistoryLimit, oldest first
+// - a zero before only removes the entries beyond the limit
+func (s *Store) LoginAttemptPurge(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// walking backwards is most recent first, so the entries past the limit are the oldest
+	newer := make(map[string]uint32)
+	kept := make([]data.LoginAttempt, 0, len(s.logins))
+	for i := len(s.logins) - 1; i >= 0; i-- {
+		attempt := s.logins[i]
+		if attempt.AttemptedAt.Before(before) {
+			continue
+		}
+		if s.opts.LoginHistoryLimit != 0 && newer[attempt.Username] >= s.opts.LoginHistoryLimit {
+			continue
+		}
+		newer[attempt.Username]++
+		kept = append(kept, attempt)
+	}
+	for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+		kept[i], kept[j] = kept[j], kept[i]
+	}
+	purged := int64(len(s.logins) - len(kept))
+	s.logins = kept
+
+	return purged, nil
+}
+
+// AuditCreate - append an entry to the audit log, OccurredAt is set by the store
+func (s *Store) AuditCreate(ctx context.Context, entry data.AuditEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastAuditID++
+	entry.ID = s.lastAuditID
+	entry.OccurredAt = *now()
+
+	// snapshots are copied so later changes by the caller do not alter the log, empty snapshots are stored as absent
+	entry.Before = copySnapshot(entry.Before)
+	entry.After = copySnapshot(entry.After)
+	s.audit = append(s.audit, entry)
+
+	return nil
+}
+
+// copySnapshot - copy an audit snapshot, nil when empty
+func copySnapshot(snapshot []byte) []byte {
+	if len(snapshot) == 0 {
+		return nil
+	}
+	return append([]byte(nil), snapshot...)
+}
+
+// AuditGetSelection - retrieve the audit log entries matching filter, most recent first
+// - for the specified page and result set size (default page=1 and page_size=30)
+// - a 1 based page index is used
+func (s *Store) AuditGetSelection(ctx context.Context, page uint32, pageSize uint32, filter data.AuditFilter) (data.AuditEntries, error) {
+	if err := ctx.Err(); err != nil {
+		return data.AuditEntries{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries data.AuditEntries
+
+	// entries are appended in order so walking backwards is most recent first
+	var matched []data.AuditEntry
+	for i := len(s.audit) - 1; i >= 0; i-- {
+		entry := s.audit[i]
+		if filter.Actor != "" && entry.Actor != filter.Actor {
+			continue
+		}
+		if filter.Action != "" && entry.Action != filter.Action {
+			continue
+		}
+		if filter.Entity != "" && entry.Entity != filter.Entity {
+			continue
+		}
+		if filter.EntityID != "" && entry.EntityID != filter.EntityID {
+			continue
+		}
+		if !filter.Since.IsZero() && entry.OccurredAt.Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && !entry.OccurredAt.Before(filter.Until) {
+			continue
+		}
+		matched = append(matched, entry)
+	}
+
+	start, end, totalPages := pageBounds(len(matched), page, pageSize)
+	entries.TotalItems = uint32(len(matched))
+	entries.TotalPages = totalPages
+	if start < end {
+		entries.Entries = matched[start:end]
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,191 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/halt-joe/ftp-user-svc/data"
+	"github.com/halt-joe/ftp-user-svc/data/datastoretest"
+)
+
+func TestFtpUserCreateConcurrent(t *testing.T) {
+	s := New()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		ids     = make(map[uint32]bool)
+		created int
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// every username is requested twice so half of the creates are duplicates
+			user, err := s.FtpUserCreate(context.Background(), data.FtpUser{Username: fmt.Sprintf("user%d", i%25), Password: "secret"})
+			if err != nil {
+				if !errors.Is(err, data.ErrFTPAccountExists) {
+					t.Errorf("unexpected error from FtpUserCreate %s", err)
+				}
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if ids[user.ID] {
+				t.Errorf("id %d was returned twice", user.ID)
+			}
+			ids[user.ID] = true
+			created++
+		}(i)
+	}
+	wg.Wait()
+
+	if created != 25 {
+		t.Errorf("expected 25 accounts to be created but received %d", created)
+	}
+}
+
+func TestFtpUserSelection(t *testing.T) {
+	s := New()
+
+	for _, username := range []string{"Charlie", "alpha", "Bravo", "delta"} {
+		if _, err := s.FtpUserCreate(context.Background(), data.FtpUser{Username: username, Description: username + " user"}); err != nil {
+			t.Fatalf("unexpected error from FtpUserCreate %s", err)
+		}
+	}
+	if err := s.FtpUserRecordLogin(context.Background(), 3, "10.0.0.1"); err != nil {
+		t.Fatalf("unexpected error from FtpUserRecordLogin %s", err)
+	}
+	if err := s.FtpUserDelete(context.Background(), 4, nil); err != nil {
+		t.Fatalf("unexpected error from FtpUserDelete %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		page     uint32
+		pageSize uint32
+		search   string
+		filter   data.FtpUserFilter
+		expected []uint32
+		pages    uint32
+	}{
+		{name: "Default", expected: []uint32{1, 2, 3}, pages: 1},
+		{name: "Second page", page: 2, pageSize: 2, expected: []uint32{3}, pages: 2},
+		{name: "Page past the end", page: 3, pageSize: 2, expected: nil, pages: 2},
+		{name: "Search ignores case", search: "ALPHA", expected: []uint32{2}, pages: 1},
+		{name: "Search description", search: "o user", expected: []uint32{3}, pages: 1},
+		{name: "Sort by username descending", filter: data.FtpUserFilter{Sort: "-username"}, expected: []uint32{2, 1, 3}, pages: 1},
+		{name: "Never logged in sorts first", filter: data.FtpUserFilter{Sort: "-last_login_at"}, expected: []uint32{3, 1, 2}, pages: 1},
+		{name: "Logged in after", filter: data.FtpUserFilter{LastLoginAfter: time.Now().Add(-time.Hour)}, expected: []uint32{3}, pages: 1},
+		{name: "Deleted", filter: data.FtpUserFilter{Deleted: true}, expected: []uint32{4}, pages: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, err := s.FtpUserGetSelection(context.Background(), tt.page, tt.pageSize, tt.search, tt.filter)
+			if err != nil {
+				t.Fatalf("unexpected error from FtpUserGetSelection %s", err)
+			}
+
+			var ids []uint32
+			for _, user := range users.Ftpusers {
+				ids = append(ids, user.ID)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.expected) {
+				t.Errorf("expected ids %v but received %v", tt.expected, ids)
+			}
+			if users.TotalPages != tt.pages {
+				t.Errorf("expected %d pages but received %d", tt.pages, users.TotalPages)
+			}
+		})
+	}
+}
+
+func TestMappingCreate(t *testing.T) {
+	s := New()
+
+	account, _ := s.FtpUserCreate(context.Background(), data.FtpUser{Username: "Test"})
+	id := account.ID
+	removed, _ := s.FtpUserCreate(context.Background(), data.FtpUser{Username: "Deleted"})
+	deleted := removed.ID
+	_ = s.FtpUserDelete(context.Background(), deleted, nil)
+
+	stale := time.Date(2022, 5, 4, 10, 15, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		mapping  data.NewMapping
+		expected int
+	}{
+		{name: "Insert", mapping: data.NewMapping{System: "BillSys1", SystemID: "123", FTPAccountID: id}, expected: data.MappingInserted},
+		{name: "Update", mapping: data.NewMapping{System: "BillSys1", SystemID: "123", FTPAccountID: id}, expected: data.MappingUpdated},
+		{name: "Deleted account", mapping: data.NewMapping{System: "BillSys1", SystemID: "456", FTPAccountID: deleted}, expected: data.MappingFTPAccountNotFound},
+		{name: "Stale version", mapping: data.NewMapping{System: "BillSys1", SystemID: "123", FTPAccountID: id, UpdatedOn: &stale}, expected: data.MappingModified},
+		{name: "Versioned without a mapping", mapping: data.NewMapping{System: "BillSys1", SystemID: "789", FTPAccountID: id, UpdatedOn: &stale}, expected: data.MappingModified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := s.MappingCreate(context.Background(), tt.mapping)
+			if err != nil {
+				t.Fatalf("unexpected error from MappingCreate %s", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected result %d but received %d", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestFtpUserPurge(t *testing.T) {
+	s := New()
+
+	created, _ := s.FtpUserCreate(context.Background(), data.FtpUser{Username: "Test"})
+	id := created.ID
+	_, _ = s.MappingCreate(context.Background(), data.NewMapping{System: "BillSys1", SystemID: "123", FTPAccountID: id})
+	_ = s.FtpUserDelete(context.Background(), id, nil)
+
+	purged, err := s.FtpUserPurge(context.Background(), time.Now().Add(time.Minute))
+	if err != nil || purged != 1 {
+		t.Fatalf("expected one account purged but received %d %v", purged, err)
+	}
+
+	// the username is free again and the mapping went with the account
+	if _, err := s.FtpUserCreate(context.Background(), data.FtpUser{Username: "Test"}); err != nil {
+		t.Errorf("unexpected error from FtpUserCreate %s", err)
+	}
+	if ids, _ := s.SystemIDUserRetrieve(context.Background(), "BillSys1"); len(ids) != 0 {
+		t.Errorf("expected the mapping to be purged but found %v", ids)
+	}
+}
+
+func TestFtpUserGetCopy(t *testing.T) {
+	s := New()
+
+	created, _ := s.FtpUserCreate(context.Background(), data.FtpUser{Username: "Test", Password: "secret"})
+	id := created.ID
+
+	user, _ := s.FtpUserGet(context.Background(), id)
+	if user.Password != "" {
+		t.Errorf("expected the password to be withheld but received %s", user.Password)
+	}
+
+	// changing the returned version must not change the stored version
+	version := *user.UpdatedOn
+	*user.UpdatedOn = version.Add(time.Hour)
+	user.Description = "A changed user"
+	user.UpdatedOn = &version
+	if err := s.FtpUserUpdate(context.Background(), user); err != nil {
+		t.Errorf("unexpected error from FtpUserUpdate %s", err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	datastoretest.Run(t, func(t *testing.T, opts data.Options) data.Datastore {
+		return NewWithOptions(opts)
+	})
+}
@@ -0,0 +1,101 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/halt-joe/ftp-user-svc/data"
+)
+
+// tx - a working copy of a Store whose changes replace the Store's when committed
+// - the Store is locked from Begin until Commit or Rollback, so transactions and writes are serialised
+// - the working copy must not be used once the transaction has ended
+type tx struct {
+	*Store
+
+	parent *Store
+	once   sync.Once
+}
+
+// compile time check that tx satisfies the data.Tx interface
+var _ data.Tx = (*tx)(nil)
+
+// Begin - start a transaction, every other call on the Store waits until it is committed or rolled back
+func (s *Store) Begin(ctx context.Context) (data.Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+
+	return &tx{Store: s.clone(), parent: s}, nil
+}
+
+// clone - a copy of the Store's contents with a lock of its own
+func (s *Store) clone() *Store {
+	c := &Store{
+		opts:          s.opts,
+		accounts:      make(map[uint32]data.FtpUser, len(s.accounts)),
+		mappings:      make(map[mappingKey]storedMapping, len(s.mappings)),
+		systems:       make(map[string]registeredSystem, len(s.systems)),
+		logins:        append([]data.LoginAttempt(nil), s.logins...),
+		audit:         append([]data.AuditEntry(nil), s.audit...),
+		lastAccountID: s.lastAccountID,
+		lastLoginID:   s.lastLoginID,
+		lastAuditID:   s.lastAuditID,
+	}
+	for id, user := range s.accounts {
+		user.LastLoginAt = copyTime(user.LastLoginAt)
+		user.DeletedAt = copyTime(user.DeletedAt)
+		user.UpdatedOn = copyTime(user.UpdatedOn)
+		c.accounts[id] = user
+	}
+	for key, mapping := range s.mappings {
+		c.mappings[key] = mapping
+	}
+	for name, system := range s.systems {
+		c.systems[name] = system
+	}
+
+	return c
+}
+
+// Begin - transactions cannot be nested
+func (t *tx) Begin(ctx context.Context) (data.Tx, error) {
+	return nil, data.ErrNestedTransaction
+}
+
+// Commit - replace the Store's contents with the transaction's
+func (t *tx) Commit() error {
+	return t.end(func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		t.parent.accounts = t.accounts
+		t.parent.mappings = t.mappings
+		t.parent.systems = t.systems
+		t.parent.logins = t.logins
+		t.parent.audit = t.audit
+		t.parent.lastAccountID = t.lastAccountID
+		t.parent.lastLoginID = t.lastLoginID
+		t.parent.lastAuditID = t.lastAuditID
+	})
+}
+
+// Rollback - discard the transaction's changes
+func (t *tx) Rollback() error {
+	return t.end(func() {})
+}
+
+// end - apply finish and release the Store, sql.ErrTxDone is returned when the transaction has already ended
+func (t *tx) end(finish func()) error {
+	err := sql.ErrTxDone
+	t.once.Do(func() {
+		finish()
+		t.parent.mu.Unlock()
+		err = nil
+	})
+
+	return err
+}
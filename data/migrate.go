@@ -0,0 +1,335 @@
+package data
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// migrationFiles - the numbered migrations for each driver named <version>_<name>.<up|down>.sql
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration Errors
+const (
+	ErrSchemaOutOfDate   = "The database schema is out of date, %d migrations are pending. Run migrate up"
+	ErrMigrationFileName = "Invalid migration file name %s"
+	ErrMigrationMissing  = "Migration %04d %s is missing its %s file"
+)
+
+// Migration - a numbered schema change with the statements that apply and revert it
+type Migration struct {
+	Version uint32
+	Name    string
+	up      string
+	down    string
+}
+
+// MigrationStatus - a migration and the time it was applied, AppliedAt is nil when it is pending
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// loadMigrations - read the embedded migrations for driver ordered by version
+func loadMigrations(driver string) ([]Migration, error) {
+	dir := "migrations/" + driver
+
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint32]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+
+		var base string
+		up := strings.HasSuffix(name, ".up.sql")
+		switch {
+		case up:
+			base = strings.TrimSuffix(name, ".up.sql")
+		case strings.HasSuffix(name, ".down.sql"):
+			base = strings.TrimSuffix(name, ".down.sql")
+		default:
+			continue
+		}
+
+		segs := strings.SplitN(base, "_", 2)
+		if len(segs) != 2 {
+			return nil, fmt.Errorf(ErrMigrationFileName, name)
+		}
+
+		version, err := strconv.ParseUint(segs[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf(ErrMigrationFileName, name)
+		}
+
+		content, err := migrationFiles.ReadFile(dir + "/" + name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[uint32(version)]
+		if !ok {
+			m = &Migration{Version: uint32(version), Name: segs[1]}
+			byVersion[uint32(version)] = m
+		}
+		if up {
+			m.up = string(content)
+		} else {
+			m.down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf(ErrMigrationMissing, m.Version, m.Name, "up")
+		}
+		if m.down == "" {
+			return nil, fmt.Errorf(ErrMigrationMissing, m.Version, m.Name, "down")
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// splitStatements - split a migration into its statements, each terminated by a ; at the end of a line
+// - a ; within a $$ quoted body, such as a PostgreSQL do block, does not end the statement
+func splitStatements(migration string) []string {
+	var (
+		statements []string
+		pending    string
+	)
+
+	for _, part := range strings.Split(migration, ";\n") {
+		pending += part
+		if strings.Count(pending, "$$")%2 == 1 {
+			pending += ";\n"
+			continue
+		}
+
+		statement := strings.TrimSuffix(strings.TrimSpace(pending), ";")
+		pending = ""
+		if statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	if statement := strings.TrimSpace(pending); statement != "" {
+		statements = append(statements, statement)
+	}
+
+	return statements
+}
+
+// ensureMigrationsTable - create the schema_migrations tracking table when it does not exist
+// - only migrating creates it, reading the status must work for a user that cannot change the schema
+func (db *Database) ensureMigrationsTable(ctx context.Context) error {
+	qry := "create table if not exists `schema_migrations` ("
+	qry += "`version` integer not null primary key, "
+	qry += "`name` varchar(255) not null, "
+	qry += "`applied_at` timestamp not null default current_timestamp)"
+
+	_, err := db.ExecForDriver(ctx, qry)
+	if err != nil {
+		log.Error(err.Error())
+	}
+
+	return err
+}
+
+// migrationsTableExists - whether the schema_migrations tracking table has been created
+func (db *Database) migrationsTableExists(ctx context.Context) (bool, error) {
+	var count int
+	err := db.QueryRowForDriver(ctx, db.dialect().TableExists(), "schema_migrations").Scan(&count)
+	if err != nil {
+		log.Error(err.Error())
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// appliedMigrations - the versions recorded in schema_migrations and when they were applied
+// - none are applied when the table has not been created yet
+func (db *Database) appliedMigrations(ctx context.Context) (map[uint32]time.Time, error) {
+	applied := make(map[uint32]time.Time)
+
+	exists, err := db.migrationsTableExists(ctx)
+	if err != nil || !exists {
+		return applied, err
+	}
+
+	results, err := db.QueryForDriver(ctx, "select `version`, `applied_at` from `schema_migrations`")
+	if err != nil {
+		log.Error(err.Error())
+		return applied, err
+	}
+	defer results.Close()
+
+	for results.Next() {
+		var (
+			version   uint32
+			appliedAt time.Time
+		)
+		err = results.Scan(&version, &appliedAt)
+		if err != nil {
+			return applied, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, results.Err()
+}
+
+// MigrationStatus - list every known migration and when it was applied
+// - reads the schema only, it does not create the schema_migrations table
+func (db *Database) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
+		return nil, dbErr
+	}
+
+	migrations, err := loadMigrations(db.dialect().DriverName())
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Migration: m}
+		if t, ok := applied[m.Version]; ok {
+			s.AppliedAt = &t
+		}
+		status = append(status, s)
+	}
+
+	return status, nil
+}
+
+// MigrateUp - apply every pending migration in version order, returning the migrations applied
+func (db *Database) MigrateUp(ctx context.Context) ([]Migration, error) {
+	if err := db.ensureMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+
+	status, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrated []Migration
+	for _, s := range status {
+		if s.AppliedAt != nil {
+			continue
+		}
+
+		err = db.runMigration(ctx, s.Migration, true)
+		if err != nil {
+			return migrated, fmt.Errorf("migration %04d %s failed: %s", s.Version, s.Name, err.Error())
+		}
+		migrated = append(migrated, s.Migration)
+	}
+
+	return migrated, nil
+}
+
+// MigrateDown - revert the most recently applied migration, returning nil when none are applied
+func (db *Database) MigrateDown(ctx context.Context) (*Migration, error) {
+	if err := db.ensureMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+
+	status, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(status) - 1; i >= 0; i-- {
+		if status[i].AppliedAt == nil {
+			continue
+		}
+
+		m := status[i].Migration
+		err = db.runMigration(ctx, m, false)
+		if err != nil {
+			return nil, fmt.Errorf("migration %04d %s failed: %s", m.Version, m.Name, err.Error())
+		}
+		return &m, nil
+	}
+
+	return nil, nil
+}
+
+// runMigration - apply or revert a migration and record the change in schema_migrations
+// - statements run in a transaction, MySQL commits DDL statements implicitly so a failed
+// migration may be partly applied there
+func (db *Database) runMigration(ctx context.Context, m Migration, up bool) error {
+	statements := splitStatements(m.down)
+	if up {
+		statements = splitStatements(m.up)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range statements {
+		_, err = tx.ExecContext(ctx, statement)
+		if err != nil {
+			log.Error(err.Error(), "migration", m.Version)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, db.bind("insert into `schema_migrations` (`version`, `name`) values (?, ?)"), m.Version, m.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, db.bind("delete from `schema_migrations` where `version` = ?"), m.Version)
+	}
+	if err != nil {
+		log.Error(err.Error(), "migration", m.Version)
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkSchemaVersion - return ErrSchemaOutOfDate when migrations are pending
+func (db *Database) checkSchemaVersion(ctx context.Context) error {
+	status, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, s := range status {
+		if s.AppliedAt == nil {
+			pending++
+		}
+	}
+
+	if pending > 0 {
+		return fmt.Errorf(ErrSchemaOutOfDate, pending)
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	for _, driver := range []string{MySQLDriverName, PostgreSQLDriverName, SQLiteDriverName} {
+		t.Run(driver, func(t *testing.T) {
+			migrations, err := loadMigrations(driver)
+			if err != nil {
+				t.Fatalf("unexpected error from loadMigrations %s", err)
+			}
+			if len(migrations) == 0 {
+				t.Fatalf("no migrations found for %s", driver)
+			}
+
+			for i, m := range migrations {
+				if m.Version != uint32(i+1) {
+					t.Errorf("expected migration version %d but found %d", i+1, m.Version)
+				}
+				if len(splitStatements(m.up)) == 0 || len(splitStatements(m.down)) == 0 {
+					t.Errorf("migration %04d %s has no statements", m.Version, m.Name)
+				}
+			}
+		})
+	}
+
+	mysql, _ := loadMigrations(MySQLDriverName)
+	postgres, _ := loadMigrations(PostgreSQLDriverName)
+	sqlite, _ := loadMigrations(SQLiteDriverName)
+	if len(mysql) != len(postgres) || len(mysql) != len(sqlite) {
+		t.Errorf("expected the same number of migrations for each driver but found %d, %d and %d", len(mysql), len(postgres), len(sqlite))
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	migration := "-- a comment\ncreate table `a` (\n    `id` int\n);\n\ncreate index ix on `a` (`id`);\n"
+	expected := []string{"-- a comment\ncreate table `a` (\n    `id` int\n)", "create index ix on `a` (`id`)"}
+
+	statements := splitStatements(migration)
+	if !reflect.DeepEqual(statements, expected) {
+		t.Errorf("expected statements %q but received %q", expected, statements)
+	}
+}
+
+func TestSplitStatementsDollarQuoted(t *testing.T) {
+	migration := "do $$\nbegin\n    create extension x;\n    create index ix on a (b);\nend\n$$;\ndrop index iy;\n"
+	expected := []string{"do $$\nbegin\n    create extension x;\n    create index ix on a (b);\nend\n$$", "drop index iy"}
+
+	statements := splitStatements(migration)
+	if !reflect.DeepEqual(statements, expected) {
+		t.Errorf("expected statements %q but received %q", expected, statements)
+	}
+}
+
+func TestMigrateUp(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf(errDBConnectionError, err)
+	}
+	defer db.Close()
+
+	dBase := &Database{DB: db, Dialect: MySQL}
+
+	migrations, err := loadMigrations(MySQLDriverName)
+	if err != nil {
+		t.Fatalf("unexpected error from loadMigrations %s", err)
+	}
+	latest := migrations[len(migrations)-1]
+
+	// every migration but the latest is applied
+	applied := mock.NewRows([]string{"version", "applied_at"})
+	for _, m := range migrations[:len(migrations)-1] {
+		applied = applied.AddRow(m.Version, testUpdatedOn)
+	}
+
+	mock.ExpectExec("create table if not exists [`\"]schema_migrations[`\"]").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("from information_schema.tables").WithArgs("schema_migrations").WillReturnRows(mock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("select [`\"]version[`\"], [`\"]applied_at[`\"] from [`\"]schema_migrations[`\"]").WillReturnRows(applied)
+	mock.ExpectBegin()
+	for range splitStatements(latest.up) {
+		mock.ExpectExec(".+").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec("insert into [`\"]schema_migrations[`\"]").WithArgs(latest.Version, latest.Name).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	migrated, err := dBase.MigrateUp(context.Background())
+	if err != nil {
+		t.Errorf("unexpected error from MigrateUp %s", err)
+	}
+	if len(migrated) != 1 || migrated[0].Version != latest.Version {
+		t.Errorf("expected only migration %d to be applied but received %v", latest.Version, migrated)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCheckSchemaVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf(errDBConnectionError, err)
+	}
+	defer db.Close()
+
+	dBase := &Database{DB: db, Dialect: PostgreSQL}
+
+	migrations, err := loadMigrations(PostgreSQLDriverName)
+	if err != nil {
+		t.Fatalf("unexpected error from loadMigrations %s", err)
+	}
+
+	mock.ExpectQuery("from information_schema.tables").WithArgs("schema_migrations").WillReturnRows(mock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("select [`\"]version[`\"], [`\"]applied_at[`\"] from [`\"]schema_migrations[`\"]").WillReturnRows(mock.NewRows([]string{"version", "applied_at"}).AddRow(1, testUpdatedOn))
+
+	err = dBase.checkSchemaVersion(context.Background())
+	expErr := fmt.Sprintf(ErrSchemaOutOfDate, len(migrations)-1)
+	if err == nil || err.Error() != expErr {
+		t.Errorf("expected error %s but received %v", expErr, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCheckSchemaVersionNoMigrationsTable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf(errDBConnectionError, err)
+	}
+	defer db.Close()
+
+	dBase := &Database{DB: db, Dialect: MySQL}
+
+	migrations, err := loadMigrations(MySQLDriverName)
+	if err != nil {
+		t.Fatalf("unexpected error from loadMigrations %s", err)
+	}
+
+	// the check only reads, the missing table means every migration is pending and is not created
+	mock.ExpectQuery("from information_schema.tables").WithArgs("schema_migrations").WillReturnRows(mock.NewRows([]string{"count"}).AddRow(0))
+
+	err = dBase.checkSchemaVersion(context.Background())
+	expErr := fmt.Sprintf(ErrSchemaOutOfDate, len(migrations))
+	if err == nil || err.Error() != expErr {
+		t.Errorf("expected error %s but received %v", expErr, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
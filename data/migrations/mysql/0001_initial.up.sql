@@ -0,0 +1,17 @@
+-- existing databases created from the original schema already have these tables
+create table if not exists `ftp_account` (
+	`id` int unsigned not null auto_increment primary key,
+    `username` varchar(255) not null,
+    `description` varchar(255) not null,
+    `password` varchar(255) not null,
+    `updated_on` timestamp not null default current_timestamp,
+    constraint `uc_username` unique (`username`)
+);
+
+create table if not exists `ftp_mapping` (
+	`system` varchar(255) not null,
+	`id` varchar(255) not null,
+    `ftp_id` int unsigned not null,
+    primary key (`system` asc, `id` asc),
+    constraint `fk_ftp_account` foreign key (`ftp_id`) references `ftp_account` (`id`) on delete cascade
+);
@@ -0,0 +1,4 @@
+alter table `ftp_account`
+    drop column `last_login_at`,
+    drop column `last_login_ip`,
+    drop column `login_count`;
@@ -0,0 +1 @@
+drop table `ftp_login_history`;
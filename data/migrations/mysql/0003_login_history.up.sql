@@ -0,0 +1,13 @@
+create table `ftp_login_history` (
+	`id` bigint unsigned not null auto_increment primary key,
+    `attempted_at` timestamp not null default current_timestamp,
+    `username` varchar(255) not null,
+    `ftp_id` int unsigned null default null,
+    `ip` varchar(45) not null default '',
+    `protocol` varchar(32) not null default '',
+    `status` varchar(32) not null,
+    `request_id` varchar(36) not null default '',
+    index `ix_login_history_attempted_at` (`attempted_at`),
+    index `ix_login_history_username` (`username`, `attempted_at`),
+    index `ix_login_history_ftp_id` (`ftp_id`, `attempted_at`)
+);
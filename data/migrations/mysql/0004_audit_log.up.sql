@@ -0,0 +1,14 @@
+create table `ftp_audit_log` (
+	`id` bigint unsigned not null auto_increment primary key,
+    `occurred_at` timestamp not null default current_timestamp,
+    `actor` varchar(255) not null,
+    `action` varchar(32) not null,
+    `entity` varchar(32) not null,
+    `entity_id` varchar(511) not null,
+    `before_state` text null,
+    `after_state` text null,
+    `request_id` varchar(36) not null default '',
+    index `ix_audit_log_occurred_at` (`occurred_at`),
+    index `ix_audit_log_entity` (`entity`, `entity_id`(255)),
+    index `ix_audit_log_actor` (`actor`)
+);
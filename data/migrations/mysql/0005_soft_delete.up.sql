@@ -0,0 +1 @@
+alter table `ftp_account` add column `deleted_at` timestamp null default null;
@@ -0,0 +1,2 @@
+alter table `ftp_mapping` drop column `updated_on`;
+alter table `ftp_account` modify `updated_on` timestamp not null default current_timestamp;
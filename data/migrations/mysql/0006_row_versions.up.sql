@@ -0,0 +1,2 @@
+alter table `ftp_account` modify `updated_on` timestamp(6) not null default current_timestamp(6);
+alter table `ftp_mapping` add column `updated_on` timestamp(6) not null default current_timestamp(6);
@@ -0,0 +1 @@
+drop table `ftp_change`;
@@ -0,0 +1,7 @@
+create table `ftp_change` (
+	`id` bigint unsigned not null auto_increment primary key,
+    `changed_at` timestamp not null default current_timestamp,
+    `ftp_id` int unsigned null default null,
+    `system` varchar(255) null default null,
+    index `ix_change_changed_at` (`changed_at`)
+);
@@ -0,0 +1 @@
+alter table `ftp_account` drop index `ft_account_search`;
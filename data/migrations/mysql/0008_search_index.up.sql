@@ -0,0 +1 @@
+alter table `ftp_account` add fulltext index `ft_account_search` (`username`, `description`);
@@ -0,0 +1,2 @@
+drop table if exists ftp_mapping;
+drop table if exists ftp_account;
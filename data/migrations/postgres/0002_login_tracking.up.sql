@@ -0,0 +1,4 @@
+alter table ftp_account
+    add column last_login_at timestamp null,
+    add column last_login_ip varchar(45) null,
+    add column login_count integer not null default 0;
@@ -0,0 +1,3 @@
+-- soft deleted accounts cannot be represented without the column
+delete from ftp_account where deleted_at is not null;
+alter table ftp_account drop column deleted_at;
@@ -0,0 +1 @@
+alter table ftp_mapping drop column updated_on;
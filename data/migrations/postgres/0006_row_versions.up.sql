@@ -0,0 +1 @@
+alter table ftp_mapping add column updated_on timestamp not null default current_timestamp;
@@ -0,0 +1,7 @@
+create table ftp_change (
+    "id" bigserial primary key,
+    changed_at timestamp not null default current_timestamp,
+    ftp_id integer null,
+    "system" varchar(255) null
+);
+create index ix_change_changed_at on ftp_change (changed_at);
@@ -0,0 +1,2 @@
+drop index if exists ix_account_description_trgm;
+drop index if exists ix_account_username_trgm;
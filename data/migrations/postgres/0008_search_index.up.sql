@@ -0,0 +1,11 @@
+-- trigram indexes let like searches for any part of the username or description use an index,
+-- they are left out when the pg_trgm extension cannot be installed
+do $$
+begin
+    create extension if not exists pg_trgm;
+    create index if not exists ix_account_username_trgm on ftp_account using gin (username gin_trgm_ops);
+    create index if not exists ix_account_description_trgm on ftp_account using gin (description gin_trgm_ops);
+exception when others then
+    raise notice 'pg_trgm is not available, searches will not be indexed: %', sqlerrm;
+end
+$$;
@@ -0,0 +1,17 @@
+-- timestamps are stored as UTC text with millisecond precision so they compare and round trip exactly
+create table if not exists `ftp_account` (
+    `id` integer primary key autoincrement,
+    `username` varchar(255) not null,
+    `description` varchar(255) not null,
+    `password` varchar(255) not null,
+    `updated_on` timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    constraint `uc_username` unique (`username`)
+);
+
+create table if not exists `ftp_mapping` (
+    `system` varchar(255) not null,
+    `id` varchar(255) not null,
+    `ftp_id` integer not null,
+    primary key (`system`, `id`),
+    constraint `fk_ftp_account` foreign key (`ftp_id`) references `ftp_account` (`id`) on delete cascade
+);
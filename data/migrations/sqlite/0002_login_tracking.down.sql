@@ -0,0 +1,3 @@
+alter table `ftp_account` drop column `last_login_at`;
+alter table `ftp_account` drop column `last_login_ip`;
+alter table `ftp_account` drop column `login_count`;
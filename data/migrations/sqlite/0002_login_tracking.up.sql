@@ -0,0 +1,3 @@
+alter table `ftp_account` add column `last_login_at` timestamp null default null;
+alter table `ftp_account` add column `last_login_ip` varchar(45) null default null;
+alter table `ftp_account` add column `login_count` integer not null default 0;
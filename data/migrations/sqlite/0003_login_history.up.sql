@@ -0,0 +1,13 @@
+create table `ftp_login_history` (
+    `id` integer primary key autoincrement,
+    `attempted_at` timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    `username` varchar(255) not null,
+    `ftp_id` integer null default null,
+    `ip` varchar(45) not null default '',
+    `protocol` varchar(32) not null default '',
+    `status` varchar(32) not null,
+    `request_id` varchar(36) not null default ''
+);
+create index `ix_login_history_attempted_at` on `ftp_login_history` (`attempted_at`);
+create index `ix_login_history_username` on `ftp_login_history` (`username`, `attempted_at`);
+create index `ix_login_history_ftp_id` on `ftp_login_history` (`ftp_id`, `attempted_at`);
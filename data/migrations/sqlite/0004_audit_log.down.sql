@@ -0,0 +1 @@
+drop table `ftp_audit_log`;
@@ -0,0 +1,14 @@
+create table `ftp_audit_log` (
+    `id` integer primary key autoincrement,
+    `occurred_at` timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    `actor` varchar(255) not null,
+    `action` varchar(32) not null,
+    `entity` varchar(32) not null,
+    `entity_id` varchar(511) not null,
+    `before_state` text null,
+    `after_state` text null,
+    `request_id` varchar(36) not null default ''
+);
+create index `ix_audit_log_occurred_at` on `ftp_audit_log` (`occurred_at`);
+create index `ix_audit_log_entity` on `ftp_audit_log` (`entity`, `entity_id`);
+create index `ix_audit_log_actor` on `ftp_audit_log` (`actor`);
@@ -0,0 +1,12 @@
+-- sqlite cannot add a column with a non-constant default so the mapping table is rebuilt
+create table `ftp_mapping_versioned` (
+    `system` varchar(255) not null,
+    `id` varchar(255) not null,
+    `ftp_id` integer not null,
+    `updated_on` timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    primary key (`system`, `id`),
+    constraint `fk_ftp_account` foreign key (`ftp_id`) references `ftp_account` (`id`) on delete cascade
+);
+insert into `ftp_mapping_versioned` (`system`, `id`, `ftp_id`) select `system`, `id`, `ftp_id` from `ftp_mapping`;
+drop table `ftp_mapping`;
+alter table `ftp_mapping_versioned` rename to `ftp_mapping`;
@@ -0,0 +1,7 @@
+create table `ftp_change` (
+    `id` integer primary key autoincrement,
+    `changed_at` timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    `ftp_id` integer null default null,
+    `system` varchar(255) null default null
+);
+create index `ix_change_changed_at` on `ftp_change` (`changed_at`);
@@ -0,0 +1 @@
+-- sqlite searches without an index, the version is kept in step with the other drivers
@@ -0,0 +1,5 @@
+create table `ftp_system` (
+    `system` varchar(255) not null primary key,
+    `description` varchar(255) not null default '',
+    `created_at` timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
@@ -0,0 +1,205 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// ReplicaCheckInterval - how often each replica is pinged to decide whether reads are sent to it
+var ReplicaCheckInterval = 10 * time.Second
+
+// replica - a read only copy of the database and whether it answered its last health check
+type replica struct {
+	*Database
+	name    string
+	healthy atomic.Bool
+}
+
+// replicaSet - the replicas of a Database, healthy replicas take reads in turn
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint32
+	stop     chan struct{}
+	stopped  sync.Once
+}
+
+// AddReplicas - send lookups and listings to the databases in dataSourceNames, read only copies of db
+// configured with opts
+// - replicas are used in turn while they answer the health check every ReplicaCheckInterval
+// - a read that fails on a replica is retried on db and the replica is not used until it next answers
+func (db *Database) AddReplicas(dataSourceNames []string, opts Options) error {
+	if db.replicas != nil {
+		return errors.New("replicas have already been added")
+	}
+	if len(dataSourceNames) == 0 {
+		return nil
+	}
+
+	set := &replicaSet{stop: make(chan struct{})}
+	for i, dataSourceName := range dataSourceNames {
+		r := &replica{name: fmt.Sprintf("replica%d", i+1)}
+
+		d, err := parseDataSource(dataSourceName)
+		d.Options = opts
+		if err == nil && d.dialect() != db.dialect() {
+			err = fmt.Errorf("replica driver %s does not match %s", d.dialect().DriverName(), db.dialect().DriverName())
+		}
+		if err == nil {
+			err = d.open()
+		}
+		if err != nil {
+			set.close()
+			return fmt.Errorf("%s: %w", r.name, err)
+		}
+
+		r.Database = &d
+		set.replicas = append(set.replicas, r)
+	}
+
+	set.check()
+	go set.monitor()
+
+	db.replicas = set
+	return nil
+}
+
+// Replicas - the connection pool of each replica, in the order they were added
+func (db *Database) Replicas() []*sql.DB {
+	if db.replicas == nil {
+		return nil
+	}
+
+	pools := make([]*sql.DB, len(db.replicas.replicas))
+	for i, r := range db.replicas.replicas {
+		pools[i] = r.DB
+	}
+
+	return pools
+}
+
+// Close - close the database and its replicas
+func (db *Database) Close() error {
+	if db.replicas != nil {
+		db.replicas.close()
+	}
+
+	return db.DB.Close()
+}
+
+// read - run fn against the database reads are sent to
+// - a replica is used outside of a transaction until ctx has seen a write, see TrackWrites
+// - a replica that fails is marked unhealthy and fn is run again against the primary
+func (db *Database) read(ctx context.Context, fn func(r *Database) error) error {
+	if db.tx != nil || db.replicas == nil || hasWritten(ctx) {
+		return fn(db)
+	}
+
+	r := db.replicas.pick()
+	if r == nil {
+		return fn(db)
+	}
+
+	err := fn(r.Database)
+	if err == nil || ctx.Err() != nil || isDataError(err) {
+		return err
+	}
+
+	log.Warn("Replica read failed, using the primary", "replica", r.name, "error", err.Error())
+	r.healthy.Store(false)
+
+	return fn(db)
+}
+
+// isDataError - whether err is an answer from the database rather than a failure to reach it
+func isDataError(err error) bool {
+	var dataErr *Error
+	return errors.As(err, &dataErr) || errors.Is(err, sql.ErrNoRows)
+}
+
+// pick - the next healthy replica, nil when none are healthy
+func (s *replicaSet) pick() *replica {
+	n := uint32(len(s.replicas))
+	start := s.next.Add(1)
+	for i := uint32(0); i < n; i++ {
+		r := s.replicas[(start+i)%n]
+		if r.healthy.Load() {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// check - ping every replica and record whether it answered
+func (s *replicaSet) check() {
+	for _, r := range s.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), ReplicaCheckInterval)
+		err := r.PingContext(ctx)
+		cancel()
+
+		healthy := err == nil
+		if r.healthy.Swap(healthy) != healthy {
+			if healthy {
+				log.Info("Replica available", "replica", r.name)
+			} else {
+				log.Warn("Replica unavailable", "replica", r.name, "error", err.Error())
+			}
+		}
+	}
+}
+
+// monitor - check the replicas every ReplicaCheckInterval until the set is closed
+func (s *replicaSet) monitor() {
+	ticker := time.NewTicker(ReplicaCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.check()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// close - stop the health checks and close every replica
+func (s *replicaSet) close() {
+	s.stopped.Do(func() {
+		close(s.stop)
+		for _, r := range s.replicas {
+			if r.Database != nil {
+				r.DB.Close()
+			}
+		}
+	})
+}
+
+// writesKey - the context key of the flag recording a write made with the context
+type writesKey struct{}
+
+// TrackWrites - a context for a single request, once a write is made with it reads go to the primary
+// so the request sees its own writes
+func TrackWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, writesKey{}, new(atomic.Bool))
+}
+
+// markWrite - record that a write was made with ctx
+func markWrite(ctx context.Context) {
+	if written, ok := ctx.Value(writesKey{}).(*atomic.Bool); ok {
+		written.Store(true)
+	}
+}
+
+// hasWritten - whether a write was made with ctx
+func hasWritten(ctx context.Context) bool {
+	written, ok := ctx.Value(writesKey{}).(*atomic.Bool)
+	return ok && written.Load()
+}
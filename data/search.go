@@ -0,0 +1,91 @@
+package data
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Search modes of FtpUserFilter.SearchMode, SearchContains when not set
+// - SearchContains matches any part of the username or description
+// - SearchPrefix matches the start of the username or description
+// - SearchWildcard matches the whole username or description, * matching any text and ? any one character
+// - SearchWords matches accounts where every word starts a word of the username or description,
+// through the full text index on MySQL
+const (
+	SearchContains = "contains"
+	SearchPrefix   = "prefix"
+	SearchWildcard = "wildcard"
+	SearchWords    = "words"
+)
+
+// MaxSearchLength - the most characters a search may have
+const MaxSearchLength = 100
+
+// searchOperators - the characters with a meaning in a MySQL boolean full text search, dropped from SearchWords words
+const searchOperators = `+-<>()~*"@`
+
+// ValidSearch - report whether search can be run in mode
+// - search must be at most MaxSearchLength characters, and have text to match in SearchWildcard and SearchWords
+func ValidSearch(search string, mode string) bool {
+	if utf8.RuneCountInString(search) > MaxSearchLength {
+		return false
+	}
+
+	switch mode {
+	case "", SearchContains, SearchPrefix:
+		return true
+	case SearchWildcard:
+		return search == "" || strings.Trim(search, "*?") != ""
+	case SearchWords:
+		return search == "" || len(SearchWordsOf(search)) > 0
+	}
+
+	return false
+}
+
+// SearchWordsOf - the words matched by a SearchWords search, without the MySQL boolean operators
+func SearchWordsOf(search string) []string {
+	return strings.Fields(strings.Map(func(r rune) rune {
+		if strings.ContainsRune(searchOperators, r) {
+			return ' '
+		}
+		return r
+	}, search))
+}
+
+// searchCondition - the condition matching the accounts found by search in mode, with its args
+func (db *Database) searchCondition(search string, mode string) (string, []interface{}) {
+	columns := "(`username` like ?" + likeEscape + " or `description` like ?" + likeEscape + ")"
+
+	switch mode {
+	case SearchPrefix:
+		pattern := escapeLike(search) + "%"
+		return columns, []interface{}{pattern, pattern}
+
+	case SearchWildcard:
+		pattern := strings.NewReplacer("*", "%", "?", "_").Replace(escapeLike(search))
+		return columns, []interface{}{pattern, pattern}
+
+	case SearchWords:
+		words := SearchWordsOf(search)
+		if db.dialect() == MySQL {
+			return "match (`username`, `description`) against (? in boolean mode)", []interface{}{"+" + strings.Join(words, "* +") + "*"}
+		}
+
+		// a word starts the column or follows a space
+		var (
+			conditions []string
+			args       []interface{}
+		)
+		for _, word := range words {
+			start, inner := escapeLike(word)+"%", "% "+escapeLike(word)+"%"
+			conditions = append(conditions, "(`username` like ?"+likeEscape+" or `username` like ?"+likeEscape+
+				" or `description` like ?"+likeEscape+" or `description` like ?"+likeEscape+")")
+			args = append(args, start, inner, start, inner)
+		}
+		return strings.Join(conditions, " and "), args
+	}
+
+	pattern := "%" + escapeLike(search) + "%"
+	return columns, []interface{}{pattern, pattern}
+}
@@ -0,0 +1,95 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchCondition(t *testing.T) {
+	tests := []struct {
+		name     string
+		dialect  Dialect
+		search   string
+		mode     string
+		expCond  string
+		expArgs  []interface{}
+		expValid bool
+	}{
+		{
+			name:     "Contains",
+			search:   "a_b%",
+			expCond:  "(`username` like ? escape '!' or `description` like ? escape '!')",
+			expArgs:  []interface{}{"%a!_b!%%", "%a!_b!%%"},
+			expValid: true,
+		},
+		{
+			name:     "Prefix",
+			search:   "a!b",
+			mode:     SearchPrefix,
+			expCond:  "(`username` like ? escape '!' or `description` like ? escape '!')",
+			expArgs:  []interface{}{"a!!b%", "a!!b%"},
+			expValid: true,
+		},
+		{
+			name:     "Wildcard",
+			search:   "a*_?",
+			mode:     SearchWildcard,
+			expCond:  "(`username` like ? escape '!' or `description` like ? escape '!')",
+			expArgs:  []interface{}{"a%!__", "a%!__"},
+			expValid: true,
+		},
+		{
+			name:     "Words MySQL",
+			dialect:  MySQL,
+			search:   "billing +export*",
+			mode:     SearchWords,
+			expCond:  "match (`username`, `description`) against (? in boolean mode)",
+			expArgs:  []interface{}{"+billing* +export*"},
+			expValid: true,
+		},
+		{
+			name:     "Words",
+			dialect:  PostgreSQL,
+			search:   "50%",
+			mode:     SearchWords,
+			expCond:  "(`username` like ? escape '!' or `username` like ? escape '!' or `description` like ? escape '!' or `description` like ? escape '!')",
+			expArgs:  []interface{}{"50!%%", "% 50!%%", "50!%%", "% 50!%%"},
+			expValid: true,
+		},
+		{name: "Only Wildcards", search: "**", mode: SearchWildcard},
+		{name: "Only Operators", search: "+ -", mode: SearchWords},
+		{name: "Unknown Mode", search: "a", mode: "regexp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if valid := ValidSearch(tt.search, tt.mode); valid != tt.expValid {
+				t.Fatalf("expected ValidSearch %t but received %t", tt.expValid, valid)
+			}
+			if !tt.expValid {
+				return
+			}
+
+			db := &Database{Dialect: tt.dialect}
+			condition, args := db.searchCondition(tt.search, tt.mode)
+			if condition != tt.expCond {
+				t.Errorf("expected condition %q but received %q", tt.expCond, condition)
+			}
+			if !reflect.DeepEqual(args, tt.expArgs) {
+				t.Errorf("expected args %v but received %v", tt.expArgs, args)
+			}
+		})
+	}
+}
+
+func TestValidSearchLength(t *testing.T) {
+	search := ""
+	for i := 0; i < MaxSearchLength; i++ {
+		search += "é"
+	}
+	if !ValidSearch(search, "") {
+		t.Errorf("expected a search of %d characters to be valid", MaxSearchLength)
+	}
+	if ValidSearch(search+"a", "") {
+		t.Errorf("expected a search of more than %d characters to be rejected", MaxSearchLength)
+	}
+}
@@ -0,0 +1,284 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newSQLiteDB - an in memory SQLite database with every migration applied
+func newSQLiteDB(t *testing.T) *Database {
+	t.Helper()
+
+	db, err := NewDB("sqlite://file::memory:", DefaultOptions())
+	if err != nil {
+		t.Fatalf("unexpected error from NewDB %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.MigrateUp(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error from MigrateUp %s", err)
+	}
+
+	return db
+}
+
+func TestSQLiteMigrateDown(t *testing.T) {
+	db := newSQLiteDB(t)
+
+	for {
+		m, err := db.MigrateDown(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error from MigrateDown %s", err)
+		}
+		if m == nil {
+			break
+		}
+	}
+
+	migrated, err := db.MigrateUp(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error from MigrateUp %s", err)
+	}
+
+	migrations, _ := loadMigrations(SQLiteDriverName)
+	if len(migrated) != len(migrations) {
+		t.Errorf("expected %d migrations to be reapplied but received %d", len(migrations), len(migrated))
+	}
+}
+
+func TestSQLiteMigrationStatus(t *testing.T) {
+	db, err := NewDB("sqlite://file::memory:", DefaultOptions())
+	if err != nil {
+		t.Fatalf("unexpected error from NewDB %s", err)
+	}
+	defer db.Close()
+
+	status, err := db.MigrationStatus(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error from MigrationStatus %s", err)
+	}
+	for _, s := range status {
+		if s.AppliedAt != nil {
+			t.Errorf("expected migration %d to be pending", s.Version)
+		}
+	}
+
+	exists, err := db.migrationsTableExists(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error from migrationsTableExists %s", err)
+	}
+	if exists {
+		t.Error("expected MigrationStatus not to create the schema_migrations table")
+	}
+
+	if _, err = db.MigrateUp(context.Background()); err != nil {
+		t.Fatalf("unexpected error from MigrateUp %s", err)
+	}
+	status, err = db.MigrationStatus(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error from MigrationStatus %s", err)
+	}
+	for _, s := range status {
+		if s.AppliedAt == nil {
+			t.Errorf("expected migration %d to be applied", s.Version)
+		}
+	}
+}
+
+func TestSQLiteFtpUser(t *testing.T) {
+	db := newSQLiteDB(t)
+
+	created, err := db.FtpUserCreate(context.Background(), FtpUser{Username: "Test", Description: "A test user", Password: "secret"})
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserCreate %s", err)
+	}
+	id := created.ID
+
+	_, err = db.FtpUserCreate(context.Background(), FtpUser{Username: "Test", Description: "A duplicate user", Password: "secret"})
+	if !errors.Is(err, ErrFTPAccountExists) {
+		t.Errorf("expected error %s but received %v", ErrFTPAccountExists, err)
+	}
+
+	user, err := db.FtpUserGet(context.Background(), id)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserGet %s", err)
+	}
+	if user.Username != "Test" || user.UpdatedOn == nil {
+		t.Fatalf("unexpected user returned %+v", user)
+	}
+
+	// a write against the version read succeeds, repeating it is rejected as the version has moved on
+	// - versions have millisecond precision in SQLite so the write must not share the create's millisecond
+	time.Sleep(2 * time.Millisecond)
+	user.Description = "A changed user"
+	err = db.FtpUserUpdate(context.Background(), user)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserUpdate %s", err)
+	}
+	err = db.FtpUserUpdate(context.Background(), user)
+	if !errors.Is(err, ErrFTPAccountModified) {
+		t.Errorf("expected error %s but received %v", ErrFTPAccountModified, err)
+	}
+
+	err = db.FtpUserRecordLogin(context.Background(), id, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserRecordLogin %s", err)
+	}
+
+	users, err := db.FtpUserGetSelection(context.Background(), 1, 10, "changed", FtpUserFilter{LastLoginAfter: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserGetSelection %s", err)
+	}
+	if users.TotalItems != 1 || len(users.Ftpusers) != 1 || users.Ftpusers[0].LoginCount != 1 || users.Ftpusers[0].LastLoginAt == nil {
+		t.Errorf("unexpected selection returned %+v", users)
+	}
+
+	err = db.FtpUserDelete(context.Background(), id, nil)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserDelete %s", err)
+	}
+	_, err = db.FtpUserGet(context.Background(), id)
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected error %s but received %v", ErrUserNotFound, err)
+	}
+
+	err = db.FtpUserRestore(context.Background(), id)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserRestore %s", err)
+	}
+	_, err = db.FtpUserGet(context.Background(), id)
+	if err != nil {
+		t.Errorf("unexpected error from FtpUserGet after restore %s", err)
+	}
+}
+
+func TestSQLiteMapping(t *testing.T) {
+	db := newSQLiteDB(t)
+
+	created, err := db.FtpUserCreate(context.Background(), FtpUser{Username: "Test", Description: "A test user", Password: "secret"})
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserCreate %s", err)
+	}
+	id := created.ID
+
+	tests := []struct {
+		name     string
+		mapping  NewMapping
+		expected int
+	}{
+		{name: "Insert", mapping: NewMapping{System: "BillSys1", SystemID: "123", FTPAccountID: id}, expected: MappingInserted},
+		{name: "Update", mapping: NewMapping{System: "BillSys1", SystemID: "123", FTPAccountID: id}, expected: MappingUpdated},
+		{name: "Account not found", mapping: NewMapping{System: "BillSys1", SystemID: "456", FTPAccountID: id + 1}, expected: MappingFTPAccountNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := db.MappingCreate(context.Background(), tt.mapping)
+			if err != nil {
+				t.Fatalf("unexpected error from MappingCreate %s", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected result %d but received %d", tt.expected, result)
+			}
+		})
+	}
+
+	mapping, err := db.MappingRetrieve(context.Background(), "BillSys1", "123")
+	if err != nil {
+		t.Fatalf("unexpected error from MappingRetrieve %s", err)
+	}
+	if mapping.FTPAccount.ID != id || mapping.UpdatedOn == nil {
+		t.Fatalf("unexpected mapping returned %+v", mapping)
+	}
+
+	user, err := db.FtpUserLookup(context.Background(), "Test")
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserLookup %s", err)
+	}
+	if user.Password != "secret" {
+		t.Errorf("expected the stored password but received %s", user.Password)
+	}
+
+	stale := mapping.UpdatedOn.Add(-time.Second)
+	_, err = db.MappingDelete(context.Background(), "BillSys1", "123", &stale)
+	if !errors.Is(err, ErrMappingModified) {
+		t.Errorf("expected error %s but received %v", ErrMappingModified, err)
+	}
+	rows, err := db.MappingDelete(context.Background(), "BillSys1", "123", mapping.UpdatedOn)
+	if err != nil || rows != 1 {
+		t.Errorf("expected one mapping deleted but received %d %v", rows, err)
+	}
+
+	// purging a soft deleted account removes its mappings through the cascade
+	_, err = db.MappingCreate(context.Background(), NewMapping{System: "BillSys1", SystemID: "123", FTPAccountID: id})
+	if err != nil {
+		t.Fatalf("unexpected error from MappingCreate %s", err)
+	}
+	err = db.FtpUserDelete(context.Background(), id, nil)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserDelete %s", err)
+	}
+	purged, err := db.FtpUserPurge(context.Background(), time.Now().Add(time.Minute))
+	if err != nil || purged != 1 {
+		t.Fatalf("expected one account purged but received %d %v", purged, err)
+	}
+
+	var count int
+	err = db.QueryRowForDriver(context.Background(), "select count(*) from `ftp_mapping`").Scan(&count)
+	if err != nil || count != 0 {
+		t.Errorf("expected the mapping to be removed with the account but found %d %v", count, err)
+	}
+}
+
+func TestSQLiteHistory(t *testing.T) {
+	db := newSQLiteDB(t)
+
+	err := db.LoginAttemptCreate(context.Background(), LoginAttempt{Username: "Test", IP: "10.0.0.1", Protocol: "SSH", Status: "success"})
+	if err != nil {
+		t.Fatalf("unexpected error from LoginAttemptCreate %s", err)
+	}
+
+	attempts, err := db.LoginAttemptGetSelection(context.Background(), 1, 10, LoginAttemptFilter{Since: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("unexpected error from LoginAttemptGetSelection %s", err)
+	}
+	if attempts.TotalItems != 1 || len(attempts.Logins) != 1 || attempts.Logins[0].AttemptedAt.IsZero() {
+		t.Errorf("unexpected login history returned %+v", attempts)
+	}
+
+	err = db.AuditCreate(context.Background(), AuditEntry{Actor: "admin", Action: AuditActionCreate, Entity: "ftp_account", EntityID: "1", After: []byte(`{"id":1}`)})
+	if err != nil {
+		t.Fatalf("unexpected error from AuditCreate %s", err)
+	}
+
+	entries, err := db.AuditGetSelection(context.Background(), 1, 10, AuditFilter{Actor: "admin", Until: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("unexpected error from AuditGetSelection %s", err)
+	}
+	if entries.TotalItems != 1 || len(entries.Entries) != 1 || string(entries.Entries[0].After) != `{"id":1}` {
+		t.Errorf("unexpected audit log returned %+v", entries)
+	}
+
+	purged, err := db.LoginAttemptPurge(context.Background(), time.Now().Add(time.Minute))
+	if err != nil || purged != 1 {
+		t.Errorf("expected one login attempt purged but received %d %v", purged, err)
+	}
+}
+
+func TestSQLiteQueryTimeout(t *testing.T) {
+	db := newSQLiteDB(t)
+	db.Options.QueryTimeout = time.Nanosecond
+
+	_, err := db.FtpUserGetSelection(context.Background(), 0, 0, "", FtpUserFilter{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v but received %v", context.DeadlineExceeded, err)
+	}
+
+	// the timeout of one database does not limit another
+	other := newSQLiteDB(t)
+	if _, err := other.FtpUserGetSelection(context.Background(), 0, 0, "", FtpUserFilter{}); err != nil {
+		t.Errorf("unexpected error from FtpUserGetSelection %s", err)
+	}
+}
@@ -0,0 +1,223 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// System - type used to represent a system that accounts are mapped in
+// - Mappings and FTPAccounts count the mappings of active accounts and the accounts they map to
+// - Registered is set for systems added with SystemRegister, which may have no mappings
+type System struct {
+	Name         string     `json:"system"`
+	Description  string     `json:"description,omitempty"`
+	Registered   bool       `json:"registered"`
+	RegisteredAt *time.Time `json:"registered_at,omitempty"`
+	Mappings     uint32     `json:"mappings"`
+	FTPAccounts  uint32     `json:"ftp_accounts"`
+}
+
+// Systems - type used to return a collection of System structs
+type Systems struct {
+	Systems []System `json:"systems"`
+}
+
+// SystemGetSelection - retrieve every system that is registered or has mappings of active accounts, ordered by name
+// - read from a replica when there is one
+func (db *Database) SystemGetSelection(ctx context.Context) (Systems, error) {
+	var systems Systems
+	err := db.read(ctx, func(r *Database) (err error) {
+		systems, err = r.systemGetSelection(ctx)
+		return err
+	})
+	return systems, err
+}
+
+func (db *Database) systemGetSelection(ctx context.Context) (systems Systems, err error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if err = db.checkDBConnection(ctx); err != nil {
+		return
+	}
+
+	found := make(map[string]*System)
+	get := func(name string) *System {
+		if found[name] == nil {
+			found[name] = &System{Name: name}
+		}
+		return found[name]
+	}
+
+	qry := "select m.`system`, count(*), count(distinct m.`ftp_id`) "
+	qry += "from `ftp_mapping` m "
+	qry += "inner join `ftp_account` a on m.`ftp_id` = a.`id` "
+	qry += "where a.`deleted_at` is null group by m.`system`"
+
+	err = db.queryEach(ctx, qry, func(rows *sql.Rows) error {
+		var (
+			name                  string
+			mappings, ftpAccounts uint32
+		)
+		if err := rows.Scan(&name, &mappings, &ftpAccounts); err != nil {
+			return err
+		}
+		system := get(name)
+		system.Mappings, system.FTPAccounts = mappings, ftpAccounts
+		return nil
+	})
+	if err != nil {
+		return systems, err
+	}
+
+	qry = "select `system`, `description`, `created_at` from `ftp_system`"
+
+	err = db.queryEach(ctx, qry, func(rows *sql.Rows) error {
+		var (
+			name, description string
+			createdAt         time.Time
+		)
+		if err := rows.Scan(&name, &description, &createdAt); err != nil {
+			return err
+		}
+		system := get(name)
+		system.Description, system.Registered, system.RegisteredAt = description, true, &createdAt
+		return nil
+	})
+	if err != nil {
+		return systems, err
+	}
+
+	systems.Systems = make([]System, 0, len(found))
+	for _, system := range found {
+		systems.Systems = append(systems.Systems, *system)
+	}
+	sort.Slice(systems.Systems, func(i, j int) bool { return systems.Systems[i].Name < systems.Systems[j].Name })
+
+	return systems, nil
+}
+
+// SystemRegistrationGet - retrieve the registration of system, ErrSystemNotFound when it is not registered
+// - Mappings and FTPAccounts are not counted
+// - read from the primary, as it is used to snapshot a registration before it is written
+func (db *Database) SystemRegistrationGet(ctx context.Context, system string) (System, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
+		return System{}, dbErr
+	}
+
+	var createdAt time.Time
+	registered := System{Name: system, Registered: true, RegisteredAt: &createdAt}
+
+	qry := "select `description`, `created_at` from `ftp_system` where `system` = ?"
+	err := db.QueryRowForDriver(ctx, qry, system).Scan(&registered.Description, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return System{}, ErrSystemNotFound
+	}
+	if err != nil {
+		log.Error(err.Error())
+		return System{}, contextErr(ctx, err)
+	}
+
+	return registered, nil
+}
+
+// queryEach - call fn with each row returned by query
+func (db *Database) queryEach(ctx context.Context, query string, fn func(rows *sql.Rows) error, args ...interface{}) error {
+	rows, err := db.QueryForDriver(ctx, query, args...)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = fn(rows); err != nil {
+			log.Error(err.Error())
+			return err
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Error(err.Error())
+	}
+
+	return err
+}
+
+// SystemRegister - register system so it can be mapped when Options.RequireRegisteredSystems is set,
+// updating the description of a system already registered
+func (db *Database) SystemRegister(ctx context.Context, system string, description string) error {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
+		return dbErr
+	}
+
+	d := db.dialect()
+	qry := "insert into `ftp_system` (`system`, `description`) values (?, ?)"
+	qry += d.Upsert([]string{"system"}, "`description` = "+d.Excluded("description"))
+
+	_, err := db.ExecForDriver(ctx, qry, system, description)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// SystemUnregister - remove the registration of system, its mappings are kept
+func (db *Database) SystemUnregister(ctx context.Context, system string) error {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
+		return dbErr
+	}
+
+	result, err := db.ExecForDriver(ctx, "delete from `ftp_system` where `system` = ?", system)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	if rows == 0 {
+		return ErrSystemNotFound
+	}
+
+	return nil
+}
+
+// checkSystemRegistered - ErrSystemNotRegistered when Options.RequireRegisteredSystems is set and system is not registered
+func (db *Database) checkSystemRegistered(ctx context.Context, system string) error {
+	if !db.Options.RequireRegisteredSystems {
+		return nil
+	}
+
+	var count int
+	err := db.QueryRowForDriver(ctx, "select count(*) from `ftp_system` where `system` = ?", system).Scan(&count)
+	if err != nil {
+		log.Error(err.Error())
+		return contextErr(ctx, err)
+	}
+	if count == 0 {
+		return ErrSystemNotRegistered
+	}
+
+	return nil
+}
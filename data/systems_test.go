@@ -0,0 +1,121 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSystemRegister(t *testing.T) {
+	tests := []struct {
+		name     string
+		dialect  Dialect
+		expQuery string
+	}{
+		{
+			name:     "MySQL",
+			dialect:  MySQL,
+			expQuery: "insert into `ftp_system` \\(`system`, `description`\\) values \\(\\?, \\?\\) on duplicate key update `description` = values\\(`description`\\)",
+		},
+		{
+			name:     "PostgreSQL",
+			dialect:  PostgreSQL,
+			expQuery: "insert into \"ftp_system\" \\(\"system\", \"description\"\\) values \\(\\$1, \\$2\\) on conflict \\(\"system\"\\) do update set \"description\" = excluded.\"description\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf(errDBConnectionError, err)
+			}
+			defer db.Close()
+
+			dBase := &Database{DB: db, Dialect: tt.dialect}
+
+			mock.ExpectExec(tt.expQuery).WithArgs("BillSys1", "Billing").WillReturnResult(sqlmock.NewResult(0, 1))
+
+			if err := dBase.SystemRegister(context.Background(), "BillSys1", "Billing"); err != nil {
+				t.Errorf("unexpected error from SystemRegister %s", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestSystemRegistrationGet(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf(errDBConnectionError, err)
+	}
+	defer db.Close()
+
+	dBase := &Database{DB: db}
+
+	getQuery := "select `description`, `created_at` from `ftp_system` where `system` = \\?"
+	mock.ExpectQuery(getQuery).WithArgs("BillSys1").WillReturnRows(sqlmock.NewRows([]string{"description", "created_at"}).AddRow("Billing", time.Now()))
+	mock.ExpectQuery(getQuery).WithArgs("BillSys2").WillReturnRows(sqlmock.NewRows([]string{"description", "created_at"}))
+
+	system, err := dBase.SystemRegistrationGet(context.Background(), "BillSys1")
+	if err != nil {
+		t.Errorf("unexpected error from SystemRegistrationGet %s", err)
+	}
+	if system.Name != "BillSys1" || system.Description != "Billing" || !system.Registered || system.RegisteredAt == nil {
+		t.Errorf("unexpected system returned %+v", system)
+	}
+	if _, err := dBase.SystemRegistrationGet(context.Background(), "BillSys2"); err != ErrSystemNotFound {
+		t.Errorf("expected %v but received %v", ErrSystemNotFound, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSystemUnregister(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf(errDBConnectionError, err)
+	}
+	defer db.Close()
+
+	dBase := &Database{DB: db}
+
+	delQuery := "delete from `ftp_system` where `system` = \\?"
+	mock.ExpectExec(delQuery).WithArgs("BillSys1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(delQuery).WithArgs("BillSys1").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := dBase.SystemUnregister(context.Background(), "BillSys1"); err != nil {
+		t.Errorf("unexpected error from SystemUnregister %s", err)
+	}
+	if err := dBase.SystemUnregister(context.Background(), "BillSys1"); err != ErrSystemNotFound {
+		t.Errorf("expected %v but received %v", ErrSystemNotFound, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMappingCreateUnregisteredSystem(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf(errDBConnectionError, err)
+	}
+	defer db.Close()
+
+	dBase := &Database{DB: db, Options: Options{RequireRegisteredSystems: true}}
+
+	cntQuery := "select count\\(\\*\\) from `ftp_system` where `system` = \\?"
+	mock.ExpectQuery(cntQuery).WithArgs("BillSys2").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	result, err := dBase.MappingCreate(context.Background(), NewMapping{System: "BillSys2", SystemID: "1", FTPAccountID: 1})
+	if err != ErrSystemNotRegistered || result != MappingError {
+		t.Errorf("expected %v but received %d %v", ErrSystemNotRegistered, result, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,107 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// ErrNestedTransaction - returned by Begin on a Tx, transactions cannot be nested
+var ErrNestedTransaction = errors.New("A transaction cannot be started within a transaction")
+
+// Tx - a Datastore whose changes are kept together by Commit or discarded together by Rollback
+// - once committed Rollback returns sql.ErrTxDone, so it can always be deferred
+//
+//	tx, err := db.Begin(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	defer tx.Rollback()
+//
+//	user, err := tx.FtpUserCreate(ctx, user)
+//	...
+//	return tx.Commit()
+type Tx interface {
+	Datastore
+	Commit() error
+	Rollback() error
+}
+
+// queryer - the query methods shared by sql.DB and sql.Tx
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// dbTx - a Database running every query in one transaction
+type dbTx struct {
+	*Database
+}
+
+// compile time check that dbTx satisfies the Tx interface
+var _ Tx = dbTx{}
+
+// Begin - start a transaction that lasts until it is committed, rolled back or ctx ends
+// - ctx should be the context of the request, not one limited to a single query
+func (db *Database) Begin(ctx context.Context) (Tx, error) {
+	if db.tx != nil {
+		return nil, ErrNestedTransaction
+	}
+
+	if dbErr := db.checkDBConnection(ctx); dbErr != nil {
+		return nil, dbErr
+	}
+
+	markWrite(ctx)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, contextErr(ctx, err)
+	}
+
+	return dbTx{db.withTx(tx)}, nil
+}
+
+// Commit - keep the changes made in the transaction
+func (tx dbTx) Commit() error {
+	return tx.tx.Commit()
+}
+
+// Rollback - discard the changes made in the transaction
+func (tx dbTx) Rollback() error {
+	return tx.tx.Rollback()
+}
+
+// withTx - a copy of db running every query in tx
+func (db *Database) withTx(tx *sql.Tx) *Database {
+	c := *db
+	c.tx = tx
+	return &c
+}
+
+// inTx - run fn in the current transaction or, outside of one, in a transaction of its own
+// - a transaction of its own is committed when fn succeeds and rolled back when it fails
+func (db *Database) inTx(ctx context.Context, fn func(tx *Database) error) error {
+	if db.tx != nil {
+		return fn(db)
+	}
+
+	markWrite(ctx)
+
+	sqlTx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer sqlTx.Rollback()
+
+	err = fn(db.withTx(sqlTx))
+	if err != nil {
+		return err
+	}
+
+	return sqlTx.Commit()
+}
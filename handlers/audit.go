@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/halt-joe/ftp-user-svc/apierror"
+	"github.com/halt-joe/ftp-user-svc/auth"
+	"github.com/halt-joe/ftp-user-svc/data"
+	log "github.com/inconshreveable/log15"
+)
+
+// redactedPassword - the value recorded in audit snapshots in place of a password
+const redactedPassword = "[redacted]"
+
+// redactFtpUser - replace a supplied password so it is never written to the audit log
+func redactFtpUser(user data.FtpUser) data.FtpUser {
+	if user.Password != "" {
+		user.Password = redactedPassword
+	}
+	return user
+}
+
+// mappingEntityID - the audit entity id of the mapping for system and id
+func mappingEntityID(system string, id string) string {
+	return system + "/" + id
+}
+
+// audit - append a change to the audit log in tx, before and after are nil when the entity did not exist
+// - tx is the transaction making the change, so the change is only committed along with its entry
+func audit(tx data.Datastore, r *http.Request, requestID string, action string, entity string, entityID string, before interface{}, after interface{}) error {
+	entry := data.AuditEntry{
+		Actor:     auth.Actor(r),
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		RequestID: requestID,
+	}
+
+	var err error
+	if before != nil {
+		if entry.Before, err = json.Marshal(before); err != nil {
+			return err
+		}
+	}
+	if after != nil {
+		if entry.After, err = json.Marshal(after); err != nil {
+			return err
+		}
+	}
+
+	err = tx.AuditCreate(r.Context(), entry)
+	if err != nil {
+		log.Error(requestID+" unable to record audit entry", "action", action, "entity", entity, "id", entityID, "error", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// AuditGet - retrieves the audit log within a specified page index and page size, most recent first
+// - accounts permanently removed by the deleted account purge are not audited, their delete entry is the last
+//
+//	Responses:
+//	  - 200 Success
+//	  - 400 Bad Request
+//	  - 401 Unauthorized (Failed Authentication)
+//	  - 500 Error
+//
+//	Query Parameters:
+//	- page, page_size
+//	    the page index and page size
+//	- actor
+//	    the name of the api key that made the change
+//	- action
+//	    create, update, update_password, delete or restore
+//	- entity, entity_id
+//	    ftp_account, ftp_mapping or ftp_system and the id of the account, the system/id of the mapping or the name of the system
+//	- since, until
+//	    RFC 3339 times bounding when the change occurred
+//
+//	Response Body:
+//	  {
+//	    "entries": [
+//	      {"id":7,"occurred_at":"2022-05-04T10:15:00Z","actor":"admin-ui","action":"update","entity":"ftp_account","entity_id":"11",
+//	       "before":{"id":11,"username":"testuser","description":"old"},"after":{"id":11,"username":"testuser","description":"new"},"request_id":"..."},
+//	      ...
+//	    ],
+//	    "total_items": 245,
+//	    "total_pages": 9
+//	  }
+func (env *Env) AuditGet(w http.ResponseWriter, r *http.Request) {
+	// setup error response
+	er := apierror.NewErrorResponse(w, r)
+
+	// Authenticate
+	if !auth.Authenticate(r) {
+		er.Status = http.StatusUnauthorized
+		er.Message = auth.ErrUnauthorized
+		er.WriteResponse()
+		return
+	}
+
+	filter := data.AuditFilter{
+		Actor:    r.FormValue("actor"),
+		Action:   r.FormValue("action"),
+		Entity:   r.FormValue("entity"),
+		EntityID: r.FormValue("entity_id"),
+	}
+
+	page, err := parseUint32Param(r, "page")
+	if err != nil {
+		er.Status = http.StatusBadRequest
+		er.Message = fmt.Sprintf(ErrInvalidQueryParam, r.FormValue("page"), "page")
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	pageSize, err := parseUint32Param(r, "page_size")
+	if err != nil {
+		er.Status = http.StatusBadRequest
+		er.Message = fmt.Sprintf(ErrInvalidQueryParam, r.FormValue("page_size"), "page_size")
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	if filter.Since, err = parseTimeParam(r, "since"); err != nil {
+		er.Status = http.StatusBadRequest
+		er.Message = fmt.Sprintf(ErrInvalidQueryParam, r.FormValue("since"), "since")
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	if filter.Until, err = parseTimeParam(r, "until"); err != nil {
+		er.Status = http.StatusBadRequest
+		er.Message = fmt.Sprintf(ErrInvalidQueryParam, r.FormValue("until"), "until")
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	entries, err := env.Data.AuditGetSelection(r.Context(), page, pageSize, filter)
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	output, err := json.Marshal(entries)
+	if err != nil {
+		er.Status = http.StatusInternalServerError
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(output)
+}
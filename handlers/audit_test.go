@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/halt-joe/ftp-user-svc/auth"
+	"github.com/halt-joe/ftp-user-svc/data"
+	"github.com/halt-joe/ftp-user-svc/data/memory"
+)
+
+func (mdb *mockDB) AuditCreate(ctx context.Context, entry data.AuditEntry) error {
+	mdb.audits = append(mdb.audits, entry)
+	return nil
+}
+func (mdb *mockDB) AuditGetSelection(ctx context.Context, page uint32, pageSize uint32, filter data.AuditFilter) (data.AuditEntries, error) {
+	var result data.AuditEntries
+
+	for _, entry := range mdb.audits {
+		if filter.Actor != "" && filter.Actor != entry.Actor {
+			continue
+		}
+		if filter.Entity != "" && filter.Entity != entry.Entity {
+			continue
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+	result.TotalItems = uint32(len(result.Entries))
+	if result.TotalItems > 0 {
+		result.TotalPages = 1
+	}
+
+	return result, nil
+}
+
+func TestAuditOnPost(t *testing.T) {
+	auth.APIKeys = map[string]string{"admin-key": "admin-ui"}
+	defer func() { auth.APIKeys = map[string]string{} }()
+
+	mdb := &mockDB{}
+	env := Env{Data: mdb}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "https://ftpsvc.dev.run/ftpusers", strings.NewReader("{\"username\":\"Test\",\"description\":\"A test user\",\"password\":\"secret\"}"))
+	r.Header.Set("X-API-Key", "admin-key")
+
+	env.Post(w, r)
+	resp := w.Result()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d but received %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	if len(mdb.audits) != 1 {
+		t.Fatalf("Expected 1 audit entry but received %d", len(mdb.audits))
+	}
+
+	entry := mdb.audits[0]
+	if entry.Actor != "admin-ui" {
+		t.Errorf("Expected actor admin-ui but received %s", entry.Actor)
+	}
+	if entry.Action != data.AuditActionCreate || entry.Entity != data.AuditEntityFTPAccount || entry.EntityID != "1" {
+		t.Errorf("Unexpected audit entry %s %s %s", entry.Action, entry.Entity, entry.EntityID)
+	}
+	if entry.Before != nil {
+		t.Errorf("Expected no before snapshot but received %s", entry.Before)
+	}
+	if strings.Contains(string(entry.After), "secret") {
+		t.Errorf("Password was not redacted from the after snapshot %s", entry.After)
+	}
+	if !strings.Contains(string(entry.After), redactedPassword) {
+		t.Errorf("Expected a redacted password in the after snapshot %s", entry.After)
+	}
+}
+
+func TestAuditGet(t *testing.T) {
+	mdb := &mockDB{}
+	mdb.audits = []data.AuditEntry{
+		{ID: 1, Actor: "admin-ui", Action: data.AuditActionCreate, Entity: data.AuditEntityFTPAccount, EntityID: "1"},
+		{ID: 2, Actor: "billing", Action: data.AuditActionDelete, Entity: data.AuditEntityMapping, EntityID: "BillSys1/123"},
+	}
+	env := Env{Data: mdb}
+
+	type args struct {
+		w              *httptest.ResponseRecorder
+		r              *http.Request
+		expectedStatus int
+		expectedCount  int
+	}
+	tests := []struct {
+		name string
+		args func(t *testing.T) args
+	}{
+		{
+			name: "Test audit GET all",
+			args: func(t *testing.T) args {
+				return args{
+					w:              httptest.NewRecorder(),
+					r:              httptest.NewRequest("GET", "https://ftpsvc.dev.run/audit", nil),
+					expectedStatus: http.StatusOK,
+					expectedCount:  2,
+				}
+			},
+		},
+		{
+			name: "Test audit GET by actor",
+			args: func(t *testing.T) args {
+				return args{
+					w:              httptest.NewRecorder(),
+					r:              httptest.NewRequest("GET", "https://ftpsvc.dev.run/audit?actor=billing", nil),
+					expectedStatus: http.StatusOK,
+					expectedCount:  1,
+				}
+			},
+		},
+		{
+			name: "Test audit GET invalid until",
+			args: func(t *testing.T) args {
+				return args{
+					w:              httptest.NewRecorder(),
+					r:              httptest.NewRequest("GET", "https://ftpsvc.dev.run/audit?until=tomorrow", nil),
+					expectedStatus: http.StatusBadRequest,
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tArgs := tt.args(t)
+
+			env.AuditGet(tArgs.w, tArgs.r)
+			resp := tArgs.w.Result()
+			if resp.StatusCode != tArgs.expectedStatus {
+				t.Errorf("Expected status %d but received %d", tArgs.expectedStatus, resp.StatusCode)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+
+			respBody, _ := io.ReadAll(resp.Body)
+			var entries data.AuditEntries
+			if err := json.Unmarshal(respBody, &entries); err != nil {
+				t.Fatalf("unexpected error \"%s\" while unmarshaling resultant data", err.Error())
+			}
+			if len(entries.Entries) != tArgs.expectedCount {
+				t.Errorf("Expected %d entries but received %d", tArgs.expectedCount, len(entries.Entries))
+			}
+		})
+	}
+}
+
+var errAuditUnavailable = errors.New("audit log unavailable")
+
+// failingAuditTx - a transaction whose audit entries cannot be written
+type failingAuditTx struct {
+	data.Tx
+}
+
+func (tx failingAuditTx) AuditCreate(ctx context.Context, entry data.AuditEntry) error {
+	return errAuditUnavailable
+}
+
+// failingAuditStore - a Datastore whose transactions cannot write audit entries
+type failingAuditStore struct {
+	*memory.Store
+}
+
+func (s failingAuditStore) Begin(ctx context.Context) (data.Tx, error) {
+	tx, err := s.Store.Begin(ctx)
+	return failingAuditTx{tx}, err
+}
+
+func TestAuditFailureRollsBack(t *testing.T) {
+	store := memory.New()
+	env := Env{Data: failingAuditStore{store}}
+
+	user, _ := store.FtpUserCreate(context.Background(), data.FtpUser{Username: "Test", Description: "A test user", Password: "secret"})
+	id := map[string]string{"id": strconv.FormatUint(uint64(user.ID), 10)}
+
+	tests := []struct {
+		name    string
+		method  string
+		body    string
+		vars    map[string]string
+		handler func(w http.ResponseWriter, r *http.Request)
+	}{
+		{name: "Post", method: "POST", body: "{\"username\":\"Created\",\"description\":\"A new user\",\"password\":\"secret\",\"mappings\":[{\"system\":\"BillSys1\",\"id\":\"1\"}]}", handler: env.Post},
+		{name: "Put", method: "PUT", body: "{\"username\":\"Renamed\",\"description\":\"A renamed user\"}", vars: id, handler: env.IDPut},
+		{name: "Patch", method: "PATCH", body: "{\"password\":\"changed\"}", vars: id, handler: env.IDPatch},
+		{name: "Delete", method: "DELETE", vars: id, handler: env.IDDelete},
+		{name: "Mapping Post", method: "POST", body: fmt.Sprintf("{\"id\":\"2\",\"ftp_id\":%d}", user.ID), vars: map[string]string{"system": "BillSys1"}, handler: env.SystemPost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, mux.SetURLVars(httptest.NewRequest(tt.method, "https://ftpsvc.dev.run/", strings.NewReader(tt.body)), tt.vars))
+			if status := w.Result().StatusCode; status != http.StatusInternalServerError {
+				t.Errorf("Expected status %d but received %d", http.StatusInternalServerError, status)
+			}
+		})
+	}
+
+	users, _ := store.FtpUserGetSelection(context.Background(), 0, 0, "", data.FtpUserFilter{})
+	if len(users.Ftpusers) != 1 || users.Ftpusers[0].Username != "Test" || users.Ftpusers[0].UpdatedOn == nil || !users.Ftpusers[0].UpdatedOn.Equal(*user.UpdatedOn) {
+		t.Errorf("Expected every change to be rolled back but found %+v", users.Ftpusers)
+	}
+	if ids, _ := store.SystemIDUserRetrieve(context.Background(), "BillSys1"); len(ids) != 0 {
+		t.Errorf("Expected the mappings to be rolled back but found %v", ids)
+	}
+}
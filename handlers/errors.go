@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/halt-joe/ftp-user-svc/apierror"
+	"github.com/halt-joe/ftp-user-svc/data"
+)
+
+// Messages for data operations ended by their context
+const (
+	ErrTimeout   = "The database did not respond in time"
+	ErrCancelled = "The request was cancelled before the database responded"
+)
+
+// dataErrorStatus - the response status for an error returned by the Datastore
+//
+//	data.KindNotFound       - 404 Not Found
+//	data.KindConflict       - 409 Conflict
+//	data.KindModified       - 412 Precondition Failed
+//	data.KindInvalid        - 400 Bad Request
+//	context.DeadlineExceeded - 504 Gateway Timeout
+//	context.Canceled        - 503 Service Unavailable
+//	anything else           - 500 Internal Server Error
+func dataErrorStatus(err error) int {
+	var dataErr *data.Error
+	if errors.As(err, &dataErr) {
+		switch dataErr.Kind {
+		case data.KindNotFound:
+			return http.StatusNotFound
+		case data.KindConflict:
+			return http.StatusConflict
+		case data.KindModified:
+			return http.StatusPreconditionFailed
+		case data.KindInvalid:
+			return http.StatusBadRequest
+		}
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
+
+// setDataError - fill in the error response for an error returned by the Datastore
+// - the caller writes the response so the error is logged against the handler
+func setDataError(er *apierror.ErrorResponse, err error) {
+	er.Status = dataErrorStatus(err)
+	er.Err = err
+
+	switch er.Status {
+	case http.StatusNotFound:
+		// a missing resource is expected so only the message is returned
+		er.Message = err.Error()
+		er.Err = nil
+	case http.StatusConflict, http.StatusBadRequest:
+		er.Message = err.Error()
+	case http.StatusPreconditionFailed:
+		er.Message = ErrPreconditionFailed
+	case http.StatusGatewayTimeout:
+		er.Message = ErrTimeout
+	case http.StatusServiceUnavailable:
+		er.Message = ErrCancelled
+	}
+}
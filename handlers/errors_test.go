@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/halt-joe/ftp-user-svc/data"
+)
+
+func TestDataErrorStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{name: "User not found", err: data.ErrUserNotFound, expected: http.StatusNotFound},
+		{name: "Deleted account not found", err: data.ErrDeletedFTPAccount, expected: http.StatusNotFound},
+		{name: "Account exists", err: data.ErrFTPAccountExists, expected: http.StatusConflict},
+		{name: "Mapping modified", err: data.ErrMappingModified, expected: http.StatusPreconditionFailed},
+		{name: "Invalid cursor", err: data.ErrInvalidCursor, expected: http.StatusBadRequest},
+		{name: "Wrapped data error", err: fmt.Errorf("update: %w", data.ErrFTPAccountModified), expected: http.StatusPreconditionFailed},
+		{name: "Deadline exceeded", err: fmt.Errorf("select: %w", context.DeadlineExceeded), expected: http.StatusGatewayTimeout},
+		{name: "Cancelled", err: context.Canceled, expected: http.StatusServiceUnavailable},
+		{name: "Matching message is not enough", err: errors.New(data.ErrUserNotFound.Error()), expected: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := dataErrorStatus(tt.err); status != tt.expected {
+				t.Errorf("expected status %d for %v but received %d", tt.expected, tt.err, status)
+			}
+		})
+	}
+}
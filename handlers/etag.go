@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrPreconditionFailed - returned when the If-Match header does not list the current version
+const ErrPreconditionFailed = "The resource has been modified since it was retrieved"
+
+// etag - the weak entity tag for a resource version, empty when the version is unknown
+// - the tag is weak as it only versions the fields a client changes, e.g. recording a login changes the
+// last_login_at, last_login_ip and login_count of an account without changing its tag
+func etag(version *time.Time) string {
+	if version == nil {
+		return ""
+	}
+
+	return "W/\"" + strconv.FormatInt(version.UnixNano(), 36) + "\""
+}
+
+// setETag - add the ETag header for the resource version to the response
+func setETag(w http.ResponseWriter, version *time.Time) {
+	if tag := etag(version); tag != "" {
+		w.Header().Set("ETag", tag)
+	}
+}
+
+// matchETag - report whether the If-Match or If-None-Match header value lists tag
+// - "*" matches any existing resource and weak validators are compared on their opaque value
+func matchETag(header string, tag string) bool {
+	if tag == "" {
+		return false
+	}
+
+	tag = strings.TrimPrefix(tag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified - report whether the If-None-Match header lists the current version
+func notModified(r *http.Request, version *time.Time) bool {
+	header := r.Header.Get("If-None-Match")
+	return header != "" && matchETag(header, etag(version))
+}
+
+// checkIfMatch - apply the If-Match header to the current version of a resource
+// - ok is false when the header does not list the current version
+// - the returned version limits the write to the version the client read, nil when the write is unconditional
+func checkIfMatch(r *http.Request, current *time.Time) (version *time.Time, ok bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return nil, true
+	}
+
+	if !matchETag(header, etag(current)) {
+		return nil, false
+	}
+
+	return current, true
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/halt-joe/ftp-user-svc/data"
+	"github.com/halt-joe/ftp-user-svc/data/memory"
+)
+
+func TestMatchETag(t *testing.T) {
+	tag := etag(&mockUpdatedOn)
+
+	tests := []struct {
+		name     string
+		header   string
+		tag      string
+		expected bool
+	}{
+		{name: "Exact match", header: tag, tag: tag, expected: true},
+		{name: "Listed match", header: "\"abc\", " + tag, tag: tag, expected: true},
+		{name: "Strong match", header: strings.TrimPrefix(tag, "W/"), tag: tag, expected: true},
+		{name: "Any", header: "*", tag: tag, expected: true},
+		{name: "Stale", header: "\"abc\"", tag: tag, expected: false},
+		{name: "Unknown version", header: "*", tag: "", expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := matchETag(tt.header, tt.tag); result != tt.expected {
+				t.Errorf("Expected %t for %s against %s but received %t", tt.expected, tt.header, tt.tag, result)
+			}
+		})
+	}
+}
+
+func TestIDGetETag(t *testing.T) {
+	env := Env{Data: &mockDB{}}
+	tag := etag(&mockUpdatedOn)
+
+	w := httptest.NewRecorder()
+	r := mux.SetURLVars(httptest.NewRequest("GET", "https://ftpsvc.dev.run/ftpusers/987", nil), map[string]string{"id": "987"})
+
+	env.IDGet(w, r)
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d but received %d", http.StatusOK, resp.StatusCode)
+	}
+	if resp.Header.Get("ETag") != tag {
+		t.Errorf("Expected ETag %s but received %s", tag, resp.Header.Get("ETag"))
+	}
+
+	w = httptest.NewRecorder()
+	r = mux.SetURLVars(httptest.NewRequest("GET", "https://ftpsvc.dev.run/ftpusers/987", nil), map[string]string{"id": "987"})
+	r.Header.Set("If-None-Match", tag)
+
+	env.IDGet(w, r)
+	resp = w.Result()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected status %d but received %d", http.StatusNotModified, resp.StatusCode)
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		name           string
+		ifMatch        string
+		handler        func(env *Env, w http.ResponseWriter, r *http.Request)
+		method         string
+		url            string
+		vars           map[string]string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "Delete current version",
+			ifMatch:        etag(&mockUpdatedOn),
+			handler:        (*Env).IDDelete,
+			method:         "DELETE",
+			url:            "https://ftpsvc.dev.run/ftpusers/987",
+			vars:           map[string]string{"id": "987"},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "Delete stale version",
+			ifMatch:        "\"stale\"",
+			handler:        (*Env).IDDelete,
+			method:         "DELETE",
+			url:            "https://ftpsvc.dev.run/ftpusers/987",
+			vars:           map[string]string{"id": "987"},
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "Put stale version",
+			ifMatch:        "\"stale\"",
+			handler:        (*Env).IDPut,
+			method:         "PUT",
+			url:            "https://ftpsvc.dev.run/ftpusers/987",
+			vars:           map[string]string{"id": "987"},
+			body:           "{\"username\":\"Test\",\"description\":\"A changed user\"}",
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "Mapping post without an existing mapping",
+			ifMatch:        "*",
+			handler:        (*Env).SystemPost,
+			method:         "POST",
+			url:            "https://ftpsvc.dev.run/mappings/BillSys1",
+			vars:           map[string]string{"system": "BillSys1"},
+			body:           "{\"id\": \"123\", \"ftp_id\": 987}",
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+	}
+
+	env := Env{Data: &mockDB{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := mux.SetURLVars(httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body)), tt.vars)
+			r.Header.Set("If-Match", tt.ifMatch)
+
+			tt.handler(&env, w, r)
+			resp := w.Result()
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d but received %d", tt.expectedStatus, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestWritesReturnETag(t *testing.T) {
+	store := memory.New()
+	env := Env{Data: store}
+
+	user, _ := store.FtpUserCreate(context.Background(), data.FtpUser{Username: "Test", Description: "A test user", Password: "secret"})
+	vars := map[string]string{"id": strconv.FormatUint(uint64(user.ID), 10)}
+	tag := etag(user.UpdatedOn)
+
+	// each write returns the version the next one is made against
+	for _, write := range []struct {
+		method  string
+		body    string
+		handler func(w http.ResponseWriter, r *http.Request)
+	}{
+		{method: "PUT", body: "{\"username\":\"Test\",\"description\":\"A changed user\"}", handler: env.IDPut},
+		{method: "PATCH", body: "{\"password\":\"changed\"}", handler: env.IDPatch},
+		{method: "PUT", body: "{\"username\":\"Test\",\"description\":\"A user changed again\"}", handler: env.IDPut},
+	} {
+		time.Sleep(time.Millisecond)
+
+		w := httptest.NewRecorder()
+		r := mux.SetURLVars(httptest.NewRequest(write.method, "https://ftpsvc.dev.run/ftpusers/1", strings.NewReader(write.body)), vars)
+		r.Header.Set("If-Match", tag)
+
+		write.handler(w, r)
+		resp := w.Result()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: Expected status %d but received %d", write.method, http.StatusOK, resp.StatusCode)
+		}
+		if next := resp.Header.Get("ETag"); next == "" || next == tag {
+			t.Fatalf("%s: Expected a new ETag but received %q", write.method, next)
+		} else {
+			tag = next
+		}
+	}
+
+	// recording a login leaves the weak tag unchanged
+	_ = store.FtpUserRecordLogin(context.Background(), user.ID, "10.0.0.1")
+
+	w := httptest.NewRecorder()
+	r := mux.SetURLVars(httptest.NewRequest("GET", "https://ftpsvc.dev.run/ftpusers/1", nil), vars)
+	r.Header.Set("If-None-Match", tag)
+
+	env.IDGet(w, r)
+	if status := w.Result().StatusCode; status != http.StatusNotModified {
+		t.Errorf("Expected status %d but received %d", http.StatusNotModified, status)
+	}
+	if !strings.HasPrefix(tag, "W/") {
+		t.Errorf("Expected a weak ETag but received %s", tag)
+	}
+}
@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/halt-joe/ftp-user-svc/apierror"
@@ -22,30 +24,75 @@ const (
 	ErrFTPUserIDConversion = "Cannot convert %s to an integer"
 	ErrFTPAccountExists    = "An FTP Account for %s already exists"
 	ErrFTPUserNotFound     = "User Not Found"
+	ErrInvalidQueryParam   = "Invalid value %s for query parameter %s"
+	ErrFTPUserMappingReq   = "System and ID are required for every mapping"
 )
 
+// accountStatuses - the accepted values of the status query parameter and whether each lists the soft deleted accounts
+var accountStatuses = map[string]bool{
+	"active":  false,
+	"deleted": true,
+}
+
+// newFtpUser - the body of POST /ftpusers, an account and the mappings created along with it
+type newFtpUser struct {
+	data.FtpUser
+	Mappings []data.NewMapping `json:"mappings,omitempty"`
+}
+
 // Get - retrieves all ftp user accounts within a specified page index and page size
 //
 //	Responses:
 //	  - 200 Success
+//	  - 400 Bad Request
 //	  - 401 Unauthorized (Failed Authentication)
 //	  - 500 Error
 //
+//	Query Parameters:
+//	- page, page_size, q
+//	    the page index, page size and search text of at most 100 characters
+//	- q_mode
+//	    how q is matched: contains (default), prefix, wildcard with * and ?, or words
+//	- last_login_before, last_login_after
+//	    RFC 3339 times bounding the last login, accounts never used are treated as before any time
+//	- max_login_count
+//	    the maximum number of logins recorded against the account
+//	- username, username_prefix
+//	    the exact username, or the start of it
+//	- description~
+//	    text contained in the description
+//	- updated_since
+//	    an RFC 3339 time, only accounts changed at or after it
+//	- has_mapping_in
+//	    comma separated systems, only accounts with a mapping in any of them
+//	- sort
+//	    comma separated id, username, last_login_at, login_count or updated_on, each prefixed with "-" for descending order
+//	- status, deleted
+//	    status=deleted or deleted=true to list the soft deleted accounts that can be restored instead of the active accounts
+//	- cursor
+//	    the next or prev token of another page with the same sort, read instead of page
+//	- count
+//	    false to leave out total_items and total_pages, which need every matching account counted
+//
 //	Response Body:
 //	  {
 //	    "ftpusers": [
-//	      {"id":11,"username":"testuser","description":"test description"},
+//	      {"id":11,"username":"testuser","description":"test description","last_login_at":"2022-05-04T10:15:00Z","last_login_ip":"10.0.0.7","login_count":42},
 //	      {"id":12,"username":"testuser2","description":"test description 2"},
 //	      ...
 //	    ],
 //	    "total_items": 245,
-//	    "total_pages": 13
+//	    "total_pages": 13,
+//	    "next": "eyJpIjoxMn0",
+//	    "prev": "eyJiIjp0cnVlLCJpIjoxMX0"
 //	  }
 func (env *Env) Get(w http.ResponseWriter, r *http.Request) {
 	var (
 		page     uint32
 		pageSize uint32
 		search   string
+		filter   data.FtpUserFilter
+		err      error
 	)
 
 	// setup error response
@@ -83,12 +130,119 @@ func (env *Env) Get(w http.ResponseWriter, r *http.Request) {
 	if value := r.FormValue("q"); value != "" {
 		search = value
 	}
+	filter.SearchMode = r.FormValue("q_mode")
+	if !data.ValidSearch(search, filter.SearchMode) {
+		param, value := "q", search
+		if !data.ValidSearch("", filter.SearchMode) {
+			param, value = "q_mode", filter.SearchMode
+		}
+		er.Status = http.StatusBadRequest
+		er.Message = fmt.Sprintf(ErrInvalidQueryParam, value, param)
+		er.WriteResponse()
+		return
+	}
+	if filter.LastLoginBefore, err = parseTimeParam(r, "last_login_before"); err != nil {
+		er.Status = http.StatusBadRequest
+		er.Message = fmt.Sprintf(ErrInvalidQueryParam, r.FormValue("last_login_before"), "last_login_before")
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+	if filter.LastLoginAfter, err = parseTimeParam(r, "last_login_after"); err != nil {
+		er.Status = http.StatusBadRequest
+		er.Message = fmt.Sprintf(ErrInvalidQueryParam, r.FormValue("last_login_after"), "last_login_after")
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+	if value := r.FormValue("max_login_count"); value != "" {
+		count, err := parseUint32Param(r, "max_login_count")
+		if err != nil {
+			er.Status = http.StatusBadRequest
+			er.Message = fmt.Sprintf(ErrInvalidQueryParam, value, "max_login_count")
+			er.Err = err
+			er.WriteResponse()
+			return
+		}
+		filter.MaxLoginCount = &count
+	}
+	filter.Username = r.FormValue("username")
+	filter.UsernamePrefix = r.FormValue("username_prefix")
+	filter.DescriptionContains = r.FormValue("description~")
+	if filter.UpdatedSince, err = parseTimeParam(r, "updated_since"); err != nil {
+		er.Status = http.StatusBadRequest
+		er.Message = fmt.Sprintf(ErrInvalidQueryParam, r.FormValue("updated_since"), "updated_since")
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+	if value := r.FormValue("has_mapping_in"); value != "" {
+		for _, system := range strings.Split(value, ",") {
+			if system == "" {
+				er.Status = http.StatusBadRequest
+				er.Message = fmt.Sprintf(ErrInvalidQueryParam, value, "has_mapping_in")
+				er.WriteResponse()
+				return
+			}
+			filter.MappingSystems = append(filter.MappingSystems, system)
+		}
+	}
+	if value := r.FormValue("sort"); value != "" {
+		if !data.ValidFtpUserSort(value) {
+			er.Status = http.StatusBadRequest
+			er.Message = fmt.Sprintf(ErrInvalidQueryParam, value, "sort")
+			er.WriteResponse()
+			return
+		}
+		filter.Sort = value
+	}
+	if value := r.FormValue("deleted"); value != "" {
+		deleted, err := strconv.ParseBool(value)
+		if err != nil {
+			er.Status = http.StatusBadRequest
+			er.Message = fmt.Sprintf(ErrInvalidQueryParam, value, "deleted")
+			er.Err = err
+			er.WriteResponse()
+			return
+		}
+		filter.Deleted = deleted
+	}
+	if value := r.FormValue("status"); value != "" {
+		deleted, ok := accountStatuses[value]
+		if !ok || (r.FormValue("deleted") != "" && deleted != filter.Deleted) {
+			er.Status = http.StatusBadRequest
+			er.Message = fmt.Sprintf(ErrInvalidQueryParam, value, "status")
+			er.WriteResponse()
+			return
+		}
+		filter.Deleted = deleted
+	}
+	if value := r.FormValue("cursor"); value != "" {
+		if _, err := data.ParseCursor(value, filter.Sort); err != nil {
+			er.Status = http.StatusBadRequest
+			er.Message = fmt.Sprintf(ErrInvalidQueryParam, value, "cursor")
+			er.Err = err
+			er.WriteResponse()
+			return
+		}
+		filter.Cursor = value
+	}
+	if value := r.FormValue("count"); value != "" {
+		count, err := strconv.ParseBool(value)
+		if err != nil {
+			er.Status = http.StatusBadRequest
+			er.Message = fmt.Sprintf(ErrInvalidQueryParam, value, "count")
+			er.Err = err
+			er.WriteResponse()
+			return
+		}
+		filter.SkipTotal = !count
+	}
 
-	users, err := env.Data.FtpUserGetSelection(page, pageSize, search)
+	users, err := env.Data.FtpUserGetSelection(r.Context(), page, pageSize, search, filter)
 
 	if err != nil {
-		er.Status = http.StatusInternalServerError
-		er.Err = err
+		setDataError(&er, err)
 		er.WriteResponse()
 		return
 	}
@@ -110,6 +264,7 @@ func (env *Env) Get(w http.ResponseWriter, r *http.Request) {
 //
 //	Responses:
 //	  - 200 Success
+//	  - 304 Not Modified (If-None-Match lists the current ETag)
 //	  - 400 Bad Request
 //	  - 401 Unauthorized (Failed Authentication)
 //	  - 404 User Not Found
@@ -120,8 +275,11 @@ func (env *Env) Get(w http.ResponseWriter, r *http.Request) {
 //	- id
 //	    the id of the ftp user entry
 //
+//	Response Headers:
+//	  ETag - the version of the account to send in If-Match when it is changed
+//
 //	Response Body:
-//	  {"id":11,"username":"testuser","description":"test description"}
+//	  {"id":11,"username":"testuser","description":"test description","last_login_at":"2022-05-04T10:15:00Z","last_login_ip":"10.0.0.7","login_count":42}
 func (env *Env) IDGet(w http.ResponseWriter, r *http.Request) {
 	// setup error response
 	er := apierror.NewErrorResponse(w, r)
@@ -152,21 +310,19 @@ func (env *Env) IDGet(w http.ResponseWriter, r *http.Request) {
 		er.WriteResponse()
 		return
 	}
-	user, err := env.Data.FtpUserGet(uint32(id))
+	user, err := env.Data.FtpUserGet(r.Context(), uint32(id))
 	if err != nil {
-		e := err.Error()
-		if e == data.ErrUserNotFound {
-			er.Status = http.StatusNotFound
-			er.Message = e
-			er.WriteResponse()
-			return
-		}
-		er.Status = http.StatusInternalServerError
-		er.Err = err
+		setDataError(&er, err)
 		er.WriteResponse()
 		return
 	}
 
+	setETag(w, user.UpdatedOn)
+	if notModified(r, user.UpdatedOn) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	output, err := json.Marshal(user)
 	if err != nil {
 		er.Status = http.StatusInternalServerError
@@ -181,6 +337,7 @@ func (env *Env) IDGet(w http.ResponseWriter, r *http.Request) {
 }
 
 // Post - create an FTP User
+// - the optional mappings are created in the same transaction, so either all of them and the account are created or none are
 //
 //	Responses:
 //	  - 201 Created
@@ -190,10 +347,13 @@ func (env *Env) IDGet(w http.ResponseWriter, r *http.Request) {
 //	  - 500 Error
 //
 //	Request Body:
-//	  {username":"testuser", "description":"test description", "password":"testpassword"}
+//	  {username":"testuser", "description":"test description", "password":"testpassword", "mappings":[{"system":"BillSys1","id":"123"}]}
+//
+//	Response Headers:
+//	  ETag - the version of the created account to send in If-Match when it is changed
 //
 //	Response Body:
-//	  {"id":11,"username":"testuser","description":"test description"}
+//	  {"id":11,"username":"testuser","description":"test description","updated_on":"2022-05-04T10:15:00.123456Z","mappings":[{"system":"BillSys1","id":"123","ftp_id":11}]}
 func (env *Env) Post(w http.ResponseWriter, r *http.Request) {
 	// setup error response
 	er := apierror.NewErrorResponse(w, r)
@@ -217,7 +377,7 @@ func (env *Env) Post(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Unmarshall
-	var user data.FtpUser
+	var user newFtpUser
 	err = json.Unmarshal(b, &user)
 	if err != nil {
 		er.Status = http.StatusInternalServerError
@@ -235,27 +395,29 @@ func (env *Env) Post(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := env.Data.FtpUserCreate(user)
-	if err != nil {
-		e := err.Error()
-		if e == data.ErrFTPAccountExists {
+	// Empty System or ID not valid
+	for _, mapping := range user.Mappings {
+		if mapping.System == "" || mapping.SystemID == "" {
 			er.User = user.Username
-			er.Status = http.StatusConflict
-			er.Message = fmt.Sprintf(ErrFTPAccountExists, user.Username)
-			er.Err = err
+			er.Status = http.StatusBadRequest
+			er.Message = ErrFTPUserMappingReq
 			er.WriteResponse()
 			return
 		}
+	}
+
+	created, err := env.createFtpUser(r, er.RequestID, user.FtpUser, user.Mappings)
+	if err != nil {
 		er.User = user.Username
-		er.Status = http.StatusInternalServerError
-		er.Err = err
+		setDataError(&er, err)
+		if errors.Is(err, data.ErrFTPAccountExists) {
+			er.Message = fmt.Sprintf(ErrFTPAccountExists, user.Username)
+		}
 		er.WriteResponse()
 		return
 	}
 
-	user.ID = id
-	user.Password = ""
-
+	user.FtpUser = created
 	output, err := json.Marshal(user)
 	if err != nil {
 		er.User = user.Username
@@ -265,11 +427,79 @@ func (env *Env) Post(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setETag(w, created.UpdatedOn)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	w.Write(output)
 }
 
+// createFtpUser - create the account and map it to each of mappings in one transaction along with their audit entries
+// - the FTPAccountID of each mapping is set to the created account
+func (env *Env) createFtpUser(r *http.Request, requestID string, user data.FtpUser, mappings []data.NewMapping) (data.FtpUser, error) {
+	ctx := r.Context()
+
+	tx, err := env.Data.Begin(ctx)
+	if err != nil {
+		return data.FtpUser{}, err
+	}
+	defer tx.Rollback()
+
+	created, err := tx.FtpUserCreate(ctx, user)
+	if err != nil {
+		return data.FtpUser{}, err
+	}
+
+	after := created
+	after.Password = redactedPassword
+	err = audit(tx, r, requestID, data.AuditActionCreate, data.AuditEntityFTPAccount, strconv.FormatUint(uint64(created.ID), 10), nil, after)
+	if err != nil {
+		return data.FtpUser{}, err
+	}
+
+	for i := range mappings {
+		// snapshot the mapping replaced by the new one, nil when it is inserted
+		var before interface{}
+		existing, err := tx.MappingRetrieve(ctx, mappings[i].System, mappings[i].SystemID)
+		if err == nil {
+			before = existing
+		} else if !errors.Is(err, data.ErrMappingNotFound) {
+			return data.FtpUser{}, err
+		}
+
+		mappings[i].FTPAccountID = created.ID
+		result, err := tx.MappingCreate(ctx, mappings[i])
+		if err != nil {
+			return data.FtpUser{}, err
+		}
+		if result != data.MappingInserted && result != data.MappingUpdated {
+			return data.FtpUser{}, fmt.Errorf(data.ErrUnexpectedResult, result)
+		}
+
+		var mapping data.Mapping
+		mapping.ID = mappings[i].SystemID
+		mapping.System = mappings[i].System
+		mapping.FTPAccount.ID = created.ID
+		mapping.FTPAccount.Username = created.Username
+		mapping.FTPAccount.Description = created.Description
+
+		action := data.AuditActionCreate
+		if before != nil {
+			action = data.AuditActionUpdate
+		}
+		err = audit(tx, r, requestID, action, data.AuditEntityMapping, mappingEntityID(mapping.System, mapping.ID), before, mapping)
+		if err != nil {
+			return data.FtpUser{}, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return data.FtpUser{}, err
+	}
+
+	return created, nil
+}
+
 // IDPut - update an FTP User specified by id
 //
 //	Responses:
@@ -277,6 +507,7 @@ func (env *Env) Post(w http.ResponseWriter, r *http.Request) {
 //	  - 400 Bad Request
 //	  - 401 Unauthorized (Failed Authentication)
 //	  - 404 Not Found
+//	  - 412 Precondition Failed (If-Match does not list the current ETag)
 //	  - 500 Error
 //
 //	Request Path Parameters:
@@ -286,6 +517,9 @@ func (env *Env) Post(w http.ResponseWriter, r *http.Request) {
 //
 //	Request Body:
 //	  {username":"testuser", "description":"test description"}
+//
+//	Response Headers:
+//	  ETag - the new version of the account to send in If-Match when it is next changed
 func (env *Env) IDPut(w http.ResponseWriter, r *http.Request) {
 	// setup error response
 	er := apierror.NewErrorResponse(w, r)
@@ -344,24 +578,77 @@ func (env *Env) IDPut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the snapshot, the write and its audit entry share a transaction, so the snapshot is read from the primary
+	// rather than a replica that may lag behind it and If-Match is checked against the version changed
+	tx, err := env.Data.Begin(r.Context())
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+	defer tx.Rollback()
+
+	// snapshot the account for the audit log
+	before, err := tx.FtpUserGet(r.Context(), uint32(id))
+	if err != nil {
+		setDataError(&er, err)
+		if errors.Is(err, data.ErrUserNotFound) {
+			er.Message = data.ErrFTPAccountNotFound.Error()
+		}
+		er.WriteResponse()
+		return
+	}
+
+	version, ok := checkIfMatch(r, before.UpdatedOn)
+	if !ok {
+		er.Status = http.StatusPreconditionFailed
+		er.Message = ErrPreconditionFailed
+		er.WriteResponse()
+		return
+	}
+
 	user.ID = uint32(id)
+	user.UpdatedOn = version
 
-	err = env.Data.FtpUserUpdate(user)
+	err = tx.FtpUserUpdate(r.Context(), user)
 	if err != nil {
-		e := err.Error()
-		if e == data.ErrFTPAccountNotFound {
-			er.Status = http.StatusNotFound
-			er.Message = e
-			er.WriteResponse()
-			return
+		setDataError(&er, err)
+		if errors.Is(err, data.ErrFTPAccountExists) {
+			er.User = user.Username
+			er.Message = fmt.Sprintf(ErrFTPAccountExists, user.Username)
 		}
-		er.Status = http.StatusInternalServerError
-		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	after := before
+	after.Username = user.Username
+	after.Description = user.Description
+	after.UpdatedOn = nil
+	err = audit(tx, r, er.RequestID, data.AuditActionUpdate, data.AuditEntityFTPAccount, strconv.FormatInt(id, 10), before, after)
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	// the new version for the ETag of the response
+	updated, err := tx.FtpUserGet(r.Context(), uint32(id))
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		setDataError(&er, err)
 		er.WriteResponse()
 		return
 	}
 
 	user.Password = ""
+	user.UpdatedOn = nil
 
 	output, err := json.Marshal(user)
 	if err != nil {
@@ -371,18 +658,20 @@ func (env *Env) IDPut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setETag(w, updated.UpdatedOn)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(output)
 }
 
-// IDDelete - delete an FTP User specified by id
+// IDDelete - soft delete an FTP User specified by id, the account and its mappings can be restored until purged
 //
 //	Responses:
 //	  - 204 No Content
 //	  - 400 Bad Request
 //	  - 401 Unauthorized (Failed Authentication)
 //	  - 404 Not Found
+//	  - 412 Precondition Failed (If-Match does not list the current ETag)
 //	  - 500 Error
 //
 //	Request Path Parameters:
@@ -419,18 +708,52 @@ func (env *Env) IDDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = env.Data.FtpUserDelete(uint32(id))
+	// the snapshot, the write and its audit entry share a transaction, so the snapshot is read from the primary
+	// rather than a replica that may lag behind it and If-Match is checked against the version changed
+	tx, err := env.Data.Begin(r.Context())
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+	defer tx.Rollback()
 
+	// snapshot the account for the audit log
+	before, err := tx.FtpUserGet(r.Context(), uint32(id))
 	if err != nil {
-		e := err.Error()
-		if e == data.ErrFTPAccountNotFound {
-			er.Status = http.StatusNotFound
-			er.Message = e
-			er.WriteResponse()
-			return
+		setDataError(&er, err)
+		if errors.Is(err, data.ErrUserNotFound) {
+			er.Message = data.ErrFTPAccountNotFound.Error()
 		}
-		er.Status = http.StatusInternalServerError
-		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	version, ok := checkIfMatch(r, before.UpdatedOn)
+	if !ok {
+		er.Status = http.StatusPreconditionFailed
+		er.Message = ErrPreconditionFailed
+		er.WriteResponse()
+		return
+	}
+
+	err = tx.FtpUserDelete(r.Context(), uint32(id), version)
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	err = audit(tx, r, er.RequestID, data.AuditActionDelete, data.AuditEntityFTPAccount, strconv.FormatInt(id, 10), before, nil)
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		setDataError(&er, err)
 		er.WriteResponse()
 		return
 	}
@@ -445,6 +768,7 @@ func (env *Env) IDDelete(w http.ResponseWriter, r *http.Request) {
 //	  - 400 Bad Request
 //	  - 401 Unauthorized (Failed Authentication)
 //	  - 404 Not Found
+//	  - 412 Precondition Failed (If-Match does not list the current ETag)
 //	  - 500 Error
 //
 //	Request Path Parameters:
@@ -454,6 +778,9 @@ func (env *Env) IDDelete(w http.ResponseWriter, r *http.Request) {
 //
 //	Request Body:
 //	  {"password":"newpassword"}
+//
+//	Response Headers:
+//	  ETag - the new version of the account to send in If-Match when it is next changed
 func (env *Env) IDPatch(w http.ResponseWriter, r *http.Request) {
 	// setup error response
 	er := apierror.NewErrorResponse(w, r)
@@ -512,22 +839,169 @@ func (env *Env) IDPatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user.ID = uint32(id)
+	// the snapshot, the write and its audit entry share a transaction, so the snapshot is read from the primary
+	// rather than a replica that may lag behind it and If-Match is checked against the version changed
+	tx, err := env.Data.Begin(r.Context())
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+	defer tx.Rollback()
 
-	err = env.Data.FtpUserUpdatePassword(user)
+	// snapshot the account for the audit log
+	before, err := tx.FtpUserGet(r.Context(), uint32(id))
 	if err != nil {
-		e := err.Error()
-		if e == data.ErrFTPAccountNotFound {
-			er.Status = http.StatusNotFound
-			er.Message = e
-			er.WriteResponse()
-			return
+		setDataError(&er, err)
+		if errors.Is(err, data.ErrUserNotFound) {
+			er.Message = data.ErrFTPAccountNotFound.Error()
 		}
+		er.WriteResponse()
+		return
+	}
+
+	version, ok := checkIfMatch(r, before.UpdatedOn)
+	if !ok {
+		er.Status = http.StatusPreconditionFailed
+		er.Message = ErrPreconditionFailed
+		er.WriteResponse()
+		return
+	}
+
+	user.ID = uint32(id)
+	user.UpdatedOn = version
+
+	err = tx.FtpUserUpdatePassword(r.Context(), user)
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	after := before
+	after.Password = redactedPassword
+	after.UpdatedOn = nil
+	err = audit(tx, r, er.RequestID, data.AuditActionUpdatePassword, data.AuditEntityFTPAccount, strconv.FormatInt(id, 10), before, after)
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	// the new version for the ETag of the response
+	updated, err := tx.FtpUserGet(r.Context(), uint32(id))
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	setETag(w, updated.UpdatedOn)
+	w.WriteHeader(http.StatusOK)
+}
+
+// IDRestore - restore a soft deleted FTP User specified by id along with its mappings
+//
+//	Responses:
+//	  - 200 Success
+//	  - 400 Bad Request
+//	  - 401 Unauthorized (Failed Authentication)
+//	  - 404 Not Found (No deleted account with the id)
+//	  - 500 Error
+//
+//	Request Path Parameters:
+//	  /ftpusers/{id}/restore
+//	- id
+//	    the id of the ftp user entry
+//
+//	Response Body:
+//	  {"id":11,"username":"testuser","description":"test description"}
+func (env *Env) IDRestore(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	env.idRestoreWithVars(w, r, vars)
+}
+
+func (env *Env) idRestoreWithVars(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	// setup error response
+	er := apierror.NewErrorResponse(w, r)
+
+	// Authenticate
+	if !auth.Authenticate(r) {
+		er.Status = http.StatusUnauthorized
+		er.Message = auth.ErrUnauthorized
+		er.WriteResponse()
+		return
+	}
+
+	id, err := strconv.ParseInt(params["id"], 10, 32)
+	if err != nil {
+		er.Status = http.StatusBadRequest
+		er.Message = fmt.Sprintf(ErrFTPUserIDConversion, params["id"])
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	if id < 1 {
+		er.Status = http.StatusBadRequest
+		er.Message = ErrInvalidFTPUserID
+		er.WriteResponse()
+		return
+	}
+
+	// the restore is committed along with its audit entry
+	tx, err := env.Data.Begin(r.Context())
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.FtpUserRestore(r.Context(), uint32(id))
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	user, err := tx.FtpUserGet(r.Context(), uint32(id))
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	err = audit(tx, r, er.RequestID, data.AuditActionRestore, data.AuditEntityFTPAccount, strconv.FormatInt(id, 10), nil, user)
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	output, err := json.Marshal(user)
+	if err != nil {
 		er.Status = http.StatusInternalServerError
 		er.Err = err
 		er.WriteResponse()
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	w.Write(output)
 }
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,10 +13,13 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	sftpgo "github.com/drakkan/sftpgo/v2/dataprovider"
+	"github.com/gorilla/mux"
 	"github.com/halt-joe/ftp-user-svc/data"
+	"github.com/halt-joe/ftp-user-svc/data/memory"
 )
 
 const (
@@ -24,9 +28,15 @@ const (
 
 var errNotImplmented = errors.New("not implemented")
 
-type mockDB struct{}
+// mockUpdatedOn - the version of every account returned by the mockDB
+var mockUpdatedOn = time.Date(2022, 5, 4, 10, 15, 0, 0, time.UTC)
 
-func (mdb *mockDB) FtpUserLookup(username string) (sftpgo.User, error) {
+type mockDB struct {
+	audits   []data.AuditEntry
+	mappings map[string]data.Mapping
+}
+
+func (mdb *mockDB) FtpUserLookup(ctx context.Context, username string) (sftpgo.User, error) {
 	if username == "Test" {
 		user := sftpgo.User{}
 		user.ID = 987
@@ -35,37 +45,83 @@ func (mdb *mockDB) FtpUserLookup(username string) (sftpgo.User, error) {
 		user.Password = "pass"
 		return user, nil
 	}
-	return sftpgo.User{}, errors.New(data.ErrUserNotFound)
+	return sftpgo.User{}, data.ErrUserNotFound
 }
-func (mdb *mockDB) MappingDelete(system string, id string) (int64, error) {
+func (mdb *mockDB) MappingDelete(ctx context.Context, system string, id string, version *time.Time) (int64, error) {
 	return 0, nil
 }
-func (mdb *mockDB) MappingRetrieve(system string, id string) (data.Mapping, error) {
-	return data.Mapping{}, errors.New("MappingRetrieve not implemented")
+func (mdb *mockDB) MappingRetrieve(ctx context.Context, system string, id string) (data.Mapping, error) {
+	mapping, ok := mdb.mappings[system+"/"+id]
+	if !ok {
+		return data.Mapping{}, data.ErrMappingNotFound
+	}
+	return mapping, nil
 }
-func (mdb *mockDB) MappingCreate(mapping data.NewMapping) (int, error) {
+func (mdb *mockDB) MappingCreate(ctx context.Context, mapping data.NewMapping) (int, error) {
+	user, err := mdb.FtpUserGet(ctx, mapping.FTPAccountID)
+	if err != nil {
+		return data.MappingError, err
+	}
+	if mdb.mappings == nil {
+		mdb.mappings = make(map[string]data.Mapping)
+	}
+	account := data.FtpUser{ID: user.ID, Username: user.Username, Description: user.Description}
+	mdb.mappings[mapping.System+"/"+mapping.SystemID] = data.Mapping{System: mapping.System, ID: mapping.SystemID, FTPAccount: account, UpdatedOn: &mockUpdatedOn}
 	return data.MappingInserted, nil
 }
-func (mdb *mockDB) FtpUserGetSelection(page uint32, pageSize uint32, search string) (data.FtpUsers, error) {
+func (mdb *mockDB) FtpUserGetSelection(ctx context.Context, page uint32, pageSize uint32, search string, filter data.FtpUserFilter) (data.FtpUsers, error) {
 	return data.FtpUsers{}, errors.New("FtpUserGetSelection() Not implemented")
 }
-func (mdb *mockDB) FtpUserGet(id uint32) (data.FtpUser, error) {
-	user, err := mdb.FtpUserLookup("Test")
-	result := data.FtpUser{ID: uint32(user.ID), Username: user.Username, Description: user.Description, Password: user.Password}
+func (mdb *mockDB) FtpUserGet(ctx context.Context, id uint32) (data.FtpUser, error) {
+	if err := ctx.Err(); err != nil {
+		return data.FtpUser{}, err
+	}
+	user, err := mdb.FtpUserLookup(ctx, "Test")
+	result := data.FtpUser{ID: uint32(user.ID), Username: user.Username, Description: user.Description, Password: user.Password, UpdatedOn: &mockUpdatedOn}
 	return result, err
 }
-func (mdb *mockDB) FtpUserCreate(user data.FtpUser) (uint32, error) {
-	return 1, nil
+func (mdb *mockDB) FtpUserCreate(ctx context.Context, user data.FtpUser) (data.FtpUser, error) {
+	return data.FtpUser{ID: 1, Username: user.Username, Description: user.Description, UpdatedOn: &mockUpdatedOn}, nil
 }
-func (mdb *mockDB) FtpUserUpdate(user data.FtpUser) error {
-	return errNotImplmented
+func (mdb *mockDB) Begin(ctx context.Context) (data.Tx, error) {
+	return mockTx{mdb}, nil
+}
+
+// mockTx - a transaction of the mockDB, its writes are made as they happen
+type mockTx struct {
+	*mockDB
+}
+
+func (tx mockTx) Begin(ctx context.Context) (data.Tx, error) {
+	return nil, data.ErrNestedTransaction
+}
+func (tx mockTx) Commit() error {
+	return nil
+}
+func (tx mockTx) Rollback() error {
+	return nil
 }
-func (mdb *mockDB) FtpUserDelete(id uint32) error {
+func (mdb *mockDB) FtpUserUpdate(ctx context.Context, user data.FtpUser) error {
 	return errNotImplmented
 }
-func (mdb *mockDB) FtpUserUpdatePassword(user data.FtpUser) error {
+func (mdb *mockDB) FtpUserDelete(ctx context.Context, id uint32, version *time.Time) error {
+	return nil
+}
+func (mdb *mockDB) FtpUserUpdatePassword(ctx context.Context, user data.FtpUser) error {
 	return errNotImplmented
 }
+func (mdb *mockDB) FtpUserRestore(ctx context.Context, id uint32) error {
+	if id == 404 {
+		return data.ErrDeletedFTPAccount
+	}
+	return nil
+}
+func (mdb *mockDB) FtpUserPurge(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+func (mdb *mockDB) FtpUserRecordLogin(ctx context.Context, id uint32, ip string) error {
+	return nil
+}
 func TestGet(t *testing.T) {
 
 	db, _, err := sqlmock.New()
@@ -297,11 +353,12 @@ func TestGetResponse(t *testing.T) {
 			}
 			defer db.Close()
 
-			columns := []string{"id", "username", "description"}
+			columns := []string{"id", "username", "description", "last_login_at", "last_login_ip", "login_count", "deleted_at", "updated_on"}
 
 			cntQuery := "select count\\([`\"]id[`\"]\\) from [`\"]ftp_account[`\"]"
-			selQuery := "select [`\"]id[`\"], [`\"]username[`\"], [`\"]description[`\"] from [`\"]ftp_account[`\"]"
-			searchClause := " where [`\"]username[`\"] like (\\?|\\$1) or [`\"]description[`\"] like (\\?|\\$2)"
+			selQuery := "select [`\"]id[`\"], [`\"]username[`\"], [`\"]description[`\"], [`\"]last_login_at[`\"], [`\"]last_login_ip[`\"], [`\"]login_count[`\"], [`\"]deleted_at[`\"], [`\"]updated_on[`\"] from [`\"]ftp_account[`\"]"
+			activeClause := " where [`\"]deleted_at[`\"] is null"
+			searchClause := activeClause + " and \\([`\"]username[`\"] like (\\?|\\$1) escape '!' or [`\"]description[`\"] like (\\?|\\$2) escape '!'\\)"
 			orderClause := " order by [`\"]id[`\"]"
 
 			expPageRows := mock.NewRows(columns)
@@ -344,13 +401,13 @@ func TestGetResponse(t *testing.T) {
 				if pageIndex == page {
 					if search != "" {
 						if searchExists {
-							expPageRows = expPageRows.AddRow(r, username, description)
-							pageData.Ftpusers = append(pageData.Ftpusers, data.FtpUser{ID: uint32(r), Username: username, Description: description, Password: ""})
+							expPageRows = expPageRows.AddRow(r, username, description, nil, nil, 0, nil, mockUpdatedOn)
+							pageData.Ftpusers = append(pageData.Ftpusers, data.FtpUser{ID: uint32(r), Username: username, Description: description, Password: "", UpdatedOn: &mockUpdatedOn})
 							resultCount++
 						}
 					} else {
-						expPageRows = expPageRows.AddRow(r, username, description)
-						pageData.Ftpusers = append(pageData.Ftpusers, data.FtpUser{ID: uint32(r), Username: username, Description: description, Password: ""})
+						expPageRows = expPageRows.AddRow(r, username, description, nil, nil, 0, nil, mockUpdatedOn)
+						pageData.Ftpusers = append(pageData.Ftpusers, data.FtpUser{ID: uint32(r), Username: username, Description: description, Password: "", UpdatedOn: &mockUpdatedOn})
 						resultCount++
 					}
 				}
@@ -369,13 +426,18 @@ func TestGetResponse(t *testing.T) {
 				pageData.TotalPages++
 			}
 
+			// the mock returns no row past the page so only pages after the first link back
+			if page > 1 && len(pageData.Ftpusers) > 0 {
+				pageData.Prev = data.NewCursor(pageData.Ftpusers[0], "", true)
+			}
+
 			// set the expected queries and their results
 			if search != "" {
 				mock.ExpectQuery(cntQuery + searchClause).WillReturnRows(expCountRows)
 				mock.ExpectQuery(selQuery + searchClause).WillReturnRows(expPageRows)
 			} else {
-				mock.ExpectQuery(cntQuery).WillReturnRows(expCountRows)
-				mock.ExpectQuery(selQuery + orderClause).WillReturnRows(expPageRows)
+				mock.ExpectQuery(cntQuery + activeClause).WillReturnRows(expCountRows)
+				mock.ExpectQuery(selQuery + activeClause + orderClause).WillReturnRows(expPageRows)
 			}
 
 			w := httptest.NewRecorder()
@@ -452,3 +514,402 @@ func TestGetResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestIDRestore(t *testing.T) {
+	type args struct {
+		w              *httptest.ResponseRecorder
+		r              *http.Request
+		params         map[string]string
+		expectedStatus int
+		expectedAudits int
+	}
+	tests := []struct {
+		name string
+		args func(t *testing.T) args
+	}{
+		{
+			name: "Test ftp user restore Success",
+			args: func(t *testing.T) args {
+				return args{
+					w:              httptest.NewRecorder(),
+					r:              httptest.NewRequest("POST", "https://ftpsvc.dev.run/ftpusers/987/restore", nil),
+					params:         map[string]string{"id": "987"},
+					expectedStatus: http.StatusOK,
+					expectedAudits: 1,
+				}
+			},
+		},
+		{
+			name: "Test ftp user restore Not Found",
+			args: func(t *testing.T) args {
+				return args{
+					w:              httptest.NewRecorder(),
+					r:              httptest.NewRequest("POST", "https://ftpsvc.dev.run/ftpusers/404/restore", nil),
+					params:         map[string]string{"id": "404"},
+					expectedStatus: http.StatusNotFound,
+				}
+			},
+		},
+		{
+			name: "Test ftp user restore Bad Request",
+			args: func(t *testing.T) args {
+				return args{
+					w:              httptest.NewRecorder(),
+					r:              httptest.NewRequest("POST", "https://ftpsvc.dev.run/ftpusers/abc/restore", nil),
+					params:         map[string]string{"id": "abc"},
+					expectedStatus: http.StatusBadRequest,
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tArgs := tt.args(t)
+			mdb := &mockDB{}
+			env := Env{Data: mdb}
+
+			env.idRestoreWithVars(tArgs.w, tArgs.r, tArgs.params)
+			resp := tArgs.w.Result()
+			if resp.StatusCode != tArgs.expectedStatus {
+				t.Errorf("Expected status %d but received %d", tArgs.expectedStatus, resp.StatusCode)
+			}
+			if len(mdb.audits) != tArgs.expectedAudits {
+				t.Errorf("Expected %d audit entries but received %d", tArgs.expectedAudits, len(mdb.audits))
+			}
+		})
+	}
+}
+
+func TestGetCursor(t *testing.T) {
+	store := memory.New()
+	env := Env{Data: store}
+
+	for i := 1; i <= 5; i++ {
+		_, _ = store.FtpUserCreate(context.Background(), data.FtpUser{Username: fmt.Sprintf("Test%d", i), Password: "secret"})
+	}
+
+	// get - the status and body of GET /ftpusers with query
+	get := func(query string) (int, data.FtpUsers) {
+		w := httptest.NewRecorder()
+		env.Get(w, httptest.NewRequest("GET", "https://ftpsvc.dev.run/ftpusers?"+query, nil))
+		resp := w.Result()
+
+		var users data.FtpUsers
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
+				t.Fatalf("unexpected error decoding the response %s", err)
+			}
+		}
+		return resp.StatusCode, users
+	}
+
+	status, first := get("page_size=2&sort=-username")
+	if status != http.StatusOK || first.Next == "" || first.Prev != "" || first.TotalItems != 5 {
+		t.Fatalf("Expected a first page with a next cursor but received %d %+v", status, first)
+	}
+
+	status, second := get("page_size=2&sort=-username&count=false&cursor=" + first.Next)
+	if status != http.StatusOK || len(second.Ftpusers) != 2 || second.Ftpusers[0].Username != "Test3" || second.TotalItems != 0 || second.Prev == "" {
+		t.Errorf("Expected the second page without totals but received %d %+v", status, second)
+	}
+
+	for _, query := range []string{"cursor=notacursor", "sort=username&cursor=" + first.Next, "count=maybe"} {
+		if status, _ := get(query); status != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s but received %d", http.StatusBadRequest, query, status)
+		}
+	}
+}
+
+func TestGetFilters(t *testing.T) {
+	store := memory.New()
+	env := Env{Data: store}
+
+	for i, username := range []string{"alpha", "alpine", "bravo"} {
+		user, _ := store.FtpUserCreate(context.Background(), data.FtpUser{Username: username, Description: "Account " + username, Password: "secret"})
+		if i > 0 {
+			_, _ = store.MappingCreate(context.Background(), data.NewMapping{System: data.LookupSystem, SystemID: username, FTPAccountID: user.ID})
+		}
+	}
+
+	tests := []struct {
+		query     string
+		expStatus int
+		expUsers  []string
+	}{
+		{query: "username=alpha", expStatus: http.StatusOK, expUsers: []string{"alpha"}},
+		{query: "username_prefix=alp&sort=-username", expStatus: http.StatusOK, expUsers: []string{"alpine", "alpha"}},
+		{query: "description~=bra", expStatus: http.StatusOK, expUsers: []string{"bravo"}},
+		{query: "has_mapping_in=OtherSys," + data.LookupSystem + "&sort=username,id", expStatus: http.StatusOK, expUsers: []string{"alpine", "bravo"}},
+		{query: "updated_since=2000-01-01T00:00:00Z&status=active", expStatus: http.StatusOK, expUsers: []string{"alpha", "alpine", "bravo"}},
+		{query: "status=deleted", expStatus: http.StatusOK},
+		{query: "status=removed", expStatus: http.StatusBadRequest},
+		{query: "status=active&deleted=true", expStatus: http.StatusBadRequest},
+		{query: "has_mapping_in=" + data.LookupSystem + ",", expStatus: http.StatusBadRequest},
+		{query: "updated_since=yesterday", expStatus: http.StatusBadRequest},
+		{query: "sort=username,-username", expStatus: http.StatusBadRequest},
+		{query: "sort=password", expStatus: http.StatusBadRequest},
+		{query: "q=alp&q_mode=prefix", expStatus: http.StatusOK, expUsers: []string{"alpha", "alpine"}},
+		{query: "q=*o&q_mode=wildcard", expStatus: http.StatusOK, expUsers: []string{"bravo"}},
+		{query: "q=alp&q_mode=fuzzy", expStatus: http.StatusBadRequest},
+		{query: "q=**&q_mode=wildcard", expStatus: http.StatusBadRequest},
+		{query: "q=" + strings.Repeat("a", data.MaxSearchLength+1), expStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			env.Get(w, httptest.NewRequest("GET", "https://ftpsvc.dev.run/ftpusers?"+tt.query, nil))
+			resp := w.Result()
+			if resp.StatusCode != tt.expStatus {
+				t.Fatalf("Expected status %d but received %d", tt.expStatus, resp.StatusCode)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+
+			var users data.FtpUsers
+			if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
+				t.Fatalf("unexpected error decoding the response %s", err)
+			}
+			var usernames []string
+			for _, user := range users.Ftpusers {
+				usernames = append(usernames, user.Username)
+			}
+			if fmt.Sprint(usernames) != fmt.Sprint(tt.expUsers) {
+				t.Errorf("Expected %v but received %v", tt.expUsers, usernames)
+			}
+		})
+	}
+}
+
+func TestIDPutConflict(t *testing.T) {
+	store := memory.New()
+	env := Env{Data: store}
+
+	created, _ := store.FtpUserCreate(context.Background(), data.FtpUser{Username: "Test", Password: "secret"})
+	_, _ = store.FtpUserCreate(context.Background(), data.FtpUser{Username: "Taken", Password: "secret"})
+	id := created.ID
+
+	w := httptest.NewRecorder()
+	r := mux.SetURLVars(httptest.NewRequest("PUT", "https://ftpsvc.dev.run/ftpusers/1", strings.NewReader("{\"username\":\"Taken\",\"description\":\"A renamed user\"}")), map[string]string{"id": strconv.FormatUint(uint64(id), 10)})
+
+	env.IDPut(w, r)
+	resp := w.Result()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status %d but received %d", http.StatusConflict, resp.StatusCode)
+	}
+
+	user, _ := store.FtpUserGet(context.Background(), id)
+	if user.Username != "Test" {
+		t.Errorf("Expected the username to be unchanged but received %s", user.Username)
+	}
+}
+
+func TestPostReturnsCreatedRow(t *testing.T) {
+	store := memory.New()
+	env := Env{Data: store}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "https://ftpsvc.dev.run/ftpusers", strings.NewReader("{\"username\":\"Test\",\"description\":\"A test user\",\"password\":\"secret\"}"))
+
+	env.Post(w, r)
+	resp := w.Result()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d but received %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	var user data.FtpUser
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		t.Fatalf("unexpected error decoding the response %s", err)
+	}
+	if user.ID == 0 || user.Password != "" || user.UpdatedOn == nil {
+		t.Errorf("Expected the created row without its password but received %+v", user)
+	}
+	if resp.Header.Get("ETag") != etag(user.UpdatedOn) {
+		t.Errorf("Expected ETag %s but received %s", etag(user.UpdatedOn), resp.Header.Get("ETag"))
+	}
+}
+
+// failingMappingTx - a transaction whose mapping writes fail
+type failingMappingTx struct {
+	data.Tx
+}
+
+func (tx failingMappingTx) MappingCreate(ctx context.Context, mapping data.NewMapping) (int, error) {
+	return data.MappingError, errNotImplmented
+}
+
+// failingMappingStore - a Datastore whose transactions fail to write mappings
+type failingMappingStore struct {
+	data.Datastore
+}
+
+func (s failingMappingStore) Begin(ctx context.Context) (data.Tx, error) {
+	tx, err := s.Datastore.Begin(ctx)
+	return failingMappingTx{tx}, err
+}
+
+func TestPostWithMappings(t *testing.T) {
+	body := "{\"username\":\"Test\",\"description\":\"A test user\",\"password\":\"secret\",\"mappings\":[{\"system\":\"BillSys1\",\"id\":\"123\"},{\"system\":\"BillSys1\",\"id\":\"456\"}]}"
+
+	t.Run("Created Together", func(t *testing.T) {
+		store := memory.New()
+		env := Env{Data: store}
+
+		w := httptest.NewRecorder()
+		env.Post(w, httptest.NewRequest("POST", "https://ftpsvc.dev.run/ftpusers", strings.NewReader(body)))
+		resp := w.Result()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status %d but received %d", http.StatusCreated, resp.StatusCode)
+		}
+
+		var created newFtpUser
+		if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+			t.Fatalf("unexpected error decoding the response %s", err)
+		}
+		if len(created.Mappings) != 2 || created.Mappings[0].FTPAccountID != created.ID || created.Mappings[1].FTPAccountID != created.ID {
+			t.Errorf("Expected both mappings for account %d but received %+v", created.ID, created.Mappings)
+		}
+
+		ids, _ := store.SystemIDUserRetrieve(context.Background(), "BillSys1")
+		if len(ids) != 2 || ids["123"] != "Test" || ids["456"] != "Test" {
+			t.Errorf("Expected both mappings to be stored but found %v", ids)
+		}
+
+		entries, _ := store.AuditGetSelection(context.Background(), 0, 0, data.AuditFilter{})
+		if entries.TotalItems != 3 {
+			t.Errorf("Expected 3 audit entries but received %d", entries.TotalItems)
+		}
+	})
+
+	t.Run("Mapping Required Fields", func(t *testing.T) {
+		store := memory.New()
+		env := Env{Data: store}
+
+		w := httptest.NewRecorder()
+		env.Post(w, httptest.NewRequest("POST", "https://ftpsvc.dev.run/ftpusers", strings.NewReader("{\"username\":\"Test\",\"description\":\"A test user\",\"password\":\"secret\",\"mappings\":[{\"system\":\"BillSys1\"}]}")))
+		if w.Result().StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status %d but received %d", http.StatusBadRequest, w.Result().StatusCode)
+		}
+	})
+
+	t.Run("Rolled Back Together", func(t *testing.T) {
+		store := memory.New()
+		env := Env{Data: failingMappingStore{store}}
+
+		w := httptest.NewRecorder()
+		env.Post(w, httptest.NewRequest("POST", "https://ftpsvc.dev.run/ftpusers", strings.NewReader(body)))
+		if w.Result().StatusCode != http.StatusInternalServerError {
+			t.Errorf("Expected status %d but received %d", http.StatusInternalServerError, w.Result().StatusCode)
+		}
+
+		users, _ := store.FtpUserGetSelection(context.Background(), 0, 0, "", data.FtpUserFilter{})
+		if users.TotalItems != 0 {
+			t.Errorf("Expected the account to be rolled back but found %+v", users.Ftpusers)
+		}
+	})
+}
+
+func TestIDGetContextEnded(t *testing.T) {
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name           string
+		ctx            context.Context
+		expectedStatus int
+	}{
+		{name: "Deadline exceeded", ctx: expired, expectedStatus: http.StatusGatewayTimeout},
+		{name: "Cancelled", ctx: cancelled, expectedStatus: http.StatusServiceUnavailable},
+	}
+
+	env := Env{Data: &mockDB{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := mux.SetURLVars(httptest.NewRequest("GET", "https://ftpsvc.dev.run/ftpusers/987", nil).WithContext(tt.ctx), map[string]string{"id": "987"})
+
+			env.IDGet(w, r)
+			resp := w.Result()
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d but received %d", tt.expectedStatus, resp.StatusCode)
+			}
+		})
+	}
+}
+
+// laggingReplicaStore - a Datastore whose reads outside a transaction come from a replica that has not
+// caught up, accounts have their previous version and mappings are missing
+type laggingReplicaStore struct {
+	*memory.Store
+}
+
+func (s laggingReplicaStore) FtpUserGet(ctx context.Context, id uint32) (data.FtpUser, error) {
+	user, err := s.Store.FtpUserGet(ctx, id)
+	if err == nil {
+		stale := user.UpdatedOn.Add(-time.Minute)
+		user.UpdatedOn = &stale
+	}
+	return user, err
+}
+
+func (s laggingReplicaStore) MappingRetrieve(ctx context.Context, system string, id string) (data.Mapping, error) {
+	return data.Mapping{}, data.ErrMappingNotFound
+}
+
+func TestWritesReadPrimary(t *testing.T) {
+	store := memory.New()
+	env := Env{Data: laggingReplicaStore{store}}
+
+	user, _ := store.FtpUserCreate(context.Background(), data.FtpUser{Username: "Test", Description: "A test user", Password: "secret"})
+	other, _ := store.FtpUserCreate(context.Background(), data.FtpUser{Username: "Other", Description: "Another user", Password: "secret"})
+	_, _ = store.MappingCreate(context.Background(), data.NewMapping{System: "BillSys1", SystemID: "1", FTPAccountID: user.ID})
+	_, _ = store.MappingCreate(context.Background(), data.NewMapping{System: "BillSys1", SystemID: "2", FTPAccountID: user.ID})
+
+	// the current version of the account, each successful write changes it
+	ifMatch := func() string {
+		current, _ := store.FtpUserGet(context.Background(), user.ID)
+		return etag(current.UpdatedOn)
+	}
+	id := map[string]string{"id": strconv.FormatUint(uint64(user.ID), 10)}
+
+	tests := []struct {
+		name      string
+		method    string
+		url       string
+		body      string
+		vars      map[string]string
+		ifMatch   func() string
+		handler   func(w http.ResponseWriter, r *http.Request)
+		expStatus int
+	}{
+		{name: "Put", method: "PUT", url: "/ftpusers/1", body: "{\"username\":\"Test\",\"description\":\"Renamed\"}", vars: id, ifMatch: ifMatch, handler: env.IDPut, expStatus: http.StatusOK},
+		{name: "Patch", method: "PATCH", url: "/ftpusers/1", body: "{\"password\":\"changed\"}", vars: id, ifMatch: ifMatch, handler: env.IDPatch, expStatus: http.StatusOK},
+		{name: "Move mapping", method: "POST", url: "/mappings/BillSys1", body: fmt.Sprintf("{\"id\":\"1\",\"ftp_id\":%d}", other.ID), vars: map[string]string{"system": "BillSys1"}, handler: env.SystemPost, expStatus: http.StatusOK},
+		{name: "Delete mapping", method: "DELETE", url: "/mappings/BillSys1/2", vars: map[string]string{"system": "BillSys1", "id": "2"}, handler: env.SystemIDDelete, expStatus: http.StatusNoContent},
+		{name: "Delete", method: "DELETE", url: "/ftpusers/1", vars: id, ifMatch: ifMatch, handler: env.IDDelete, expStatus: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mux.SetURLVars(httptest.NewRequest(tt.method, "https://ftpsvc.dev.run"+tt.url, strings.NewReader(tt.body)), tt.vars)
+			if tt.ifMatch != nil {
+				r.Header.Set("If-Match", tt.ifMatch())
+			}
+
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+			if status := w.Result().StatusCode; status != tt.expStatus {
+				t.Errorf("Expected status %d but received %d", tt.expStatus, status)
+			}
+		})
+	}
+
+	entries, _ := store.AuditGetSelection(context.Background(), 0, 0, data.AuditFilter{})
+	for _, entry := range entries.Entries {
+		if entry.Before == nil {
+			t.Errorf("Expected the %s %s %s audit entry to have a before snapshot", entry.Action, entry.Entity, entry.EntityID)
+		}
+	}
+}
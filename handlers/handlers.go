@@ -1,10 +1,41 @@
 package handlers
 
 import (
+	"net/http"
+	"strconv"
+	"time"
+
 	"github.com/halt-joe/ftp-user-svc/data"
 )
 
 // Env - reference to datastore
+// - Logins writes the login history off the request path, without one it is written before /login responds
 type Env struct {
-	Data data.Datastore
+	Data   data.Datastore
+	Logins *LoginRecorder
+}
+
+// parseUint32Param - read the optional query parameter name as a uint32, 0 when not provided
+func parseUint32Param(r *http.Request, name string) (uint32, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	i, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(i), nil
+}
+
+// parseTimeParam - read the optional query parameter name as an RFC 3339 time, the zero time when not provided
+func parseTimeParam(r *http.Request, name string) (time.Time, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
 }
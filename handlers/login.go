@@ -2,8 +2,11 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"net"
 	"net/http"
 
 	sftpgo "github.com/drakkan/sftpgo/v2/dataprovider"
@@ -39,6 +42,59 @@ func GetUserNameFromLoginRequest(r *http.Request) string {
 	return result
 }
 
+// getLoginIP - the client ip reported by sftpgo, falling back to the address of the caller
+func getLoginIP(r *http.Request, creds data.Credentials) string {
+	if creds.IP != "" {
+		return creds.IP
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// recordLoginAttempt - count the login outcome and add it to the login history
+// - a success also records the login time and ip of the account
+func (env *Env) recordLoginAttempt(r *http.Request, requestID string, creds data.Credentials, ftpID uint32, status string) {
+	metrics.IncLoginTotals(status)
+
+	record := loginRecord{
+		attempt: data.LoginAttempt{
+			Username:     creds.Username,
+			FTPAccountID: ftpID,
+			IP:           getLoginIP(r, creds),
+			Protocol:     creds.Protocol,
+			Status:       status,
+			RequestID:    requestID,
+		},
+		success: status == metrics.LoginStatusSuccess,
+	}
+
+	if env.Logins != nil {
+		env.Logins.enqueue(record)
+		return
+	}
+	writeLoginRecord(env.Data, record)
+}
+
+// verifyLogin - the lookup user for creds and whether the password matches
+// - a data.LoginVerifier checks the password itself and does not return the stored one
+func (env *Env) verifyLogin(ctx context.Context, creds data.Credentials) (sftpgo.User, bool, error) {
+	if verifier, ok := env.Data.(data.LoginVerifier); ok {
+		return verifier.FtpUserVerify(ctx, creds.Username, creds.Password)
+	}
+
+	user, err := env.Data.FtpUserLookup(ctx, creds.Username)
+	if err != nil {
+		return user, false, err
+	}
+
+	return user, user.Password == creds.Password, nil
+}
+
 // LoginHandler - validates the provided credentials against the FTP User entries
 //
 //	 Responses:
@@ -47,7 +103,7 @@ func GetUserNameFromLoginRequest(r *http.Request) string {
 //		  - 500 Internal Server Error
 //
 //	 Request Body:
-//	   {username":"testuser", "password":"testpassword"}
+//	   {username":"testuser", "password":"testpassword", "ip":"10.0.0.7", "protocol":"SFTP"}
 //
 //	 Response Body:
 //	   {id:234, "status":1, "username":"testuser", "password":"testPassword", "description":"Test Description"}
@@ -55,7 +111,7 @@ func (env *Env) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	// setup error response
 	er := apierror.NewErrorResponse(w, r)
 
-	// Authenticate
+	// Authenticate, failures are only counted as the caller is not a trusted client
 	if !auth.Authenticate(r) {
 		metrics.IncLoginTotals(metrics.LoginStatusAuthFailure)
 		er.Status = http.StatusUnauthorized
@@ -65,10 +121,11 @@ func (env *Env) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Read Body
+	var creds data.Credentials
 	b, err := io.ReadAll(r.Body)
 	defer r.Body.Close()
 	if err != nil {
-		metrics.IncLoginTotals(metrics.LoginStatusServerError)
+		env.recordLoginAttempt(r, er.RequestID, creds, 0, metrics.LoginStatusServerError)
 		er.Status = http.StatusInternalServerError
 		er.Err = err
 		er.WriteResponse()
@@ -76,10 +133,9 @@ func (env *Env) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Unmarshall
-	var creds data.Credentials
 	err = json.Unmarshal(b, &creds)
 	if err != nil {
-		metrics.IncLoginTotals(metrics.LoginStatusServerError)
+		env.recordLoginAttempt(r, er.RequestID, creds, 0, metrics.LoginStatusServerError)
 		er.Status = http.StatusInternalServerError
 		er.Err = err
 		er.WriteResponse()
@@ -88,7 +144,7 @@ func (env *Env) LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Empty Username or Password not valid
 	if creds.Username == "" || creds.Password == "" {
-		metrics.IncLoginTotals(metrics.LoginStatusUserPassBlank)
+		env.recordLoginAttempt(r, er.RequestID, creds, 0, metrics.LoginStatusUserPassBlank)
 		er.User = creds.Username
 		er.Status = http.StatusUnauthorized
 		er.Message = auth.ErrUnauthorized
@@ -97,27 +153,25 @@ func (env *Env) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Look for User in Database
-	user, err := env.Data.FtpUserLookup(creds.Username)
+	user, matched, err := env.verifyLogin(r.Context(), creds)
 	if err != nil {
-		e := err.Error()
-		if e == data.ErrUserNotFound {
-			metrics.IncLoginTotals(metrics.LoginStatusUserNotFound)
+		if errors.Is(err, data.ErrUserNotFound) {
+			env.recordLoginAttempt(r, er.RequestID, creds, 0, metrics.LoginStatusUserNotFound)
 			er.User = creds.Username
 			er.Status = http.StatusUnauthorized
 			er.Message = auth.ErrUnauthorized
 			er.WriteResponse()
 			return
 		}
-		metrics.IncLoginTotals(metrics.LoginStatusServerError)
+		env.recordLoginAttempt(r, er.RequestID, creds, 0, metrics.LoginStatusServerError)
 		er.User = creds.Username
-		er.Status = http.StatusInternalServerError
-		er.Err = err
+		setDataError(&er, err)
 		er.WriteResponse()
 		return
 	}
 
-	if user.Password != creds.Password {
-		metrics.IncLoginTotals(metrics.LoginStatusBadPassword)
+	if !matched {
+		env.recordLoginAttempt(r, er.RequestID, creds, uint32(user.ID), metrics.LoginStatusBadPassword)
 		er.User = creds.Username
 		er.Status = http.StatusUnauthorized
 		er.Message = auth.ErrUnauthorized
@@ -125,9 +179,10 @@ func (env *Env) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	metrics.IncLoginTotals(metrics.LoginStatusSuccess)
+	env.recordLoginAttempt(r, er.RequestID, creds, uint32(user.ID), metrics.LoginStatusSuccess)
 
 	user.Status = 1
+	user.Password = creds.Password
 
 	// set user permissions to list and download only
 	user.Permissions = map[string][]string{"/": {sftpgo.PermListItems, sftpgo.PermDownload}}
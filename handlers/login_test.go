@@ -1,11 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/halt-joe/ftp-user-svc/data"
+	"github.com/halt-joe/ftp-user-svc/data/cache"
+	"github.com/halt-joe/ftp-user-svc/data/memory"
 )
 
 func TestLoginPost(t *testing.T) {
@@ -64,3 +70,86 @@ func TestLoginPost(t *testing.T) {
 		})
 	}
 }
+
+func TestLoginPostCached(t *testing.T) {
+	env := Env{Data: cache.New(&mockDB{}, 10, time.Minute)}
+
+	// the second login is answered from the cache, which does not keep the password
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		env.LoginHandler(w, httptest.NewRequest("POST", "https://ftpsvc.dev.run/login/", strings.NewReader("{\"username\": \"Test\", \"password\": \"pass\"}")))
+
+		resp := w.Result()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d but received %d", http.StatusOK, resp.StatusCode)
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		if !strings.Contains(string(respBody), "\"password\":\"pass\"") {
+			t.Errorf("Expected the password in the body but received %s", respBody)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	env.LoginHandler(w, httptest.NewRequest("POST", "https://ftpsvc.dev.run/login/", strings.NewReader("{\"username\": \"Test\", \"password\": \"wrong\"}")))
+	if status := w.Result().StatusCode; status != http.StatusUnauthorized {
+		t.Errorf("Expected status %d but received %d", http.StatusUnauthorized, status)
+	}
+}
+
+// blockingHistoryStore - a Datastore whose login history writes wait until released
+type blockingHistoryStore struct {
+	*memory.Store
+	release chan struct{}
+}
+
+func (s *blockingHistoryStore) LoginAttemptCreate(ctx context.Context, attempt data.LoginAttempt) error {
+	<-s.release
+	return s.Store.LoginAttemptCreate(ctx, attempt)
+}
+
+func TestLoginRecorder(t *testing.T) {
+	store := &blockingHistoryStore{Store: memory.New(), release: make(chan struct{})}
+	user, err := store.FtpUserCreate(context.Background(), data.FtpUser{Username: "recorded", Password: "secret"})
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserCreate %s", err)
+	}
+	if _, err := store.MappingCreate(context.Background(), data.NewMapping{System: data.LookupSystem, SystemID: "1", FTPAccountID: user.ID}); err != nil {
+		t.Fatalf("unexpected error from MappingCreate %s", err)
+	}
+
+	env := Env{Data: store, Logins: NewLoginRecorder(store, 1)}
+
+	// the first attempt is taken by the writer, the second waits in the queue and the third is dropped,
+	// none of them hold up the response
+	for _, password := range []string{"secret", "wrong", "wrong"} {
+		w := httptest.NewRecorder()
+		env.LoginHandler(w, httptest.NewRequest("POST", "https://ftpsvc.dev.run/login/", strings.NewReader("{\"username\": \"recorded\", \"password\": \""+password+"\", \"ip\": \"10.0.0.7\"}")))
+		if password == "secret" {
+			if status := w.Result().StatusCode; status != http.StatusOK {
+				t.Fatalf("Expected status %d but received %d", http.StatusOK, status)
+			}
+			for len(env.Logins.queue) != 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+
+	close(store.release)
+	env.Logins.Close()
+
+	attempts, err := store.LoginAttemptGetSelection(context.Background(), 0, 0, data.LoginAttemptFilter{Username: "recorded"})
+	if err != nil {
+		t.Fatalf("unexpected error from LoginAttemptGetSelection %s", err)
+	}
+	if attempts.TotalItems != 2 {
+		t.Errorf("expected two attempts recorded but received %d", attempts.TotalItems)
+	}
+
+	account, err := store.FtpUserGet(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("unexpected error from FtpUserGet %s", err)
+	}
+	if account.LastLoginAt == nil || account.LastLoginIP != "10.0.0.7" {
+		t.Errorf("expected the login to be recorded but received %v %s", account.LastLoginAt, account.LastLoginIP)
+	}
+}
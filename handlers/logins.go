@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/halt-joe/ftp-user-svc/apierror"
+	"github.com/halt-joe/ftp-user-svc/auth"
+	"github.com/halt-joe/ftp-user-svc/data"
+	"github.com/halt-joe/ftp-user-svc/metrics"
+)
+
+// LoginsGet - retrieves the login history within a specified page index and page size, most recent first
+//
+//	Responses:
+//	  - 200 Success
+//	  - 400 Bad Request
+//	  - 401 Unauthorized (Failed Authentication)
+//	  - 500 Error
+//
+//	Query Parameters:
+//	- page, page_size
+//	    the page index and page size
+//	- username
+//	    the username supplied with the login attempt
+//	- status
+//	    the outcome of the login attempt e.g. "bad_password"
+//	- since
+//	    an RFC 3339 time, only attempts at or after this time are returned
+//
+//	Response Body:
+//	  {
+//	    "logins": [
+//	      {"id":42,"attempted_at":"2022-05-04T10:15:00Z","username":"testuser","ftp_id":11,"ip":"10.0.0.7","protocol":"SFTP","status":"success","request_id":"..."},
+//	      ...
+//	    ],
+//	    "total_items": 245,
+//	    "total_pages": 9
+//	  }
+func (env *Env) LoginsGet(w http.ResponseWriter, r *http.Request) {
+	// setup error response
+	er := apierror.NewErrorResponse(w, r)
+
+	// Authenticate
+	if !auth.Authenticate(r) {
+		er.Status = http.StatusUnauthorized
+		er.Message = auth.ErrUnauthorized
+		er.WriteResponse()
+		return
+	}
+
+	var (
+		filter data.LoginAttemptFilter
+		err    error
+	)
+
+	filter.Username = r.FormValue("username")
+
+	if value := r.FormValue("status"); value != "" {
+		if !metrics.IsLoginStatus(value) {
+			er.Status = http.StatusBadRequest
+			er.Message = fmt.Sprintf(ErrInvalidQueryParam, value, "status")
+			er.WriteResponse()
+			return
+		}
+		filter.Status = value
+	}
+
+	if filter.Since, err = parseTimeParam(r, "since"); err != nil {
+		er.Status = http.StatusBadRequest
+		er.Message = fmt.Sprintf(ErrInvalidQueryParam, r.FormValue("since"), "since")
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	env.writeLoginAttempts(w, r, er, filter)
+}
+
+// IDLoginsGet - retrieves the login history of the ftp user account associated with the provided id
+//
+//	Responses:
+//	  - 200 Success
+//	  - 400 Bad Request
+//	  - 401 Unauthorized (Failed Authentication)
+//	  - 404 User Not Found
+//	  - 500 Error
+//
+//	Request Path Parameters:
+//	  /ftpusers/{id}/logins
+//	- id
+//	    the id of the ftp user entry
+//
+//	Query Parameters:
+//	- page, page_size
+//	    the page index and page size
+//
+//	Response Body:
+//	  the same as LoginsGet
+func (env *Env) IDLoginsGet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	env.idLoginsGetWithVars(w, r, vars)
+}
+
+func (env *Env) idLoginsGetWithVars(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	// setup error response
+	er := apierror.NewErrorResponse(w, r)
+
+	// Authenticate
+	if !auth.Authenticate(r) {
+		er.Status = http.StatusUnauthorized
+		er.Message = auth.ErrUnauthorized
+		er.WriteResponse()
+		return
+	}
+
+	id, err := strconv.ParseInt(params["id"], 10, 32)
+	if err != nil {
+		er.Status = http.StatusBadRequest
+		er.Message = fmt.Sprintf(ErrFTPUserIDConversion, params["id"])
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	if id < 1 {
+		er.Status = http.StatusBadRequest
+		er.Message = ErrInvalidFTPUserID
+		er.WriteResponse()
+		return
+	}
+
+	// the account must exist for its history to be requested
+	_, err = env.Data.FtpUserGet(r.Context(), uint32(id))
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	env.writeLoginAttempts(w, r, er, data.LoginAttemptFilter{FTPAccountID: uint32(id)})
+}
+
+// writeLoginAttempts - read the page parameters and write the matching login history to the response
+func (env *Env) writeLoginAttempts(w http.ResponseWriter, r *http.Request, er apierror.ErrorResponse, filter data.LoginAttemptFilter) {
+	page, err := parseUint32Param(r, "page")
+	if err != nil {
+		er.Status = http.StatusBadRequest
+		er.Message = fmt.Sprintf(ErrInvalidQueryParam, r.FormValue("page"), "page")
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	pageSize, err := parseUint32Param(r, "page_size")
+	if err != nil {
+		er.Status = http.StatusBadRequest
+		er.Message = fmt.Sprintf(ErrInvalidQueryParam, r.FormValue("page_size"), "page_size")
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	attempts, err := env.Data.LoginAttemptGetSelection(r.Context(), page, pageSize, filter)
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	output, err := json.Marshal(attempts)
+	if err != nil {
+		er.Status = http.StatusInternalServerError
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(output)
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/halt-joe/ftp-user-svc/data"
+)
+
+func (mdb *mockDB) LoginAttemptCreate(ctx context.Context, attempt data.LoginAttempt) error {
+	return nil
+}
+func (mdb *mockDB) LoginAttemptGetSelection(ctx context.Context, page uint32, pageSize uint32, filter data.LoginAttemptFilter) (data.LoginAttempts, error) {
+	var result data.LoginAttempts
+
+	attempt := data.LoginAttempt{
+		ID:           1,
+		AttemptedAt:  time.Date(2022, 5, 4, 10, 15, 0, 0, time.UTC),
+		Username:     "Test",
+		FTPAccountID: 987,
+		IP:           "10.0.0.7",
+		Protocol:     "SFTP",
+		Status:       "success",
+		RequestID:    "request-1",
+	}
+
+	if (filter.Username == "" || filter.Username == attempt.Username) &&
+		(filter.FTPAccountID == 0 || filter.FTPAccountID == attempt.FTPAccountID) &&
+		(filter.Status == "" || filter.Status == attempt.Status) {
+		result.Logins = append(result.Logins, attempt)
+		result.TotalItems = 1
+		result.TotalPages = 1
+	}
+
+	return result, nil
+}
+func (mdb *mockDB) LoginAttemptPurge(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestLoginsGet(t *testing.T) {
+	attemptBody := "{\"logins\":[{\"id\":1,\"attempted_at\":\"2022-05-04T10:15:00Z\",\"username\":\"Test\",\"ftp_id\":987,\"ip\":\"10.0.0.7\",\"protocol\":\"SFTP\",\"status\":\"success\",\"request_id\":\"request-1\"}],\"total_items\":1,\"total_pages\":1}"
+
+	type args struct {
+		w              *httptest.ResponseRecorder
+		r              *http.Request
+		expectedStatus int
+		expectedBody   string
+	}
+	tests := []struct {
+		name string
+		args func(t *testing.T) args
+	}{
+		{
+			name: "Test logins GET by username",
+			args: func(t *testing.T) args {
+				return args{
+					w:              httptest.NewRecorder(),
+					r:              httptest.NewRequest("GET", "https://ftpsvc.dev.run/logins?username=Test", nil),
+					expectedStatus: http.StatusOK,
+					expectedBody:   attemptBody,
+				}
+			},
+		},
+		{
+			name: "Test logins GET no matches",
+			args: func(t *testing.T) args {
+				return args{
+					w:              httptest.NewRecorder(),
+					r:              httptest.NewRequest("GET", "https://ftpsvc.dev.run/logins?status=bad_password", nil),
+					expectedStatus: http.StatusOK,
+					expectedBody:   "{}",
+				}
+			},
+		},
+		{
+			name: "Test logins GET invalid status",
+			args: func(t *testing.T) args {
+				return args{
+					w:              httptest.NewRecorder(),
+					r:              httptest.NewRequest("GET", "https://ftpsvc.dev.run/logins?status=nope", nil),
+					expectedStatus: http.StatusBadRequest,
+					expectedBody:   "{\"status\":400,\"location\":\"handlers.(*Env).LoginsGet\",\"message\":\"" + fmt.Sprintf(ErrInvalidQueryParam, "nope", "status") + "\",\"error\":\"\"}",
+				}
+			},
+		},
+	}
+
+	env := Env{Data: &mockDB{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tArgs := tt.args(t)
+
+			env.LoginsGet(tArgs.w, tArgs.r)
+			resp := tArgs.w.Result()
+			if resp.StatusCode != tArgs.expectedStatus {
+				t.Errorf("Expected status %d but received %d", tArgs.expectedStatus, resp.StatusCode)
+			}
+			respBody, _ := io.ReadAll(resp.Body)
+			body := string(respBody)
+			if tArgs.expectedBody != body {
+				t.Errorf("Expected body of %s but received %s", tArgs.expectedBody, body)
+			}
+		})
+	}
+}
+
+func TestIDLoginsGet(t *testing.T) {
+	type args struct {
+		w              *httptest.ResponseRecorder
+		r              *http.Request
+		params         map[string]string
+		expectedStatus int
+	}
+	tests := []struct {
+		name string
+		args func(t *testing.T) args
+	}{
+		{
+			name: "Test ftp user logins GET Success",
+			args: func(t *testing.T) args {
+				return args{
+					w:              httptest.NewRecorder(),
+					r:              httptest.NewRequest("GET", "https://ftpsvc.dev.run/ftpusers/987/logins", nil),
+					params:         map[string]string{"id": "987"},
+					expectedStatus: http.StatusOK,
+				}
+			},
+		},
+		{
+			name: "Test ftp user logins GET Bad Request",
+			args: func(t *testing.T) args {
+				return args{
+					w:              httptest.NewRecorder(),
+					r:              httptest.NewRequest("GET", "https://ftpsvc.dev.run/ftpusers/abc/logins", nil),
+					params:         map[string]string{"id": "abc"},
+					expectedStatus: http.StatusBadRequest,
+				}
+			},
+		},
+	}
+
+	env := Env{Data: &mockDB{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tArgs := tt.args(t)
+
+			env.idLoginsGetWithVars(tArgs.w, tArgs.r, tArgs.params)
+			resp := tArgs.w.Result()
+			if resp.StatusCode != tArgs.expectedStatus {
+				t.Errorf("Expected status %d but received %d", tArgs.expectedStatus, resp.StatusCode)
+			}
+		})
+	}
+}
@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/halt-joe/ftp-user-svc/apierror"
@@ -25,6 +28,7 @@ const (
 //	  - 204 successfully deleted
 //	  - 401 Unauthorized (Failed Authentication)
 //	  - 404 system id not found
+//	  - 412 Precondition Failed (If-Match does not list the current ETag)
 //	  - 500 Error
 //
 //	Request Path Parameters:
@@ -49,11 +53,39 @@ func (env *Env) SystemIDDelete(w http.ResponseWriter, r *http.Request) {
 	system := params["system"]
 	id := params["id"]
 
-	rows, err := env.Data.MappingDelete(system, id)
+	// the snapshot, the write and its audit entry share a transaction, so the snapshot is read from the primary
+	// rather than a replica that may lag behind it and If-Match is checked against the version changed
+	tx, err := env.Data.Begin(r.Context())
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+	defer tx.Rollback()
 
+	// snapshot the mapping for the audit log
+	before, err := tx.MappingRetrieve(r.Context(), system, id)
 	if err != nil {
-		er.Status = http.StatusInternalServerError
-		er.Err = err
+		if errors.Is(err, data.ErrMappingNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	version, ok := checkIfMatch(r, before.UpdatedOn)
+	if !ok {
+		er.Status = http.StatusPreconditionFailed
+		er.Message = ErrPreconditionFailed
+		er.WriteResponse()
+		return
+	}
+
+	rows, err := tx.MappingDelete(r.Context(), system, id, version)
+	if err != nil {
+		setDataError(&er, err)
 		er.WriteResponse()
 		return
 	}
@@ -63,6 +95,20 @@ func (env *Env) SystemIDDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = audit(tx, r, er.RequestID, data.AuditActionDelete, data.AuditEntityMapping, mappingEntityID(system, id), before, nil)
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -70,6 +116,7 @@ func (env *Env) SystemIDDelete(w http.ResponseWriter, r *http.Request) {
 //
 //	Responses:
 //	  - 200 Success
+//	  - 304 Not Modified (If-None-Match lists the current ETag)
 //	  - 401 Unauthorized (Failed Authentication)
 //	  - 404 system id not found
 //	  - 500 Error
@@ -80,6 +127,9 @@ func (env *Env) SystemIDDelete(w http.ResponseWriter, r *http.Request) {
 //	    the system that the mapping is associated with e.g. "BillSys1
 //	- id
 //	    the id in the {system} mapped to the ftp user
+//
+//	Response Headers:
+//	  ETag - the version of the mapping to send in If-Match when it is changed
 func (env *Env) SystemIDGet(w http.ResponseWriter, r *http.Request) {
 	// setup error response
 	er := apierror.NewErrorResponse(w, r)
@@ -96,21 +146,19 @@ func (env *Env) SystemIDGet(w http.ResponseWriter, r *http.Request) {
 	system := params["system"]
 	id := params["id"]
 
-	mapping, err := env.Data.MappingRetrieve(system, id)
+	mapping, err := env.Data.MappingRetrieve(r.Context(), system, id)
 	if err != nil {
-		e := err.Error()
-		if e == data.ErrMappingNotFound {
-			er.Status = http.StatusNotFound
-			er.Message = e
-			er.WriteResponse()
-			return
-		}
-		er.Status = http.StatusInternalServerError
-		er.Err = err
+		setDataError(&er, err)
 		er.WriteResponse()
 		return
 	}
 
+	setETag(w, mapping.UpdatedOn)
+	if notModified(r, mapping.UpdatedOn) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	output, err := json.Marshal(mapping)
 	if err != nil {
 		er.Status = http.StatusInternalServerError
@@ -134,6 +182,7 @@ func (env *Env) SystemIDGet(w http.ResponseWriter, r *http.Request) {
 //	  - 400 Bad Request
 //	  - 401 Unauthorized (Failed Authentication)
 //	  - 404 The requested ftp_id does not exist
+//	  - 412 Precondition Failed (If-Match does not list the current ETag of the mapping)
 //	  - 500 Error
 //
 //	Request:
@@ -147,6 +196,12 @@ func (env *Env) SystemIDGet(w http.ResponseWriter, r *http.Request) {
 //	    the id in the {system} mapped to the ftp user
 //	- ftp_id
 //	    the id of the ftp account to map
+//
+//	Response Headers:
+//	  ETag - the version of the created or updated mapping to send in If-Match when it is next changed
+//
+//	Response Body (201):
+//	  {"system":"BillSys1","id":"999","ftp_account":{"id":7,"username":"testuser","description":"test description"},"updated_on":"2022-05-04T10:15:00Z"}
 func (env *Env) SystemPost(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	env.systemPostWithVars(w, r, vars)
@@ -194,10 +249,40 @@ func (env *Env) systemPostWithVars(w http.ResponseWriter, r *http.Request, param
 		return
 	}
 
-	result, err := env.Data.MappingCreate(mapping)
+	// the snapshot, the write and its audit entry share a transaction, so the snapshot is read from the primary
+	// rather than a replica that may lag behind it and If-Match is checked against the version changed
+	tx, err := env.Data.Begin(r.Context())
 	if err != nil {
-		er.Status = http.StatusInternalServerError
-		er.Err = err
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+	defer tx.Rollback()
+
+	// snapshot the mapping for the audit log, it is created if it does not exist
+	var before interface{}
+	existing, err := tx.MappingRetrieve(r.Context(), mapping.System, mapping.SystemID)
+	if err == nil {
+		before = existing
+	} else if !errors.Is(err, data.ErrMappingNotFound) {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	// If-Match can only be satisfied by an existing mapping
+	version, ok := checkIfMatch(r, existing.UpdatedOn)
+	if !ok || (before == nil && r.Header.Get("If-Match") != "") {
+		er.Status = http.StatusPreconditionFailed
+		er.Message = ErrPreconditionFailed
+		er.WriteResponse()
+		return
+	}
+	mapping.UpdatedOn = version
+
+	result, err := tx.MappingCreate(r.Context(), mapping)
+	if err != nil {
+		setDataError(&er, err)
 		er.WriteResponse()
 		return
 	}
@@ -207,22 +292,33 @@ func (env *Env) systemPostWithVars(w http.ResponseWriter, r *http.Request, param
 		w.WriteHeader(http.StatusNotFound)
 		return
 
+	case data.MappingModified:
+		er.Status = http.StatusPreconditionFailed
+		er.Message = ErrPreconditionFailed
+		er.WriteResponse()
+		return
+
 	case data.MappingInserted:
-		user, err := env.Data.FtpUserGet(mapping.FTPAccountID)
+		result, err := tx.MappingRetrieve(r.Context(), mapping.System, mapping.SystemID)
 		if err != nil {
-			er.Status = http.StatusInternalServerError
-			er.Err = err
+			setDataError(&er, err)
 			er.WriteResponse()
 			return
 		}
 
-		var result data.Mapping
-		result.ID = mapping.SystemID
-		result.System = mapping.System
-		result.FTPAccount.ID = user.ID
-		result.FTPAccount.Username = user.Username
-		result.FTPAccount.Description = user.Description
-		result.FTPAccount.Password = ""
+		err = audit(tx, r, er.RequestID, data.AuditActionCreate, data.AuditEntityMapping, mappingEntityID(mapping.System, mapping.SystemID), nil, result)
+		if err != nil {
+			setDataError(&er, err)
+			er.WriteResponse()
+			return
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			setDataError(&er, err)
+			er.WriteResponse()
+			return
+		}
 
 		output, err := json.Marshal(result)
 		if err != nil {
@@ -232,30 +328,78 @@ func (env *Env) systemPostWithVars(w http.ResponseWriter, r *http.Request, param
 			return
 		}
 
+		setETag(w, result.UpdatedOn)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
 		w.Write(output)
 		return
 
 	case data.MappingUpdated:
+		after, err := tx.MappingRetrieve(r.Context(), mapping.System, mapping.SystemID)
+		if err != nil {
+			setDataError(&er, err)
+			er.WriteResponse()
+			return
+		}
+
+		err = audit(tx, r, er.RequestID, data.AuditActionUpdate, data.AuditEntityMapping, mappingEntityID(mapping.System, mapping.SystemID), before, after)
+		if err != nil {
+			setDataError(&er, err)
+			er.WriteResponse()
+			return
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			setDataError(&er, err)
+			er.WriteResponse()
+			return
+		}
+
+		setETag(w, after.UpdatedOn)
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 }
 
-// SystemGet - retieves the system_id and username pairs related to the provided system
+// systemMapMediaType - the Accept media type that selects the system_id to username map response of SystemGet
+const systemMapMediaType = "application/vnd.ftp-user-svc.system-map+json"
+
+// SystemGet - retrieves the mappings related to the provided system, ordered by system id
 //
 //	Responses:
 //	  - 200 OK
 //	  - 400 Bad Request
 //	  - 401 Unauthorized (Failed Authentication)
-//	  - 404 The provided system does not exist
+//	  - 404 The provided system does not exist (map response only)
 //	  - 500 Error
 //
 //	Request:
 //	  /mappings/{system}
 //	- system
-//	    the system that the system_id and username pairs are associated with e.g. "BillSys1
+//	    the system that the mappings are associated with e.g. "BillSys1
+//
+//	Query Parameters:
+//	- page, page_size
+//	    the page index and page size
+//	- id_prefix
+//	    only return the mappings whose system id starts with it
+//	- format
+//	    "map" returns every system_id and username pair instead, as does an Accept header of
+//	    application/vnd.ftp-user-svc.system-map+json
+//
+//	Response Body:
+//	  {
+//	    "mappings": [
+//	      {"system":"BillSys1","id":"1234","ftp_account":{"id":11,"username":"testuser","description":"test description"},"updated_on":"2022-05-04T10:15:00Z"},
+//	      ...
+//	    ],
+//	    "total_items": 2,
+//	    "total_pages": 1
+//	  }
+//
+//	Map Response Body:
+//	  {"1234":"testuser","1235":"otheruser"}
 func (env *Env) SystemGet(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	env.systemGetWithVars(w, r, vars)
@@ -276,17 +420,20 @@ func (env *Env) systemGetWithVars(w http.ResponseWriter, r *http.Request, params
 	system := params["system"]
 
 	if system == "" {
-		fmt.Printf("params: %s", params)
 		er.Status = http.StatusBadRequest
 		er.Message = ErrSystemRequired
 		er.WriteResponse()
 		return
 	}
 
-	result, err := env.Data.SystemIDUserRetrieve(system)
+	if !wantsSystemMap(r) {
+		env.writeMappings(w, r, er, data.MappingFilter{System: system, IDPrefix: r.FormValue("id_prefix")})
+		return
+	}
+
+	result, err := env.Data.SystemIDUserRetrieve(r.Context(), system)
 	if err != nil {
-		er.Status = http.StatusInternalServerError
-		er.Err = err
+		setDataError(&er, err)
 		er.WriteResponse()
 		return
 	}
@@ -310,3 +457,131 @@ func (env *Env) systemGetWithVars(w http.ResponseWriter, r *http.Request, params
 	w.WriteHeader(http.StatusOK)
 	w.Write(output)
 }
+
+// wantsSystemMap - whether the request asks for the system_id to username map of SystemGet
+func wantsSystemMap(r *http.Request) bool {
+	if r.FormValue("format") == "map" {
+		return true
+	}
+
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaType := range strings.Split(accept, ",") {
+			if strings.TrimSpace(strings.Split(mediaType, ";")[0]) == systemMapMediaType {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// IDMappingsGet - retrieves the mappings of the ftp user account associated with the provided id, ordered by system and id
+//
+//	Responses:
+//	  - 200 Success
+//	  - 400 Bad Request
+//	  - 401 Unauthorized (Failed Authentication)
+//	  - 404 User Not Found
+//	  - 500 Error
+//
+//	Request Path Parameters:
+//	  /ftpusers/{id}/mappings
+//	- id
+//	    the id of the ftp user entry
+//
+//	Query Parameters:
+//	- page, page_size
+//	    the page index and page size
+//
+//	Response Body:
+//	  {
+//	    "mappings": [
+//	      {"system":"BillSys1","id":"1234","ftp_account":{"id":11,"username":"testuser","description":"test description"},"updated_on":"2022-05-04T10:15:00Z"},
+//	      ...
+//	    ],
+//	    "total_items": 2,
+//	    "total_pages": 1
+//	  }
+func (env *Env) IDMappingsGet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	env.idMappingsGetWithVars(w, r, vars)
+}
+
+func (env *Env) idMappingsGetWithVars(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	// setup error response
+	er := apierror.NewErrorResponse(w, r)
+
+	// Authenticate
+	if !auth.Authenticate(r) {
+		er.Status = http.StatusUnauthorized
+		er.Message = auth.ErrUnauthorized
+		er.WriteResponse()
+		return
+	}
+
+	id, err := strconv.ParseInt(params["id"], 10, 32)
+	if err != nil {
+		er.Status = http.StatusBadRequest
+		er.Message = fmt.Sprintf(ErrFTPUserIDConversion, params["id"])
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	if id < 1 {
+		er.Status = http.StatusBadRequest
+		er.Message = ErrInvalidFTPUserID
+		er.WriteResponse()
+		return
+	}
+
+	// the account must exist for its mappings to be requested
+	_, err = env.Data.FtpUserGet(r.Context(), uint32(id))
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	env.writeMappings(w, r, er, data.MappingFilter{FTPAccountID: uint32(id)})
+}
+
+// writeMappings - read the page parameters and write the matching mappings to the response
+func (env *Env) writeMappings(w http.ResponseWriter, r *http.Request, er apierror.ErrorResponse, filter data.MappingFilter) {
+	page, err := parseUint32Param(r, "page")
+	if err != nil {
+		er.Status = http.StatusBadRequest
+		er.Message = fmt.Sprintf(ErrInvalidQueryParam, r.FormValue("page"), "page")
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	pageSize, err := parseUint32Param(r, "page_size")
+	if err != nil {
+		er.Status = http.StatusBadRequest
+		er.Message = fmt.Sprintf(ErrInvalidQueryParam, r.FormValue("page_size"), "page_size")
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	mappings, err := env.Data.MappingGetSelection(r.Context(), page, pageSize, filter)
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	output, err := json.Marshal(mappings)
+	if err != nil {
+		er.Status = http.StatusInternalServerError
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(output)
+}
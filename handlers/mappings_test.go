@@ -1,12 +1,18 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/halt-joe/ftp-user-svc/data"
+	"github.com/halt-joe/ftp-user-svc/data/memory"
 )
 
 func TestSystemPost(t *testing.T) {
@@ -31,7 +37,7 @@ func TestSystemPost(t *testing.T) {
 					w:              httptest.NewRecorder(),
 					r:              httptest.NewRequest("POST", "https://ftpsvc.dev.run/mappings/BillSys1", strings.NewReader(PostBody)),
 					expectedStatus: 201,
-					expectedBody:   "{\"system\":\"BillSys1\",\"id\":\"123\",\"ftp_account\":{\"id\":987,\"username\":\"Test\",\"description\":\"A test user\"}}",
+					expectedBody:   "{\"system\":\"BillSys1\",\"id\":\"123\",\"ftp_account\":{\"id\":987,\"username\":\"Test\",\"description\":\"A test user\"},\"updated_on\":\"2022-05-04T10:15:00Z\"}",
 				}
 			},
 		},
@@ -52,11 +58,14 @@ func TestSystemPost(t *testing.T) {
 			if tArgs.expectedBody != body {
 				t.Errorf("Expected body of %s but received %s", tArgs.expectedBody, body)
 			}
+			if tag := resp.Header.Get("ETag"); tag != etag(&mockUpdatedOn) {
+				t.Errorf("Expected ETag %s but received %s", etag(&mockUpdatedOn), tag)
+			}
 		})
 	}
 }
 
-func (mdb *mockDB) SystemIDUserRetrieve(system string) (map[string]string, error) {
+func (mdb *mockDB) SystemIDUserRetrieve(ctx context.Context, system string) (map[string]string, error) {
 	var result map[string]string
 
 	if system == "BillSys1" {
@@ -79,18 +88,59 @@ func TestSystemGet(t *testing.T) {
 		args func(t *testing.T) args
 	}{
 		{
-			name: "[ch3193] Test mappings GET Success",
+			name: "[ch3193] Test mappings GET map Success",
 			args: func(t *testing.T) args {
 				system := "BillSys1"
 				return args{
 					w:              httptest.NewRecorder(),
-					r:              httptest.NewRequest("GET", "https://ftpsvc.dev.run/mappings/"+system, nil),
+					r:              httptest.NewRequest("GET", "https://ftpsvc.dev.run/mappings/"+system+"?format=map", nil),
 					params:         map[string]string{"system": system},
 					expectedStatus: http.StatusOK,
 					expectedBody:   "{\"system_id1\":\"username1\",\"system_id2\":\"username2\"}",
 				}
 			},
 		},
+		{
+			name: "Test mappings GET map Accept header",
+			args: func(t *testing.T) args {
+				system := "BillSys1"
+				r := httptest.NewRequest("GET", "https://ftpsvc.dev.run/mappings/"+system, nil)
+				r.Header.Set("Accept", "text/plain, "+systemMapMediaType+";q=0.9")
+				return args{
+					w:              httptest.NewRecorder(),
+					r:              r,
+					params:         map[string]string{"system": system},
+					expectedStatus: http.StatusOK,
+					expectedBody:   "{\"system_id1\":\"username1\",\"system_id2\":\"username2\"}",
+				}
+			},
+		},
+		{
+			name: "Test mappings GET page",
+			args: func(t *testing.T) args {
+				system := "BillSys1"
+				return args{
+					w:              httptest.NewRecorder(),
+					r:              httptest.NewRequest("GET", "https://ftpsvc.dev.run/mappings/"+system, nil),
+					params:         map[string]string{"system": system},
+					expectedStatus: http.StatusOK,
+					expectedBody:   "{\"mappings\":[{\"system\":\"BillSys1\",\"id\":\"system_id1\",\"ftp_account\":{\"id\":987,\"username\":\"Test\"},\"updated_on\":\"2022-05-04T10:15:00Z\"}],\"total_items\":1,\"total_pages\":1}",
+				}
+			},
+		},
+		{
+			name: "Test mappings GET empty page",
+			args: func(t *testing.T) args {
+				system := "CV3"
+				return args{
+					w:              httptest.NewRecorder(),
+					r:              httptest.NewRequest("GET", "https://ftpsvc.dev.run/mappings/"+system, nil),
+					params:         map[string]string{"system": system},
+					expectedStatus: http.StatusOK,
+					expectedBody:   "{}",
+				}
+			},
+		},
 		{
 			name: "[ch3193] Test mappings GET Bad Request",
 			args: func(t *testing.T) args {
@@ -105,12 +155,12 @@ func TestSystemGet(t *testing.T) {
 			},
 		},
 		{
-			name: "[ch3193] Test mappings GET Not Found",
+			name: "[ch3193] Test mappings GET map Not Found",
 			args: func(t *testing.T) args {
 				system := "CV3"
 				return args{
 					w:              httptest.NewRecorder(),
-					r:              httptest.NewRequest("GET", "https://ftpsvc.dev.run/mappings/"+system, nil),
+					r:              httptest.NewRequest("GET", "https://ftpsvc.dev.run/mappings/"+system+"?format=map", nil),
 					params:         map[string]string{"system": system},
 					expectedStatus: http.StatusNotFound,
 					expectedBody:   "{\"status\":404,\"location\":\"handlers.(*Env).systemGetWithVars\",\"message\":\"" + fmt.Sprintf(ErrSystemNotFound, system) + "\",\"error\":\"\"}",
@@ -137,3 +187,201 @@ func TestSystemGet(t *testing.T) {
 		})
 	}
 }
+
+func (mdb *mockDB) MappingGetSelection(ctx context.Context, page uint32, pageSize uint32, filter data.MappingFilter) (data.Mappings, error) {
+	var result data.Mappings
+
+	updatedOn := time.Date(2022, 5, 4, 10, 15, 0, 0, time.UTC)
+	mapping := data.Mapping{System: "BillSys1", ID: "system_id1", FTPAccount: data.FtpUser{ID: 987, Username: "Test"}, UpdatedOn: &updatedOn}
+
+	if (filter.FTPAccountID == 0 || filter.FTPAccountID == mapping.FTPAccount.ID) && (filter.System == "" || filter.System == mapping.System) {
+		result.Mappings = append(result.Mappings, mapping)
+		result.TotalItems = 1
+		result.TotalPages = 1
+	}
+
+	return result, nil
+}
+
+func TestIDMappingsGet(t *testing.T) {
+	type args struct {
+		w              *httptest.ResponseRecorder
+		r              *http.Request
+		params         map[string]string
+		expectedStatus int
+		expectedBody   string
+	}
+	tests := []struct {
+		name string
+		args func(t *testing.T) args
+	}{
+		{
+			name: "Test ftp user mappings GET Success",
+			args: func(t *testing.T) args {
+				return args{
+					w:              httptest.NewRecorder(),
+					r:              httptest.NewRequest("GET", "https://ftpsvc.dev.run/ftpusers/987/mappings", nil),
+					params:         map[string]string{"id": "987"},
+					expectedStatus: http.StatusOK,
+					expectedBody:   "{\"mappings\":[{\"system\":\"BillSys1\",\"id\":\"system_id1\",\"ftp_account\":{\"id\":987,\"username\":\"Test\"},\"updated_on\":\"2022-05-04T10:15:00Z\"}],\"total_items\":1,\"total_pages\":1}",
+				}
+			},
+		},
+		{
+			name: "Test ftp user mappings GET Bad Request",
+			args: func(t *testing.T) args {
+				return args{
+					w:              httptest.NewRecorder(),
+					r:              httptest.NewRequest("GET", "https://ftpsvc.dev.run/ftpusers/abc/mappings", nil),
+					params:         map[string]string{"id": "abc"},
+					expectedStatus: http.StatusBadRequest,
+				}
+			},
+		},
+		{
+			name: "Test ftp user mappings GET Bad Page",
+			args: func(t *testing.T) args {
+				return args{
+					w:              httptest.NewRecorder(),
+					r:              httptest.NewRequest("GET", "https://ftpsvc.dev.run/ftpusers/987/mappings?page=first", nil),
+					params:         map[string]string{"id": "987"},
+					expectedStatus: http.StatusBadRequest,
+				}
+			},
+		},
+	}
+
+	env := Env{Data: &mockDB{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tArgs := tt.args(t)
+
+			env.idMappingsGetWithVars(tArgs.w, tArgs.r, tArgs.params)
+			resp := tArgs.w.Result()
+			if resp.StatusCode != tArgs.expectedStatus {
+				t.Errorf("Expected status %d but received %d", tArgs.expectedStatus, resp.StatusCode)
+			}
+			respBody, _ := io.ReadAll(resp.Body)
+			if body := string(respBody); tArgs.expectedBody != "" && tArgs.expectedBody != body {
+				t.Errorf("Expected body of %s but received %s", tArgs.expectedBody, body)
+			}
+		})
+	}
+}
+
+func TestIDMappingsGetPages(t *testing.T) {
+	store := memory.New()
+	env := Env{Data: store}
+
+	user, _ := store.FtpUserCreate(context.Background(), data.FtpUser{Username: "mapped", Password: "secret"})
+	other, _ := store.FtpUserCreate(context.Background(), data.FtpUser{Username: "other", Password: "secret"})
+	for _, mapping := range []data.NewMapping{
+		{System: "SysB", SystemID: "1", FTPAccountID: user.ID},
+		{System: "SysA", SystemID: "2", FTPAccountID: user.ID},
+		{System: "SysA", SystemID: "1", FTPAccountID: user.ID},
+		{System: "SysA", SystemID: "3", FTPAccountID: other.ID},
+	} {
+		_, _ = store.MappingCreate(context.Background(), mapping)
+	}
+
+	get := func(id uint32, query string) (*http.Response, data.Mappings) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		env.idMappingsGetWithVars(w, httptest.NewRequest("GET", fmt.Sprintf("https://ftpsvc.dev.run/ftpusers/%d/mappings?%s", id, query), nil), map[string]string{"id": fmt.Sprint(id)})
+
+		var mappings data.Mappings
+		resp := w.Result()
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&mappings); err != nil {
+				t.Fatalf("unexpected error decoding the response %s", err)
+			}
+		}
+		return resp, mappings
+	}
+
+	var keys []string
+	for _, query := range []string{"page_size=2", "page=2&page_size=2"} {
+		resp, mappings := get(user.ID, query)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d but received %d", http.StatusOK, resp.StatusCode)
+		}
+		if mappings.TotalItems != 3 || mappings.TotalPages != 2 {
+			t.Errorf("expected 3 items on 2 pages but received %d items on %d pages", mappings.TotalItems, mappings.TotalPages)
+		}
+		for _, mapping := range mappings.Mappings {
+			if mapping.FTPAccount.ID != user.ID || mapping.FTPAccount.Username != "mapped" {
+				t.Errorf("unexpected account %+v returned", mapping.FTPAccount)
+			}
+			keys = append(keys, mapping.System+"/"+mapping.ID)
+		}
+	}
+	if expected := "[SysA/1 SysA/2 SysB/1]"; fmt.Sprint(keys) != expected {
+		t.Errorf("Expected %s but received %v", expected, keys)
+	}
+
+	if resp, _ := get(999, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d but received %d", http.StatusNotFound, resp.StatusCode)
+	}
+	_ = store.FtpUserDelete(context.Background(), user.ID, nil)
+	if resp, _ := get(user.ID, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d for a deleted account but received %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestSystemGetPages(t *testing.T) {
+	store := memory.New()
+	env := Env{Data: store}
+
+	user, _ := store.FtpUserCreate(context.Background(), data.FtpUser{Username: "mapped", Password: "secret", Description: "Mapped account"})
+	for _, mapping := range []data.NewMapping{
+		{System: "SysA", SystemID: "20", FTPAccountID: user.ID},
+		{System: "SysA", SystemID: "10", FTPAccountID: user.ID},
+		{System: "SysA", SystemID: "11", FTPAccountID: user.ID},
+		{System: "SysA", SystemID: "1%", FTPAccountID: user.ID},
+		{System: "SysB", SystemID: "12", FTPAccountID: user.ID},
+	} {
+		_, _ = store.MappingCreate(context.Background(), mapping)
+	}
+
+	tests := []struct {
+		query    string
+		expected string
+		expTotal uint32
+		expPages uint32
+	}{
+		{query: "page_size=2", expected: "[SysA/1% SysA/10]", expTotal: 4, expPages: 2},
+		{query: "page=2&page_size=2", expected: "[SysA/11 SysA/20]", expTotal: 4, expPages: 2},
+		{query: "id_prefix=1", expected: "[SysA/1% SysA/10 SysA/11]", expTotal: 3, expPages: 1},
+		{query: "id_prefix=1%25", expected: "[SysA/1%]", expTotal: 1, expPages: 1},
+		{query: "id_prefix=3", expected: "[]", expTotal: 0, expPages: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			env.systemGetWithVars(w, httptest.NewRequest("GET", "https://ftpsvc.dev.run/mappings/SysA?"+tt.query, nil), map[string]string{"system": "SysA"})
+			resp := w.Result()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected status %d but received %d", http.StatusOK, resp.StatusCode)
+			}
+
+			var mappings data.Mappings
+			if err := json.NewDecoder(resp.Body).Decode(&mappings); err != nil {
+				t.Fatalf("unexpected error decoding the response %s", err)
+			}
+			keys := []string{}
+			for _, mapping := range mappings.Mappings {
+				if mapping.FTPAccount.ID != user.ID || mapping.FTPAccount.Description != "Mapped account" || mapping.UpdatedOn == nil {
+					t.Errorf("unexpected mapping %+v returned", mapping)
+				}
+				keys = append(keys, mapping.System+"/"+mapping.ID)
+			}
+			if fmt.Sprint(keys) != tt.expected {
+				t.Errorf("Expected %s but received %v", tt.expected, keys)
+			}
+			if mappings.TotalItems != tt.expTotal || mappings.TotalPages != tt.expPages {
+				t.Errorf("expected %d items on %d pages but received %d items on %d pages", tt.expTotal, tt.expPages, mappings.TotalItems, mappings.TotalPages)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/halt-joe/ftp-user-svc/data"
+	"github.com/halt-joe/ftp-user-svc/metrics"
+	log "github.com/inconshreveable/log15"
+)
+
+// loginRecord - a login attempt waiting to be written, success also records the login of the account
+type loginRecord struct {
+	attempt data.LoginAttempt
+	success bool
+}
+
+// LoginRecorder - writes login attempts and successful logins off the request path
+// - a slow database delays the records rather than the logins, and when size records are waiting
+// further records are dropped and counted by the ftpusersvc_login_records_dropped_total metric
+type LoginRecorder struct {
+	store data.Datastore
+	queue chan loginRecord
+	done  chan struct{}
+}
+
+// NewLoginRecorder - a LoginRecorder writing to store that holds up to size records waiting to be written
+func NewLoginRecorder(store data.Datastore, size int) *LoginRecorder {
+	lr := &LoginRecorder{
+		store: store,
+		queue: make(chan loginRecord, size),
+		done:  make(chan struct{}),
+	}
+	go lr.run()
+
+	return lr
+}
+
+// Close - stop accepting records and wait for those waiting to be written
+func (lr *LoginRecorder) Close() {
+	close(lr.queue)
+	<-lr.done
+}
+
+// enqueue - queue record to be written, dropping it when the queue is full
+func (lr *LoginRecorder) enqueue(record loginRecord) {
+	select {
+	case lr.queue <- record:
+	default:
+		metrics.IncLoginRecordDropped()
+	}
+}
+
+// run - write the queued records until the recorder is closed
+func (lr *LoginRecorder) run() {
+	defer close(lr.done)
+
+	for record := range lr.queue {
+		writeLoginRecord(lr.store, record)
+	}
+}
+
+// writeLoginRecord - add the attempt of record to the login history and record the login of a success
+// - failures are logged, they should not change the outcome of the login
+// - the record is written even when the client has gone away, so it does not use the request context
+func writeLoginRecord(store data.Datastore, record loginRecord) {
+	attempt := record.attempt
+
+	err := store.LoginAttemptCreate(context.Background(), attempt)
+	if err != nil {
+		log.Error(attempt.RequestID+" unable to record login attempt", "user", attempt.Username, "error", err.Error())
+	}
+
+	if !record.success {
+		return
+	}
+
+	err = store.FtpUserRecordLogin(context.Background(), attempt.FTPAccountID, attempt.IP)
+	if err != nil {
+		log.Error(attempt.RequestID+" unable to record login", "user", attempt.Username, "error", err.Error())
+	}
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/halt-joe/ftp-user-svc/apierror"
+	"github.com/halt-joe/ftp-user-svc/auth"
+	"github.com/halt-joe/ftp-user-svc/data"
+)
+
+// systemRegistration - the request body of SystemPut and the audit snapshot of a registered system
+type systemRegistration struct {
+	System      string `json:"system,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// SystemsGet - retrieves every system that is registered or has mappings, with the number of mappings and accounts mapped in it
+//
+//	Responses:
+//	  - 200 Success
+//	  - 401 Unauthorized (Failed Authentication)
+//	  - 500 Error
+//
+//	Response Body:
+//	  {
+//	    "systems": [
+//	      {"system":"BillSys1","description":"Billing","registered":true,"registered_at":"2022-05-04T10:15:00Z","mappings":245,"ftp_accounts":240},
+//	      {"system":"BillSys2","registered":false,"mappings":3,"ftp_accounts":3},
+//	      ...
+//	    ]
+//	  }
+func (env *Env) SystemsGet(w http.ResponseWriter, r *http.Request) {
+	// setup error response
+	er := apierror.NewErrorResponse(w, r)
+
+	// Authenticate
+	if !auth.Authenticate(r) {
+		er.Status = http.StatusUnauthorized
+		er.Message = auth.ErrUnauthorized
+		er.WriteResponse()
+		return
+	}
+
+	systems, err := env.Data.SystemGetSelection(r.Context())
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	output, err := json.Marshal(systems)
+	if err != nil {
+		er.Status = http.StatusInternalServerError
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(output)
+}
+
+// SystemPut - registers the provided system, or updates the description of a registered system
+//
+//	Responses:
+//	  - 201 Created
+//	  - 204 No Content (the system was already registered)
+//	  - 400 Bad Request
+//	  - 401 Unauthorized (Failed Authentication)
+//	  - 500 Error
+//
+//	Request Path Parameters:
+//	  /systems/{system}
+//	- system
+//	    the system to register e.g. "BillSys1"
+//
+//	Request Body (optional):
+//	  {
+//	    "description": "Billing"
+//	  }
+func (env *Env) SystemPut(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	env.systemPutWithVars(w, r, vars)
+}
+
+func (env *Env) systemPutWithVars(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	// setup error response
+	er := apierror.NewErrorResponse(w, r)
+
+	// Authenticate
+	if !auth.Authenticate(r) {
+		er.Status = http.StatusUnauthorized
+		er.Message = auth.ErrUnauthorized
+		er.WriteResponse()
+		return
+	}
+
+	// Read Body
+	b, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		er.Status = http.StatusInternalServerError
+		er.Err = err
+		er.WriteResponse()
+		return
+	}
+
+	var registration systemRegistration
+	if len(b) > 0 {
+		if err = json.Unmarshal(b, &registration); err != nil {
+			er.Status = http.StatusInternalServerError
+			er.Err = err
+			er.WriteResponse()
+			return
+		}
+	}
+	registration.System = params["system"]
+
+	if registration.System == "" {
+		er.Status = http.StatusBadRequest
+		er.Message = ErrSystemRequired
+		er.WriteResponse()
+		return
+	}
+
+	// the registration is changed in one transaction with its audit entry
+	tx, err := env.Data.Begin(r.Context())
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+	defer tx.Rollback()
+
+	// snapshot the registration for the audit log, it is created if it does not exist
+	before, err := registeredSystem(tx, r, registration.System)
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	err = tx.SystemRegister(r.Context(), registration.System, registration.Description)
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	action, status := data.AuditActionUpdate, http.StatusNoContent
+	if before == nil {
+		action, status = data.AuditActionCreate, http.StatusCreated
+	}
+
+	err = audit(tx, r, er.RequestID, action, data.AuditEntitySystem, registration.System, before, registration)
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	w.WriteHeader(status)
+}
+
+// SystemDelete - removes the registration of the provided system, its mappings are kept
+//
+//	Responses:
+//	  - 204 No Content
+//	  - 401 Unauthorized (Failed Authentication)
+//	  - 404 The system is not registered
+//	  - 500 Error
+//
+//	Request Path Parameters:
+//	  /systems/{system}
+//	- system
+//	    the registered system e.g. "BillSys1"
+func (env *Env) SystemDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	env.systemDeleteWithVars(w, r, vars)
+}
+
+func (env *Env) systemDeleteWithVars(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	// setup error response
+	er := apierror.NewErrorResponse(w, r)
+
+	// Authenticate
+	if !auth.Authenticate(r) {
+		er.Status = http.StatusUnauthorized
+		er.Message = auth.ErrUnauthorized
+		er.WriteResponse()
+		return
+	}
+
+	system := params["system"]
+
+	// the registration is changed in one transaction with its audit entry
+	tx, err := env.Data.Begin(r.Context())
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := registeredSystem(tx, r, system)
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	err = tx.SystemUnregister(r.Context(), system)
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	err = audit(tx, r, er.RequestID, data.AuditActionDelete, data.AuditEntitySystem, system, before, nil)
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		setDataError(&er, err)
+		er.WriteResponse()
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// registeredSystem - the registration of system in store for the audit log, nil when it is not registered
+func registeredSystem(store data.Datastore, r *http.Request, system string) (interface{}, error) {
+	registered, err := store.SystemRegistrationGet(r.Context(), system)
+	if errors.Is(err, data.ErrSystemNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return systemRegistration{System: registered.Name, Description: registered.Description}, nil
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/halt-joe/ftp-user-svc/data"
+	"github.com/halt-joe/ftp-user-svc/data/memory"
+)
+
+func (mdb *mockDB) SystemGetSelection(ctx context.Context) (data.Systems, error) {
+	return data.Systems{}, errNotImplmented
+}
+func (mdb *mockDB) SystemRegistrationGet(ctx context.Context, system string) (data.System, error) {
+	return data.System{}, errNotImplmented
+}
+func (mdb *mockDB) SystemRegister(ctx context.Context, system string, description string) error {
+	return errNotImplmented
+}
+func (mdb *mockDB) SystemUnregister(ctx context.Context, system string) error {
+	return errNotImplmented
+}
+
+func TestSystems(t *testing.T) {
+	store := memory.New()
+	env := Env{Data: store}
+
+	user, _ := store.FtpUserCreate(context.Background(), data.FtpUser{Username: "mapped", Password: "secret"})
+	_, _ = store.MappingCreate(context.Background(), data.NewMapping{System: "BillSys2", SystemID: "1", FTPAccountID: user.ID})
+
+	put := func(system string, body string) int {
+		w := httptest.NewRecorder()
+		env.systemPutWithVars(w, httptest.NewRequest("PUT", "https://ftpsvc.dev.run/systems/"+system, strings.NewReader(body)), map[string]string{"system": system})
+		return w.Result().StatusCode
+	}
+	del := func(system string) int {
+		w := httptest.NewRecorder()
+		env.systemDeleteWithVars(w, httptest.NewRequest("DELETE", "https://ftpsvc.dev.run/systems/"+system, nil), map[string]string{"system": system})
+		return w.Result().StatusCode
+	}
+	get := func() string {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		env.SystemsGet(w, httptest.NewRequest("GET", "https://ftpsvc.dev.run/systems", nil))
+		resp := w.Result()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d but received %d", http.StatusOK, resp.StatusCode)
+		}
+
+		var systems data.Systems
+		if err := json.NewDecoder(resp.Body).Decode(&systems); err != nil {
+			t.Fatalf("unexpected error decoding the response %s", err)
+		}
+		var summaries []string
+		for _, system := range systems.Systems {
+			summaries = append(summaries, fmt.Sprintf("%s:%d/%d/%t/%s", system.Name, system.Mappings, system.FTPAccounts, system.Registered, system.Description))
+		}
+		return fmt.Sprint(summaries)
+	}
+
+	statuses := []struct {
+		name      string
+		status    int
+		expStatus int
+	}{
+		{name: "Register", status: put("BillSys1", ""), expStatus: http.StatusCreated},
+		{name: "Register with a description", status: put("BillSys2", `{"description":"Second billing"}`), expStatus: http.StatusCreated},
+		{name: "Update the description", status: put("BillSys1", `{"description":"Billing"}`), expStatus: http.StatusNoContent},
+		{name: "Register without a system", status: put("", ""), expStatus: http.StatusBadRequest},
+		{name: "Unregister", status: del("BillSys2"), expStatus: http.StatusNoContent},
+		{name: "Unregister again", status: del("BillSys2"), expStatus: http.StatusNotFound},
+	}
+	for _, tt := range statuses {
+		if tt.status != tt.expStatus {
+			t.Errorf("%s: Expected status %d but received %d", tt.name, tt.expStatus, tt.status)
+		}
+	}
+
+	if expected, systems := "[BillSys1:0/0/true/Billing BillSys2:1/1/false/]", get(); systems != expected {
+		t.Errorf("Expected systems %s but received %s", expected, systems)
+	}
+
+	entries, _ := store.AuditGetSelection(context.Background(), 0, 0, data.AuditFilter{Entity: data.AuditEntitySystem})
+	var actions []string
+	for _, entry := range entries.Entries {
+		if entry.Entity != data.AuditEntitySystem {
+			t.Errorf("Unexpected audit entity %s", entry.Entity)
+		}
+		actions = append(actions, entry.Action+" "+entry.EntityID)
+	}
+	if expected := "[delete BillSys2 update BillSys1 create BillSys2 create BillSys1]"; fmt.Sprint(actions) != expected {
+		t.Errorf("Expected audit entries %s but received %v", expected, actions)
+	}
+
+	t.Run("Require registered", func(t *testing.T) {
+		opts := data.DefaultOptions()
+		opts.RequireRegisteredSystems = true
+		store := memory.NewWithOptions(opts)
+		env := Env{Data: store}
+
+		user, _ := store.FtpUserCreate(context.Background(), data.FtpUser{Username: "mapped", Password: "secret"})
+		_ = store.SystemRegister(context.Background(), "BillSys1", "")
+
+		for _, tt := range []struct {
+			system    string
+			expStatus int
+		}{
+			{system: "BillSys1", expStatus: http.StatusCreated},
+			{system: "BillSys3", expStatus: http.StatusBadRequest},
+		} {
+			w := httptest.NewRecorder()
+			body := fmt.Sprintf(`{"id":"2","ftp_id":%d}`, user.ID)
+			env.systemPostWithVars(w, httptest.NewRequest("POST", "https://ftpsvc.dev.run/mappings/"+tt.system, strings.NewReader(body)), map[string]string{"system": tt.system})
+			if status := w.Result().StatusCode; status != tt.expStatus {
+				t.Errorf("%s: Expected status %d but received %d", tt.system, tt.expStatus, status)
+			}
+		}
+	})
+}
@@ -1,7 +1,10 @@
 package metrics
 
 import (
+	"database/sql"
+
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
@@ -18,6 +21,22 @@ const (
 	LoginStatusUserNotFound  = "username_not_found"
 )
 
+// Login Cache Results
+const (
+	LoginCacheHit  = "hit"
+	LoginCacheMiss = "miss"
+)
+
+// loginStatuses - the set of valid login status values
+var loginStatuses = map[string]bool{
+	LoginStatusSuccess:       true,
+	LoginStatusAuthFailure:   true,
+	LoginStatusServerError:   true,
+	LoginStatusBadPassword:   true,
+	LoginStatusUserPassBlank: true,
+	LoginStatusUserNotFound:  true,
+}
+
 var (
 	loginLabels = prometheus.Labels{"status": ""}
 	countErrors = promauto.NewCounter(
@@ -30,6 +49,16 @@ var (
 			Name: "ftpusersvc_logins_total",
 			Help: "The total number of login requests with a status to indicate success or an error message to indicate failure due to a problem with the supplied credentials"},
 		[]string{"status"})
+	countLoginCache = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ftpusersvc_login_cache_total",
+			Help: "The total number of logins answered from the login cache (hit) or looked up in the database (miss)"},
+		[]string{"result"})
+	countLoginRecordDropped = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ftpusersvc_login_records_dropped_total",
+			Help: "The total number of login attempts not written to the login history because too many were waiting to be written",
+		})
 )
 
 // IncError - increments the error counter by 1
@@ -37,8 +66,29 @@ func IncError() {
 	countErrors.Inc()
 }
 
+// IsLoginStatus - report whether status is one of the LoginStatus values
+func IsLoginStatus(status string) bool {
+	return loginStatuses[status]
+}
+
 // IncLoginTotals - increment the logins total counter with the provided label values
 func IncLoginTotals(status string) {
 	loginLabels["status"] = status
 	countLoginTotals.With(loginLabels).Inc()
 }
+
+// IncLoginCache - increment the login cache counter for result, LoginCacheHit or LoginCacheMiss
+func IncLoginCache(result string) {
+	countLoginCache.WithLabelValues(result).Inc()
+}
+
+// IncLoginRecordDropped - increment the counter of login attempts dropped before they were written
+func IncLoginRecordDropped() {
+	countLoginRecordDropped.Inc()
+}
+
+// RegisterDBStats - export the connection pool statistics of db as the go_sql_* metrics labelled with name
+// - open, in use, idle and max open connections are gauges, waits and closed connections are counters
+func RegisterDBStats(name string, db *sql.DB) error {
+	return prometheus.Register(collectors.NewDBStatsCollector(db, name))
+}
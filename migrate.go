@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/halt-joe/ftp-user-svc/data"
+)
+
+const migrateUsage = "usage: main migrate up|down|status"
+
+// migrate - run the migrate command against the DBCON database, returning the process exit code
+// - up applies every pending migration
+// - down reverts the most recently applied migration
+// - status lists every migration and when it was applied
+func migrate(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	db, err := data.NewDB(EnvVar("DBCON", dbConStr), data.DefaultOptions())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer db.Close()
+
+	err = runMigrate(db, args[0], os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	return 0
+}
+
+// runMigrate - perform the migrate command and write the outcome to out
+func runMigrate(db *data.Database, command string, out io.Writer) error {
+	switch command {
+	case "up":
+		migrated, err := db.MigrateUp(context.Background())
+		for _, m := range migrated {
+			fmt.Fprintf(out, "applied %04d %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(migrated) == 0 {
+			fmt.Fprintln(out, "schema is up to date")
+		}
+
+	case "down":
+		m, err := db.MigrateDown(context.Background())
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			fmt.Fprintln(out, "no migrations are applied")
+			return nil
+		}
+		fmt.Fprintf(out, "reverted %04d %s\n", m.Version, m.Name)
+
+	case "status":
+		status, err := db.MigrationStatus(context.Background())
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return w.Flush()
+
+	default:
+		return fmt.Errorf("unknown migrate command %s, %s", command, migrateUsage)
+	}
+
+	return nil
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/halt-joe/ftp-user-svc/apierror"
+	"github.com/halt-joe/ftp-user-svc/data"
 	"github.com/halt-joe/ftp-user-svc/handlers"
 	log "github.com/inconshreveable/log15"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -43,6 +44,9 @@ func logger(inner http.Handler, name string) http.Handler {
 		id := uuid.New()
 		ctx = context.WithValue(ctx, apierror.ContextKeyRequestID, id.String())
 
+		// reads after a write in the request go to the primary database rather than a replica
+		ctx = data.TrackWrites(ctx)
+
 		r = r.WithContext(ctx)
 
 		// get new response writer to capture response status
@@ -101,6 +105,14 @@ func Create(env *handlers.Env) *mux.Router {
 	makeRoute(router, "PUT", "/ftpusers/{id}", "FTPUserPut", sentryHandler.HandleFunc(env.IDPut))
 	makeRoute(router, "PATCH", "/ftpusers/{id}", "FTPUserPatch", sentryHandler.HandleFunc(env.IDPatch))
 	makeRoute(router, "GET", "/mappings/{system}", "MappingsSystemGet", sentryHandler.HandleFunc(env.SystemGet))
+	makeRoute(router, "GET", "/systems", "SystemsGet", sentryHandler.HandleFunc(env.SystemsGet))
+	makeRoute(router, "PUT", "/systems/{system}", "SystemPut", sentryHandler.HandleFunc(env.SystemPut))
+	makeRoute(router, "DELETE", "/systems/{system}", "SystemDelete", sentryHandler.HandleFunc(env.SystemDelete))
+	makeRoute(router, "POST", "/ftpusers/{id}/restore", "FTPUserRestore", sentryHandler.HandleFunc(env.IDRestore))
+	makeRoute(router, "GET", "/ftpusers/{id}/logins", "FTPUserLoginsGet", sentryHandler.HandleFunc(env.IDLoginsGet))
+	makeRoute(router, "GET", "/ftpusers/{id}/mappings", "FTPUserMappingsGet", sentryHandler.HandleFunc(env.IDMappingsGet))
+	makeRoute(router, "GET", "/logins", "LoginsGet", sentryHandler.HandleFunc(env.LoginsGet))
+	makeRoute(router, "GET", "/audit", "AuditGet", sentryHandler.HandleFunc(env.AuditGet))
 
 	return router
 }